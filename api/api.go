@@ -26,14 +26,15 @@ type baseResponse struct {
 
 // Web API handler
 type WebApi struct {
-	auth       *authLookup           // Authentication handler, if nil authentication will be disabled.
-	mux        *http.ServeMux        // HTTP Mux
-	handler    handler.IProxySpawner // Current proxy spawner
-	ctx        context.Context       // Context that cancels this and all WebSockets
-	cancelFunc context.CancelFunc    // Cancel this context
-	logger     *slog.Logger          // Can be nil
-	wsocks     []*wsApi              // Connected WebSockets
-	endpoints  []apiEndpoint         // Endpoint info for self documentation
+	auth       *authLookup             // Authentication handler, if nil authentication will be disabled.
+	mux        *http.ServeMux          // HTTP Mux
+	routes     *handler.SpawnerManager // Proxy spawners of every route
+	ctx        context.Context         // Context that cancels this and all WebSockets
+	cancelFunc context.CancelFunc      // Cancel this context
+	logger     *slog.Logger            // Can be nil
+	wsocks     []*wsApi                // Connected WebSockets
+	endpoints  []apiEndpoint           // Endpoint info for self documentation
+	caPems     map[string][]byte       // PEM CA certificates used for TLS interception by route name
 }
 
 // Adds a new auth key, with all permissions needed.
@@ -45,6 +46,16 @@ func (w *WebApi) AddAuth(key uint64, perms ...authPerms) error {
 	return w.auth.addKey(key, perms...)
 }
 
+// Sets the CA certificate of the default route served on /api/1/ca, this should be the PEM certificate clients need to install.
+func (w *WebApi) SetCaCertificate(certPem []byte) {
+	w.SetRouteCaCertificate(w.routes.GetDefaultRoute(), certPem)
+}
+
+// Sets the CA certificate of a route served on /api/1/ca?route=(name)
+func (w *WebApi) SetRouteCaCertificate(route string, certPem []byte) {
+	w.caPems[route] = certPem
+}
+
 func (wa *WebApi) homePage(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -88,18 +99,27 @@ func (w *WebApi) Close() {
 	}
 }
 
-// Creates a new WebApi instance.
+// Creates a new WebApi instance with one route, see NewWebApiWithRoutes.
 // If useAuth is false then GetNewAuth and AddAuth will both return errors.
 func NewWebApi(mux *http.ServeMux, useAuth bool, ph handler.IProxySpawner) *WebApi {
+	routes := handler.NewSpawnerManager()
+	routes.AddRoute(handler.DefaultRouteName, ph)
+	return NewWebApiWithRoutes(mux, useAuth, routes)
+}
+
+// Creates a new WebApi instance, requests pick a route with the 'route' query & use the default route without it.
+// If useAuth is false then GetNewAuth and AddAuth will both return errors.
+func NewWebApiWithRoutes(mux *http.ServeMux, useAuth bool, routes *handler.SpawnerManager) *WebApi {
 	wa := &WebApi{
 		mux:        mux,
-		handler:    ph,
+		routes:     routes,
 		logger:     slog.Default(),
 		ctx:        nil,
 		cancelFunc: nil,
 		wsocks:     make([]*wsApi, 0),
 		endpoints:  make([]apiEndpoint, 0),
 		auth:       nil,
+		caPems:     make(map[string][]byte),
 	}
 	if useAuth {
 		wa.auth = newAuthLookup()
@@ -113,16 +133,30 @@ func NewWebApi(mux *http.ServeMux, useAuth bool, ph handler.IProxySpawner) *WebA
 	// Write it in MD and convert that to HTML probably.
 	// For sure returns need to be documented
 	mux.HandleFunc("/", wa.homePage)
+	wa.addEndpoint("routes", 1, http.MethodGet, wa.epRoutes, AuthCanCheckStatus)
+	wa.documentEndpoint("routes", "Get status of every route, other endpoints take a 'route' query to pick one.", 1, "GET", int(AuthCanCheckStatus))
 	wa.addEndpoint("status", 1, http.MethodGet, wa.epStatus, AuthCanCheckStatus)
 	wa.documentEndpoint("status", "Get status of the Proxy Spawner", 1, "GET", int(AuthCanCheckStatus))
 	wa.addEndpoint("proxies", 1, http.MethodGet, wa.epProxyList, AuthCanCheckStatus)
 	wa.documentEndpoint("proxies", "Get status of all connected clients", 1, "GET", int(AuthCanCheckStatus))
 	wa.addEndpoint("inject", 1, http.MethodPost, wa.epInject, AuthCanInject)
 	wa.documentEndpoint("inject", "Inject data to a target, send JSON data to inject. (TODO: Better document)", 1, "POST", int(AuthCanInject))
+	wa.addEndpoint("server", 1, http.MethodPost, wa.epChangeServer, AuthCanChangeServer)
+	wa.documentEndpoint("server", "Move proxies to another server or change the server new proxies use, send JSON data.", 1, "POST", int(AuthCanChangeServer))
+	wa.addEndpoint("client", 1, http.MethodPost, wa.epChangeClient, AuthCanChangeClient)
+	wa.documentEndpoint("client", "Get a proxies resume token & give it the next new client, send JSON data.", 1, "POST", int(AuthCanChangeClient))
+	wa.addEndpoint("mirror", 1, http.MethodPost, wa.epMirror, AuthCanMirror)
+	wa.documentEndpoint("mirror", "Copy client traffic of proxies to a shadow server, send JSON data.", 1, "POST", int(AuthCanMirror))
+	wa.addEndpoint("listeners", 1, http.MethodGet, wa.epListeners, AuthCanCheckStatus)
+	wa.documentEndpoint("listeners", "Get status of the listeners.", 1, "GET", int(AuthCanCheckStatus))
+	wa.addEndpoint("listener", 1, http.MethodPost, wa.epListener, AuthCanListen)
+	wa.documentEndpoint("listener", "Start or stop a listener without restarting the proxy, send JSON data.", 1, "POST", int(AuthCanListen))
 	wa.addEndpoint("newkey", 1, http.MethodGet, wa.epGetKey, AuthCanMakeKeys)
 	wa.documentEndpoint("newkey", "Create a new key with your permissions.", 1, "GET", int(AuthCanMakeKeys))
 	wa.addEndpoint("keyinfo", 1, http.MethodGet, wa.epGetAuthValue) // Anyone can use this given they have a valid API key
 	wa.documentEndpoint("keyinfo", "Get info about this API key", 1, "GET", 0)
+	wa.addEndpoint("ca", 1, http.MethodGet, wa.epCaCert, AuthCanCheckStatus)
+	wa.documentEndpoint("ca", "Download the PEM CA certificate used for TLS interception.", 1, "GET", int(AuthCanCheckStatus))
 	wa.addEndpoint("socket", 2, http.MethodGet, wa.newWebSocket, AuthCanUseWebsocket)
 	wa.documentEndpoint("socket", "Create a new WebSocket (TODO: Document the rest of this)", 2, "GET", int(AuthCanUseWebsocket))
 	return wa
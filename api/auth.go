@@ -14,13 +14,17 @@ import (
 type authPerms int
 
 const (
-	AuthCanCheckStatus   authPerms = 1 << 0 // /api/1/status, /api/1/proxies
-	AuthCanClose         authPerms = 1 << 1 // api/1/close (Closing proxies) /api/1/socket (Requires authCanUseWebsocket)
-	AuthCanUseWebsocket  authPerms = 1 << 2 // /api/1/socket (Listening, not injecting or filtering)
-	AuthCanFilter        authPerms = 1 << 3 // /api/1/socket (Filtering, requires authCanUseWebsocket)
-	AuthCanInject        authPerms = 1 << 4 // /api/1/inject (Injecting) /api/1/socket (Requires authCanUseWebsocket)
-	AuthCanMakeKeys      authPerms = 1 << 5 // /api/1/key (Creating new keys) You can still only create keys with permissions matching your own, minus this one
-	AuthCanDuplicateKeys authPerms = 1 << 6 // /api/1/key (Creating new keys) Can create keys matching these permissions including AuthCanMakeKeys
+	AuthCanCheckStatus   authPerms = 1 << 0  // /api/1/status, /api/1/proxies, /api/1/socket health events (Requires authCanUseWebsocket)
+	AuthCanClose         authPerms = 1 << 1  // api/1/close (Closing proxies) /api/1/socket (Requires authCanUseWebsocket)
+	AuthCanUseWebsocket  authPerms = 1 << 2  // /api/1/socket (Listening, not injecting or filtering)
+	AuthCanFilter        authPerms = 1 << 3  // /api/1/socket (Filtering, requires authCanUseWebsocket)
+	AuthCanInject        authPerms = 1 << 4  // /api/1/inject (Injecting) /api/1/socket (Requires authCanUseWebsocket)
+	AuthCanMakeKeys      authPerms = 1 << 5  // /api/1/key (Creating new keys) You can still only create keys with permissions matching your own, minus this one
+	AuthCanDuplicateKeys authPerms = 1 << 6  // /api/1/key (Creating new keys) Can create keys matching these permissions including AuthCanMakeKeys
+	AuthCanChangeServer  authPerms = 1 << 7  // /api/1/server (Moving proxies to another server) /api/1/socket (Requires authCanUseWebsocket)
+	AuthCanChangeClient  authPerms = 1 << 8  // /api/1/client (Handing proxies to a new client & getting resume tokens)
+	AuthCanMirror        authPerms = 1 << 9  // /api/1/mirror (Copying client traffic to a shadow server)
+	AuthCanListen        authPerms = 1 << 10 // /api/1/listener (Starting & stopping listeners)
 
 	AuthAll            authPerms = 0xfffffffffffffff // All auth values
 	AuthAllButMakeKeys authPerms = 0xfffffffffffffdf // All auth values but make keys
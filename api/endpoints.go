@@ -3,10 +3,13 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"ezproxy/handler"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 )
 
 // Adds a new endpoint to the API, the endpoint will end up as /api/<version>/<name>, require the request be the 'method' specified and require 'perms' permissions if [authLookup] is enabled.
@@ -28,6 +31,26 @@ func (a *WebApi) addEndpoint(name string, version int, method string, handler ht
 	})
 }
 
+// Gets the spawner of the route named by the 'route' query, the default route if there isn't one.
+// If the route doesn't exist a 404 is written & ok is false.
+func (a *WebApi) getRoute(w http.ResponseWriter, r *http.Request) (ph handler.IProxySpawner, ok bool) {
+	ph, err := a.routes.GetRoute(r.URL.Query().Get("route"))
+	if err != nil {
+		a.logger.Debug("Request to unknown route", "Route", r.URL.Query().Get("route"))
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	return ph, true
+}
+
+// Gets the name of the route named by the 'route' query, the default route if there isn't one
+func (a *WebApi) getRouteName(r *http.Request) string {
+	if name := r.URL.Query().Get("route"); name != "" {
+		return name
+	}
+	return a.routes.GetDefaultRoute()
+}
+
 func (a *WebApi) documentEndpoint(name string, desc string, version int, method string, perms int) {
 	a.endpoints = append(a.endpoints, apiEndpoint{
 		Endpoint: name,
@@ -38,22 +61,93 @@ func (a *WebApi) documentEndpoint(name string, desc string, version int, method
 	})
 }
 
-// Status of a handler, used for /api/1/handler
-type handlerStatus struct {
-	ConnectionCount int    // Number of connections
+// Status of a route, used for /api/1/routes
+type routeStatus struct {
+	Name            string // Route name, used as the 'route' query
+	Default         bool   // Is this the route used without a 'route' query
 	Alive           bool   // Is the handler alive
-	BytesSent       uint64 // Number of bytes sent
-	ProxyAddress    string // Proxy address (IP):(PORT)
-	ServerAddress   string // Server address (IP):(PORT)
+	ConnectionCount int    // Number of connections
+	ProxyAddress    string // Proxy address (IP):(PORT), IPv6 addresses are bracketed
+	ServerAddress   string // Server address (IP):(PORT), IPv6 addresses are bracketed
+}
+
+func (a *WebApi) epRoutes(w http.ResponseWriter, r *http.Request) {
+	data := make([]routeStatus, 0)
+	def := a.routes.GetDefaultRoute()
+	for _, name := range a.routes.GetRouteNames() {
+		ph, err := a.routes.GetRoute(name)
+		if err != nil {
+			continue
+		}
+		data = append(data, routeStatus{
+			Name:            name,
+			Default:         name == def,
+			Alive:           ph.IsAlive(),
+			ConnectionCount: len(ph.GetAllProxies()),
+			ProxyAddress:    ph.GetProxyAddr().String(),
+			ServerAddress:   ph.GetServerAddr().String(),
+		})
+	}
+	a.logger.Debug("Sending []RouteStatus", "Count", len(data))
+	writeResponse(w, 200, data)
+}
+
+// Status of a handler, used for /api/1/status
+type handlerStatus struct {
+	Route           string           // Name of the route
+	ConnectionCount int              // Number of connections
+	Alive           bool             // Is the handler alive
+	BytesSent       uint64           // Number of bytes sent
+	ProxyAddress    string           // Proxy address (IP):(PORT), IPv6 addresses are bracketed
+	ServerAddress   string           // Server address (IP):(PORT), IPv6 addresses are bracketed
+	Upstreams       []upstreamStatus // Servers new proxies connect to, the first is ServerAddress
+	Strategy        string           // How upstreams are picked, empty if the route only has ServerAddress
+	Mirror          string           // Shadow server new proxies may be mirrored to, empty if there isn't one
+}
+
+// Status of a upstream, used for /api/1/status
+type upstreamStatus struct {
+	Address     string // (IP):(Port) of the upstream, IPv6 addresses are bracketed
+	Connections int    // Running proxies that started on this upstream
+	Healthy     bool   // Is the upstream picked for new proxies, always true without health checks
+	Error       string // Why the last health check failed, empty if it didn't
 }
 
 func (a *WebApi) epStatus(w http.ResponseWriter, r *http.Request) {
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
 	data := handlerStatus{
-		ConnectionCount: len(a.handler.GetAllProxies()),
-		Alive:           a.handler.IsAlive(),
-		BytesSent:       a.handler.GetBytesSent(),
-		ProxyAddress:    a.handler.GetProxyAddr().String(),
-		ServerAddress:   a.handler.GetServerAddr().String(),
+		Route:           a.getRouteName(r),
+		ConnectionCount: len(ph.GetAllProxies()),
+		Alive:           ph.IsAlive(),
+		BytesSent:       ph.GetBytesSent(),
+		ProxyAddress:    ph.GetProxyAddr().String(),
+		ServerAddress:   ph.GetServerAddr().String(),
+		Upstreams:       make([]upstreamStatus, 0),
+	}
+	if mm, ok := ph.(handler.IMirrorManager); ok {
+		if m := mm.GetMirror(); m != nil {
+			data.Mirror = m.Addr.String()
+		}
+	}
+	if up, ok := ph.(handler.IUpstreamPool); ok {
+		data.Strategy = up.GetUpstreamStrategy().String()
+		for _, v := range up.GetUpstreams() {
+			data.Upstreams = append(data.Upstreams, upstreamStatus{
+				Address:     v.Address.String(),
+				Connections: v.Connections,
+				Healthy:     v.Healthy,
+				Error:       v.Error,
+			})
+		}
+	} else {
+		data.Upstreams = append(data.Upstreams, upstreamStatus{
+			Address:     data.ServerAddress,
+			Connections: data.ConnectionCount,
+			Healthy:     true,
+		})
 	}
 	a.logger.Debug("Sending HandlerStatus")
 	writeResponse(w, 200, data)
@@ -61,30 +155,86 @@ func (a *WebApi) epStatus(w http.ResponseWriter, r *http.Request) {
 
 // Status of a proxy, used for /api/1/proxies
 type proxyStatus struct {
-	Id             int    // Proxy Id
-	Alive          bool   // Is the client alive
-	Address        string // (IP):(Port) of this client
-	Network        string // Network this proxy is connected on
-	BytesSent      uint64 // Number of bytes sent
-	LastContactAgo int64  // last contact ago in MS
+	Id             int               // Proxy Id
+	Alive          bool              // Is the client alive
+	Address        string            // (IP):(Port) of this client, IPv6 addresses are bracketed
+	ServerAddress  string            // (IP):(Port) of the server this client is connected to
+	Upstream       string            // (IP):(Port) of the upstream this client was given, ServerAddress differs if it was moved. Empty if the proxy doesn't track it
+	Network        string            // Network this proxy is connected on
+	BytesSent      uint64            // Number of bytes sent
+	LastContactAgo int64             // last contact ago in MS
+	Metadata       map[string]string // Extra connection info, such as "Sni", may be null
+	Mirror         *mirrorStatus     // Shadow server getting a copy of the client traffic, null if there isn't one
+}
+
+// Status of a proxies mirror, used for /api/1/proxies
+type mirrorStatus struct {
+	Address       string // (IP):(Port) of the shadow server
+	Active        bool   // Is it still copying, a mirror stops if the shadow server can't be reached or falls behind
+	BytesSent     uint64 // Bytes copied to the shadow server
+	BytesReceived uint64 // Bytes the shadow server replied with
+	Error         string // Why it stopped, empty while active
 }
 
 func (a *WebApi) epProxyList(w http.ResponseWriter, r *http.Request) {
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
 	data := make([]proxyStatus, 0)
-	for _, v := range a.handler.GetAllProxies() {
+	for _, v := range ph.GetAllProxies() {
+		var mirror *mirrorStatus
+		if m := getMirror(v); m != nil {
+			mirror = &mirrorStatus{
+				Address:       m.Address.String(),
+				Active:        m.Active,
+				BytesSent:     m.BytesSent,
+				BytesReceived: m.BytesReceived,
+				Error:         m.Error,
+			}
+		}
+		upstream := ""
+		if ut, ok := v.(handler.IUpstreamTracker); ok {
+			upstream = ut.GetUpstream().String()
+		}
+		var metadata map[string]string
+		if mp, ok := v.(handler.IMetadataProvider); ok {
+			metadata = mp.GetMetadata()
+		}
 		data = append(data, proxyStatus{
 			Id:             v.GetId(),
 			Alive:          v.IsAlive(),
 			Address:        v.GetClientAddr().String(),
+			ServerAddress:  v.GetServerAddr().String(),
+			Upstream:       upstream,
 			Network:        v.GetClientAddr().Network(),
 			BytesSent:      v.GetBytesSent(),
 			LastContactAgo: v.LastContactTimeAgo().Milliseconds(),
+			Metadata:       metadata,
+			Mirror:         mirror,
 		})
 	}
 	a.logger.Debug("Sending []ProxyStatus", "Count", len(data))
 	writeResponse(w, 200, data)
 }
 
+func (a *WebApi) epCaCert(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.getRoute(w, r); !ok {
+		return
+	}
+	caPem := a.caPems[a.getRouteName(r)]
+	if caPem == nil {
+		a.logger.Debug("CA certificate requested but TLS interception is not enabled")
+		writeResponse(w, http.StatusNotFound, "no CA certificate, TLS interception is not enabled")
+		return
+	}
+	a.logger.Debug("Sending CA certificate")
+	w.Header().Add("Content-Type", "application/x-pem-file")
+	w.Header().Add("Content-Disposition", "attachment; filename=\"ezproxy-ca.pem\"")
+	w.WriteHeader(200)
+	w.Write(caPem)
+}
+
 // Inject data, used for /api/1/inject
 type injectData struct {
 	Id       int    // Proxy ID, set to -1 for all
@@ -95,6 +245,10 @@ type injectData struct {
 
 func (a *WebApi) epInject(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		// Server error not API error
@@ -120,15 +274,15 @@ func (a *WebApi) epInject(w http.ResponseWriter, r *http.Request) {
 	if id.Id == -1 {
 		a.logger.Debug("Injecting data to all", "Data", id.Data, "ToClient", id.ToClient, "ToServer", id.ToServer)
 		if id.ToClient {
-			a.handler.SendToAllClients(id.Data)
+			ph.SendToAllClients(id.Data)
 		}
 		if id.ToServer {
-			a.handler.SendToAllServers(id.Data)
+			ph.SendToAllServers(id.Data)
 		}
 		writeResponse(w, 200, "")
 		return
 	}
-	px, err := a.handler.GetProxy(id.Id)
+	px, err := ph.GetProxy(id.Id)
 	if err != nil {
 		a.logger.Debug("Proxy not found to inject to", "Id", id.Id)
 		writeResponse(w, http.StatusNotFound, fmt.Sprintf("proxy not found: %v", err))
@@ -144,6 +298,316 @@ func (a *WebApi) epInject(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, 200, "")
 }
 
+const (
+	changeServerAll  int = -1 // Move every proxy
+	changeServerNone int = -2 // Don't move any proxies, only change the default
+)
+
+// Change server, used for /api/1/server
+type changeServerData struct {
+	Id         int    // Proxy ID, -1 for all or -2 for none
+	Address    string // New server, (IP):(Port) or "unix:(Path)" & "unixgram:(Path)" for unix sockets
+	SetDefault bool   // New proxies connect to it too, this replaces every upstream
+}
+
+// Result of changing servers
+type changeServerResult struct {
+	Changed []int          // IDs of proxies moved to the new server
+	Failed  map[int]string // IDs of proxies that couldn't be moved, with the error
+}
+
+// Moves proxies of ph to address & changes the default if setDefault is set, id is a proxy ID, changeServerAll or changeServerNone.
+// If this fails status is the HTTP status code to reply with.
+func (a *WebApi) changeServer(ph handler.IProxySpawner, id int, address string, setDefault bool) (result changeServerResult, status int, err error) {
+	if id == changeServerNone && !setDefault {
+		return result, http.StatusBadRequest, errors.New("nothing to change, Id is -2 and SetDefault is false")
+	}
+	addr, err := handler.ResolveAddr(address)
+	if err != nil {
+		return result, http.StatusBadRequest, fmt.Errorf("invalid address: %v", err)
+	}
+	var targets []handler.IProxyContainer
+	switch id {
+	case changeServerAll:
+		targets = ph.GetAllProxies()
+	case changeServerNone:
+	default:
+		px, err := ph.GetProxy(id)
+		if err != nil {
+			return result, http.StatusNotFound, fmt.Errorf("proxy not found: %v", err)
+		}
+		targets = append(targets, px)
+	}
+	if setDefault {
+		ss, ok := ph.(handler.IServerSetter)
+		if !ok {
+			return result, http.StatusNotImplemented, fmt.Errorf("can't change the default server: %w", handler.ErrUnsupported)
+		}
+		if err := ss.SetServerAddr(addr); err != nil {
+			return result, http.StatusBadRequest, err
+		}
+	}
+	result.Changed = make([]int, 0)
+	result.Failed = make(map[int]string)
+	for _, px := range targets {
+		sc, ok := px.(handler.IServerChanger)
+		if !ok {
+			result.Failed[px.GetId()] = fmt.Errorf("can't change server: %w", handler.ErrUnsupported).Error()
+			continue
+		}
+		if err := sc.ChangeServer(addr); err != nil {
+			a.logger.Debug("Failed to change server", "Id", px.GetId(), "Error", err.Error())
+			result.Failed[px.GetId()] = err.Error()
+			continue
+		}
+		result.Changed = append(result.Changed, px.GetId())
+	}
+	a.logger.Info("Changed server", "Address", addr.String(), "SetDefault", setDefault, "Changed", result.Changed, "Failed", len(result.Failed))
+	if id >= 0 && len(result.Failed) != 0 {
+		return result, http.StatusBadGateway, errors.New(result.Failed[id])
+	}
+	return result, http.StatusOK, nil
+}
+
+func (a *WebApi) epChangeServer(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		// Server error not API error
+		a.logger.Warn("Failed to read data from request", "Error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	cs := &changeServerData{}
+	if err := json.Unmarshal(data, cs); err != nil {
+		a.logger.Debug("Got invalid JSON data", "Error", err.Error())
+		writeResponse(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+	result, status, err := a.changeServer(ph, cs.Id, cs.Address, cs.SetDefault)
+	if err != nil {
+		writeResponse(w, status, err.Error())
+		return
+	}
+	writeResponse(w, status, result)
+}
+
+// Time a proxy waits for a new client if the request doesn't say
+const changeClientDefaultTimeout = time.Second * 30
+
+// Change client, used for /api/1/client
+type changeClientData struct {
+	Id      int    // Proxy ID
+	Expect  bool   // Give the next connection from Host to the proxy
+	Host    string // IP the new client connects from, "" for anyone
+	Timeout int    // MS to wait for the new client, 0 for 30 seconds
+	Role    int    // What the new client does, 0 to replace the old client, 1 to join as a writer, 2 to join as an observer
+}
+
+// Result of changing client
+type changeClientResult struct {
+	ResumeToken string // Token a client can send to take over the proxy, if the listener allows it
+}
+
+func (a *WebApi) epChangeClient(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		// Server error not API error
+		a.logger.Warn("Failed to read data from request", "Error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	cc := &changeClientData{}
+	if err := json.Unmarshal(data, cc); err != nil {
+		a.logger.Debug("Got invalid JSON data", "Error", err.Error())
+		writeResponse(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+	px, err := ph.GetProxy(cc.Id)
+	if err != nil {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("proxy not found: %v", err))
+		return
+	}
+	rp, ok := px.(handler.IResumable)
+	if !ok {
+		writeResponse(w, http.StatusNotImplemented, fmt.Sprintf("can't resume proxy: %v", handler.ErrUnsupported))
+		return
+	}
+	if cc.Expect {
+		ce, ok := ph.(handler.IClientExpecter)
+		if !ok {
+			writeResponse(w, http.StatusNotImplemented, fmt.Sprintf("can't expect client: %v", handler.ErrUnsupported))
+			return
+		}
+		timeout := time.Duration(cc.Timeout) * time.Millisecond
+		if cc.Timeout <= 0 {
+			timeout = changeClientDefaultTimeout
+		}
+		if err := ce.ExpectClient(cc.Id, cc.Host, handler.ClientRole(cc.Role), timeout); err != nil {
+			writeResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	a.logger.Debug("Sending resume token", "Id", cc.Id, "Expect", cc.Expect, "Role", handler.ClientRole(cc.Role).String())
+	writeResponse(w, http.StatusOK, changeClientResult{ResumeToken: rp.GetResumeToken()})
+}
+
+// Mirror new proxies instead of one, used for /api/1/mirror
+const mirrorNew int = -1
+
+// Mirror, used for /api/1/mirror
+type mirrorData struct {
+	Id      int      // Proxy ID, -1 for new proxies
+	Address string   // Shadow server, (IP):(Port) or "unix:(Path)" & "unixgram:(Path)" for unix sockets. "" stops mirroring
+	Policy  []string // Packets to copy, "forwarded", "dropped" & "injected". Empty for forwarded & injected
+	Record  bool     // Send the shadows replies to WebSockets flagged as mirrored, if not they are discarded
+	Sample  float64  // Fraction of new proxies to mirror when Id is -1, 0 for all of them
+}
+
+func (a *WebApi) epMirror(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		// Server error not API error
+		a.logger.Warn("Failed to read data from request", "Error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	md := &mirrorData{}
+	if err := json.Unmarshal(data, md); err != nil {
+		a.logger.Debug("Got invalid JSON data", "Error", err.Error())
+		writeResponse(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+	mm, ok := ph.(handler.IMirrorManager)
+	if !ok {
+		writeResponse(w, http.StatusNotImplemented, fmt.Sprintf("can't mirror: %v", handler.ErrUnsupported))
+		return
+	}
+	var m *handler.Mirror
+	if md.Address != "" {
+		addr, err := handler.ResolveAddr(md.Address)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid address: %v", err))
+			return
+		}
+		policy, err := handler.ParseMirrorPolicy(md.Policy)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		m = &handler.Mirror{Addr: addr, Policy: policy, Record: md.Record, Sample: md.Sample}
+	}
+	if md.Id == mirrorNew {
+		err = mm.SetMirror(m)
+	} else if _, err = ph.GetProxy(md.Id); err != nil {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("proxy not found: %v", err))
+		return
+	} else {
+		err = mm.MirrorProxy(md.Id, m)
+	}
+	if errors.Is(err, handler.ErrUnsupported) {
+		writeResponse(w, http.StatusNotImplemented, err.Error())
+		return
+	} else if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.logger.Info("Changed mirror", "Id", md.Id, "Address", md.Address, "Policy", md.Policy, "Record", md.Record)
+	writeResponse(w, http.StatusOK, "")
+}
+
+// Status of a listener, used for /api/1/listeners
+type listenerStatus struct {
+	Name    string // Listener name
+	Address string // Address the listener binds, (IP):(PORT). IPv6 addresses are bracketed
+	Running bool   // Is it accepting new clients
+	Error   string // Why it last stopped, empty if it was stopped or never ran
+}
+
+func (a *WebApi) epListeners(w http.ResponseWriter, r *http.Request) {
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
+	lm, ok := ph.(handler.IListenerManager)
+	if !ok {
+		writeResponse(w, http.StatusNotImplemented, fmt.Sprintf("can't get listeners: %v", handler.ErrUnsupported))
+		return
+	}
+	data := make([]listenerStatus, 0)
+	for _, v := range lm.GetListeners() {
+		data = append(data, listenerStatus{
+			Name:    v.Name,
+			Address: v.Address.String(),
+			Running: v.Running,
+			Error:   v.Error,
+		})
+	}
+	a.logger.Debug("Sending []ListenerStatus", "Count", len(data))
+	writeResponse(w, 200, data)
+}
+
+// Listener change, used for /api/1/listener
+type listenerData struct {
+	Name    string // Listener to change
+	Running bool   // Start the listener if true, stop it if false
+}
+
+func (a *WebApi) epListener(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		// Server error not API error
+		a.logger.Warn("Failed to read data from request", "Error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ld := &listenerData{}
+	if err := json.Unmarshal(data, ld); err != nil {
+		a.logger.Debug("Got invalid JSON data", "Error", err.Error())
+		writeResponse(w, http.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+	lm, ok := ph.(handler.IListenerManager)
+	if !ok {
+		writeResponse(w, http.StatusNotImplemented, fmt.Sprintf("can't change listener: %v", handler.ErrUnsupported))
+		return
+	}
+	if !slices.ContainsFunc(lm.GetListeners(), func(l handler.ListenerStatus) bool { return l.Name == ld.Name }) {
+		writeResponse(w, http.StatusNotFound, fmt.Sprintf("listener '%s' not found", ld.Name))
+		return
+	}
+	if ld.Running {
+		err = lm.StartListener(ld.Name)
+	} else {
+		err = lm.StopListener(ld.Name)
+	}
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.logger.Info("Changed listener", "Route", a.getRouteName(r), "Name", ld.Name, "Running", ld.Running)
+	writeResponse(w, http.StatusOK, "")
+}
+
 func isValidCreationPerm(currentValue int, userPerms int, desiredPerms int, perm authPerms) (int, bool) {
 	// First we check if we even care about this one
 	if !checkPermission(desiredPerms, perm) {
@@ -180,6 +644,22 @@ func createNewPerms(userPerms int, desiredPerms int) (value int, err error) {
 	if !ok {
 		return 0, errors.New("CanInject")
 	}
+	value, ok = isValidCreationPerm(value, userPerms, desiredPerms, AuthCanChangeServer)
+	if !ok {
+		return 0, errors.New("CanChangeServer")
+	}
+	value, ok = isValidCreationPerm(value, userPerms, desiredPerms, AuthCanChangeClient)
+	if !ok {
+		return 0, errors.New("CanChangeClient")
+	}
+	value, ok = isValidCreationPerm(value, userPerms, desiredPerms, AuthCanMirror)
+	if !ok {
+		return 0, errors.New("CanMirror")
+	}
+	value, ok = isValidCreationPerm(value, userPerms, desiredPerms, AuthCanListen)
+	if !ok {
+		return 0, errors.New("CanListen")
+	}
 	// We can only create a new key with CanMakeKeys if we have AuthCanDuplicateKeys
 	if checkPermission(desiredPerms, AuthCanMakeKeys) {
 		if !checkPermission(userPerms, AuthCanDuplicateKeys) {
@@ -254,6 +734,10 @@ type authValue struct {
 	CanInject        bool // AuthCanInject
 	CanMakeKeys      bool // AuthCanMakeKeys
 	CanDuplicateKeys bool // AuthCanDuplicateKeys
+	CanChangeServer  bool // AuthCanChangeServer
+	CanChangeClient  bool // AuthCanChangeClient
+	CanMirror        bool // AuthCanMirror
+	CanListen        bool // AuthCanListen
 	Admin            bool // AuthAll
 }
 
@@ -276,6 +760,10 @@ func (a *WebApi) epGetAuthValue(w http.ResponseWriter, r *http.Request) {
 		CanInject:        checkPermission(value, AuthCanInject),
 		CanMakeKeys:      checkPermission(value, AuthCanMakeKeys),
 		CanDuplicateKeys: checkPermission(value, AuthCanDuplicateKeys),
+		CanChangeServer:  checkPermission(value, AuthCanChangeServer),
+		CanChangeClient:  checkPermission(value, AuthCanChangeClient),
+		CanMirror:        checkPermission(value, AuthCanMirror),
+		CanListen:        checkPermission(value, AuthCanListen),
 		Admin:            value == int(AuthAll),
 	})
 }
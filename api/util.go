@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"ezproxy/handler"
 	"net/http"
 )
 
@@ -20,3 +21,11 @@ func writeResponse(w http.ResponseWriter, status int, data interface{}) error {
 	_, err = w.Write(jdata)
 	return err
 }
+
+// Gets the state of the proxies mirror, nil if there isn't one or the proxy can't be mirrored
+func getMirror(pc handler.IProxyContainer) *handler.MirrorStatus {
+	if mp, ok := pc.(handler.IMirrorable); ok {
+		return mp.GetMirror()
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ type wsFilterValue int
 const (
 	wsServerError  wsServerTypes = -1 // Should never be received, used as a internal nil
 	wsServerPacket wsServerTypes = 1  // A packet
+	wsServerHealth wsServerTypes = 2  // A upstream became healthy or unhealthy
 
 	wsFilterDrop  wsFilterValue = -1 // Wait for a action
 	wsFilterWait  wsFilterValue = 0  // Drop the packet
@@ -27,12 +28,14 @@ const (
 // WebSocket connection
 type wsApi struct {
 	parent        *WebApi
+	handler       handler.IProxySpawner // Spawner of the route picked with the 'route' query
 	ws            *websocket.Conn
 	ctx           context.Context
 	cancel        context.CancelFunc
 	canInject     bool
 	canFilter     bool
 	canClose      bool
+	canServer     bool
 	filterMap     map[int]wsFilterValue
 	filterChan    chan struct{}
 	pktIdLock     sync.Mutex
@@ -43,6 +46,13 @@ type wsApi struct {
 
 	recvChan   <-chan handler.PacketChanData
 	recvCancel context.CancelFunc
+	healthChan <-chan handler.UpstreamHealthEvent // nil unless health events were asked for
+}
+
+type wsHealthEvent struct {
+	Address string // (IP):(Port) of the upstream
+	Healthy bool   // Is the upstream picked for new proxies
+	Error   string // Why the last health check failed, empty when healthy
 }
 
 type wsPacket struct {
@@ -52,7 +62,7 @@ type wsPacket struct {
 	Source  string           // Source of this packet
 	Dest    string           // Destination of this packet
 	Data    []byte           // Packet data
-	Flags   handler.CapFlags // Flags, any CapFlag_*, if CapFlag_Inject is set this packet cannot be filtered.
+	Flags   handler.CapFlags // Flags, any CapFlag_*, if CapFlag_Inject or CapFlag_Mirrored is set this packet cannot be filtered.
 }
 
 type wsServerMsg struct {
@@ -103,6 +113,12 @@ func (w *wsApi) sendPacket(pkt *wsPacket) error {
 	return w._sendRaw(200, wsServerPacket, pkt)
 }
 
+// Sends a upstream health change over the websocket
+func (w *wsApi) sendHealth(event *wsHealthEvent) error {
+	w.parent.logger.Debug("Sending health event to WebSocket", "Upstream", event.Address, "Healthy", event.Healthy)
+	return w._sendRaw(200, wsServerHealth, event)
+}
+
 // Sends a error on the websocket
 func (w *wsApi) sendError(status int, msg string) error {
 	w.parent.logger.Debug("Sending error", "Status", status, "Message", msg)
@@ -123,7 +139,7 @@ func (w *wsApi) recv() {
 		select {
 		case pkt := <-w.recvChan:
 			// This should only ever be a open channel if the callback wasn't set.
-			px, err := w.parent.handler.GetProxy(pkt.ProxyId)
+			px, err := w.handler.GetProxy(pkt.ProxyId)
 			if err != nil {
 				w.parent.logger.Warn("Got packet from recvChan with proxy ID that lead to a non existent proxy", "Id", pkt.ProxyId)
 				continue
@@ -135,6 +151,17 @@ func (w *wsApi) recv() {
 	}
 }
 
+// Sends health events until the channel closes with the context
+func (w *wsApi) recvHealth() {
+	for event := range w.healthChan {
+		w.sendHealth(&wsHealthEvent{
+			Address: event.Address.String(),
+			Healthy: event.Healthy,
+			Error:   event.Error,
+		})
+	}
+}
+
 // listen for data, stops when the context is done
 func (w *wsApi) listen() error {
 	for {
@@ -186,6 +213,10 @@ func (w *wsApi) sendPkt(addId bool, data []byte, flags handler.CapFlags, p handl
 		// Client => Server
 		pkt.Source = p.GetClientAddr().String()
 		pkt.Dest = p.GetServerAddr().String()
+	} else if m := getMirror(p); flags.IsMirrored() && m != nil {
+		// Shadow server => Proxy
+		pkt.Source = m.Address.String()
+		pkt.Dest = p.GetClientAddr().String()
 	} else {
 		// Server => Client
 		pkt.Source = p.GetServerAddr().String()
@@ -262,13 +293,19 @@ func (w *wsApi) handleSend(data []byte, flags handler.CapFlags, p handler.IProxy
 }
 
 func (a *WebApi) newWebSocket(w http.ResponseWriter, r *http.Request) {
+	ph, ok := a.getRoute(w, r)
+	if !ok {
+		return
+	}
 	ctx, cancel := context.WithCancel(a.ctx)
 	ws := &wsApi{
 		parent:        a,
+		handler:       ph,
 		ws:            nil,
 		canInject:     false,
 		canFilter:     false,
 		canClose:      false,
+		canServer:     false,
 		defaultAction: wsFilterAllow,
 		networkFilter: "",
 		filterChan:    make(chan struct{}),
@@ -309,6 +346,34 @@ func (a *WebApi) newWebSocket(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if qr.Has("server") {
+		if checkPermission(val, AuthCanChangeServer) {
+			ws.canServer = true
+			a.logger.Debug("WebSocket can change server")
+		} else {
+			a.logger.Debug("Missing permissions for 'server' websocket not ok")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("missing permissions for 'server'"))
+			return
+		}
+	}
+	if qr.Has("health") {
+		if !checkPermission(val, AuthCanCheckStatus) {
+			a.logger.Debug("Missing permissions for 'health' websocket not ok")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("missing permissions for 'health'"))
+			return
+		}
+		hc, ok := ph.(handler.IHealthChecker)
+		if !ok {
+			a.logger.Debug("Route can't check health, 'health' websocket not ok")
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte("route can't check health"))
+			return
+		}
+		a.logger.Debug("WebSocket gets health events")
+		ws.healthChan = hc.GetHealthChan(ws.ctx)
+	}
 	if qr.Has("inject") {
 		if checkPermission(val, AuthCanInject) {
 			ws.canInject = true
@@ -352,7 +417,7 @@ func (a *WebApi) newWebSocket(w http.ResponseWriter, r *http.Request) {
 	// If we can filter we need to need to set the filter callback, if we don't need to filter we can just get a recvChan
 	// if the SendCallback is already set we can just ignore it.
 	if ws.canFilter {
-		err := a.handler.TrySetFilterCallback(ws.handleSend, ws.ctx)
+		err := ph.TrySetFilterCallback(ws.handleSend, ws.ctx)
 		if err != nil {
 			a.logger.Info("Attempted to set the sendFilter callback failed", "Error", err.Error())
 			w.WriteHeader(http.StatusConflict)
@@ -361,7 +426,7 @@ func (a *WebApi) newWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// I don't think we need this channel.
-		x, _, z := a.handler.GetRecvChan(a.ctx)
+		x, _, z := ph.GetRecvChan(a.ctx)
 		ws.recvCancel = z
 		ws.recvChan = x
 	}
@@ -382,6 +447,9 @@ func (a *WebApi) newWebSocket(w http.ResponseWriter, r *http.Request) {
 	if ws.recvChan != nil {
 		go ws.recv()
 	}
+	if ws.healthChan != nil {
+		go ws.recvHealth()
+	}
 	ws.listen()
 	a.logger.Debug("WebSocket closing, removing callbacks and filter")
 }
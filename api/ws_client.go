@@ -15,6 +15,7 @@ const (
 	wsReqInject wsReqType = 1
 	wsReqClose  wsReqType = 2
 	wsReqFilter wsReqType = 3
+	wsReqServer wsReqType = 4
 
 	wsTargetAll int = -1 // Target all proxies
 
@@ -22,13 +23,15 @@ const (
 	wsInjToServer uint64 = 1 << 1 // Server bit
 
 	wsFilterShouldSend uint64 = 1 << 0 // Send/Drop bit (Clear: Drop)
+
+	wsServerSetDefault uint64 = 1 << 0 // New proxies use the server too
 )
 
 type wsClientMsg struct {
 	Type   wsReqType
-	Target int    // Inject, Close: Target proxy, Filter: Target proxy
-	Data   []byte // Inject: Inject data
-	Extra  uint64 // Inject: 0, Send to Client. 1, Send to Server. Filter: 0, Drop/Send (0/1)
+	Target int    // Inject, Close: Target proxy, Filter: Target proxy, Server: Target proxy, -1 for all or -2 for none
+	Data   []byte // Inject: Inject data, Server: New server address
+	Extra  uint64 // Inject: 0, Send to Client. 1, Send to Server. Filter: 0, Drop/Send (0/1). Server: 0, Set default
 }
 
 func (w *wsApi) handleClientAction(t websocket.MessageType, data []byte) {
@@ -61,15 +64,15 @@ func (w *wsApi) handleClientAction(t websocket.MessageType, data []byte) {
 		if msg.Target == wsTargetAll {
 			// Send to everyone
 			if toServer {
-				w.parent.handler.SendToAllServers(msg.Data)
+				w.handler.SendToAllServers(msg.Data)
 			}
 			if toClient {
-				w.parent.handler.SendToAllClients(msg.Data)
+				w.handler.SendToAllClients(msg.Data)
 			}
 			return
 		}
 		// Send to a target proxy
-		px, err := w.parent.handler.GetProxy(msg.Target)
+		px, err := w.handler.GetProxy(msg.Target)
 		if err != nil {
 			w.parent.logger.Debug("Proxy not found", "Id", msg.Target)
 			w.sendError(http.StatusNotFound, fmt.Sprintf("proxy not found: %v", err))
@@ -90,12 +93,12 @@ func (w *wsApi) handleClientAction(t websocket.MessageType, data []byte) {
 		}
 		if msg.Target == wsTargetAll {
 			w.parent.logger.Debug("Closing all proxies")
-			for _, v := range w.parent.handler.GetAllProxies() {
+			for _, v := range w.handler.GetAllProxies() {
 				v.Cancel(handler.ErrProxyClosedOk)
 			}
 			return
 		}
-		px, err := w.parent.handler.GetProxy(msg.Target)
+		px, err := w.handler.GetProxy(msg.Target)
 		if err != nil {
 			w.sendError(http.StatusNotFound, fmt.Sprintf("proxy not found: %v", err))
 			return
@@ -125,6 +128,19 @@ func (w *wsApi) handleClientAction(t websocket.MessageType, data []byte) {
 			w.filterMap[msg.Target] = wsFilterAllow
 		}
 		w.filterChan <- struct{}{}
+	case wsReqServer:
+		if !w.canServer {
+			w.sendError(http.StatusForbidden, "Missing permissions to change server")
+			return
+		}
+		result, status, err := w.parent.changeServer(w.handler, msg.Target, string(msg.Data), msg.Extra&wsServerSetDefault != 0)
+		if err != nil {
+			w.sendError(status, err.Error())
+			return
+		}
+		for id, e := range result.Failed {
+			w.sendError(http.StatusBadGateway, fmt.Sprintf("failed to change server of proxy %d: %s", id, e))
+		}
 	default:
 		w.sendError(http.StatusBadRequest, "Unknown Type")
 		return
@@ -33,6 +33,8 @@ const (
 
 type luaBindings struct {
 	spawner       *luaSpawner
+	routes        *handler.SpawnerManager
+	routeSpawners map[string]*luaSpawner // Spawners returned by get_route, by route name
 	executionMode LuaRunModes
 	logger        *slog.Logger
 	path          string
@@ -40,6 +42,49 @@ type luaBindings struct {
 
 func (b *luaBindings) Close() {
 	b.spawner.Close()
+	for _, v := range b.routeSpawners {
+		v.Close()
+	}
+}
+
+// Gets the spawner of a route, "" is the route the bindings were made for
+func (b *luaBindings) bindGetRoute(l *lua.LState) int {
+	if l.GetTop() != 1 {
+		l.RaiseError(fmt.Sprintf("Expected 1 argument, got %d", l.GetTop()))
+		return 0
+	}
+	name := l.CheckString(1)
+	if name == "" {
+		l.Push(b.spawner.toTable(l))
+		return 1
+	}
+	if s, ok := b.routeSpawners[name]; ok {
+		l.Push(s.toTable(l))
+		return 1
+	}
+	ps, err := b.routes.GetRoute(name)
+	if err != nil {
+		l.RaiseError(err.Error())
+		return 0
+	}
+	s := &luaSpawner{spawner: ps, parent: b}
+	b.routeSpawners[name] = s
+	l.Push(s.toTable(l))
+	return 1
+}
+
+// Gets the name of every route
+func (b *luaBindings) bindGetRoutes(l *lua.LState) int {
+	if l.GetTop() != 0 {
+		l.RaiseError(fmt.Sprintf("Expected 0 arguments, got %d", l.GetTop()))
+		return 0
+	}
+	tb := l.NewTable()
+	for _, name := range b.routes.GetRouteNames() {
+		tb.Append(lua.LString(name))
+	}
+	l.Push(tb)
+	return 1
 }
 
 func (b *luaBindings) bindSleep(l *lua.LState) int {
@@ -132,6 +177,8 @@ func (b *luaBindings) run(path string) {
 	}
 	st.SetGlobal("sleep", st.NewFunction(b.bindSleep))
 	st.SetGlobal("log", st.NewFunction(b.bindLog))
+	st.SetGlobal("get_route", st.NewFunction(b.bindGetRoute))
+	st.SetGlobal("get_routes", st.NewFunction(b.bindGetRoutes))
 	st.SetGlobal("LEVEL_DEBUG", lua.LNumber(0))
 	st.SetGlobal("LEVEL_INFO", lua.LNumber(1))
 	st.SetGlobal("LEVEL_WARN", lua.LNumber(2))
@@ -148,7 +195,20 @@ func (b *luaBindings) run(path string) {
 	return
 }
 
+// Runs the lua file at path for one spawner, see NewLuaBindingWithRoutes
 func NewLuaBindingFromFile(spawner handler.IProxySpawner, path string, mode LuaRunModes) error {
+	routes := handler.NewSpawnerManager()
+	routes.AddRoute(handler.DefaultRouteName, spawner)
+	return NewLuaBindingWithRoutes(routes, "", path, mode)
+}
+
+// Runs the lua file at path, EzpMain & the callbacks use the spawner of route ("" for the default route).
+// Other routes can be used with get_route.
+func NewLuaBindingWithRoutes(routes *handler.SpawnerManager, route string, path string, mode LuaRunModes) error {
+	spawner, err := routes.GetRoute(route)
+	if err != nil {
+		return err
+	}
 	bindings := luaBindings{
 		spawner: &luaSpawner{
 			spawner: spawner,
@@ -157,6 +217,8 @@ func NewLuaBindingFromFile(spawner handler.IProxySpawner, path string, mode LuaR
 			cancel:  nil,
 			parent:  nil,
 		},
+		routes:        routes,
+		routeSpawners: make(map[string]*luaSpawner),
 		executionMode: mode,
 		logger:        slog.Default(),
 		path:          path,
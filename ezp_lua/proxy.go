@@ -25,6 +25,8 @@ func (p *luaProxy) ToTable(l *lua.LState) *lua.LTable {
 	addFunction(l, tb, "get_client_addr", p.bindGetClientAddr, 0)
 	addFunction(l, tb, "get_bytes_sent", p.bindGetBytesSent, 0)
 	addFunction(l, tb, "get_last_contact", p.bindGetLastContact, 0)
+	addFunction(l, tb, "change_server", p.bindChangeServer, 1)
+	addFunction(l, tb, "get_resume_token", p.bindGetResumeToken, 0)
 	return tb
 }
 
@@ -46,6 +48,33 @@ func (p *luaProxy) bindSendToServer(l *lua.LState) int {
 	return 0
 }
 
+func (p *luaProxy) bindChangeServer(l *lua.LState) int {
+	addr, err := handler.ResolveAddr(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, err.Error())
+		return 0
+	}
+	sc, ok := p.px.(handler.IServerChanger)
+	if !ok {
+		l.RaiseError(fmt.Sprintf("Failed to change server: %s", handler.ErrUnsupported.Error()))
+		return 0
+	}
+	if err := sc.ChangeServer(addr); err != nil {
+		l.RaiseError(fmt.Sprintf("Failed to change server: %s", err.Error()))
+	}
+	return 0
+}
+
+func (p *luaProxy) bindGetResumeToken(l *lua.LState) int {
+	rp, ok := p.px.(handler.IResumable)
+	if !ok {
+		l.RaiseError(fmt.Sprintf("Failed to get resume token: %s", handler.ErrUnsupported.Error()))
+		return 0
+	}
+	l.Push(lua.LString(rp.GetResumeToken()))
+	return 1
+}
+
 func (p *luaProxy) bindGetId(l *lua.LState) int {
 	n := p.px.GetId()
 	l.Push(lua.LNumber(n))
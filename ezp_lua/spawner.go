@@ -7,6 +7,7 @@ import (
 	"context"
 	"ezproxy/handler"
 	"fmt"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -43,6 +44,9 @@ func (s *luaSpawner) toTable(l *lua.LState) *lua.LTable {
 	addFunction(l, table, "get_bytes_sent", s.bindGetBytesSent, 0)
 	addFunction(l, table, "get_proxy_count", s.bindGetProxyCount, 0)
 	addFunction(l, table, "get_proxy", s.bindGetProxy, 1)
+	addFunction(l, table, "set_server_address", s.bindSetServerAddress, 1)
+	addFunction(l, table, "change_server", s.bindChangeServer, 2)
+	addFunction(l, table, "expect_client", s.bindExpectClient, 4)
 	return table
 }
 
@@ -127,6 +131,82 @@ func (s *luaSpawner) bindGetServerAddress(l *lua.LState) int {
 	return 1
 }
 
+func (s *luaSpawner) bindSetServerAddress(l *lua.LState) int {
+	addr, err := handler.ResolveAddr(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, err.Error())
+		return 0
+	}
+	ss, ok := s.spawner.(handler.IServerSetter)
+	if !ok {
+		l.RaiseError(fmt.Sprintf("Failed to set server: %s", handler.ErrUnsupported.Error()))
+		return 0
+	}
+	if err := ss.SetServerAddr(addr); err != nil {
+		l.RaiseError(err.Error())
+	}
+	return 0
+}
+
+func (s *luaSpawner) bindChangeServer(l *lua.LState) int {
+	id := l.CheckInt(1)
+	addr, err := handler.ResolveAddr(l.CheckString(2))
+	if err != nil {
+		l.ArgError(2, err.Error())
+		return 0
+	}
+	if id != -1 {
+		px, err := s.spawner.GetProxy(id)
+		if err != nil {
+			l.RaiseError(err.Error())
+			return 0
+		}
+		sc, ok := px.(handler.IServerChanger)
+		if !ok {
+			l.RaiseError(fmt.Sprintf("Failed to change server: %s", handler.ErrUnsupported.Error()))
+			return 0
+		}
+		if err := sc.ChangeServer(addr); err != nil {
+			l.RaiseError(fmt.Sprintf("Failed to change server: %s", err.Error()))
+		}
+		return 0
+	}
+	failed := 0
+	for _, px := range s.spawner.GetAllProxies() {
+		if sc, ok := px.(handler.IServerChanger); !ok || sc.ChangeServer(addr) != nil {
+			failed++
+		}
+	}
+	if failed != 0 {
+		l.RaiseError(fmt.Sprintf("Failed to change server of %d proxies", failed))
+	}
+	return 0
+}
+
+func (s *luaSpawner) bindExpectClient(l *lua.LState) int {
+	id := l.CheckInt(1)
+	host := l.CheckString(2)
+	timeout := l.CheckInt(3)
+	if timeout <= 0 {
+		l.ArgError(3, "Must be positive")
+		return 0
+	}
+	role, err := handler.ParseClientRole(l.CheckString(4))
+	if err != nil {
+		l.ArgError(4, err.Error())
+		return 0
+	}
+	ce, ok := s.spawner.(handler.IClientExpecter)
+	if !ok {
+		l.RaiseError(fmt.Sprintf("Failed to expect client: %s", handler.ErrUnsupported.Error()))
+		return 0
+	}
+	if err := ce.ExpectClient(id, host, role, time.Duration(timeout)*time.Millisecond); err != nil {
+		l.RaiseError(err.Error())
+	}
+	return 0
+}
+
 func (s *luaSpawner) bindGetProxyAddress(l *lua.LState) int {
 	l.Push(lua.LString(s.spawner.GetProxyAddr().String()))
 	return 1
@@ -6,6 +6,8 @@ package ezp_lua
 import (
 	"ezproxy/handler"
 	"fmt"
+	"net"
+	"strconv"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -14,14 +16,31 @@ func packetDataToTable(l *lua.LState, data *handler.PacketChanData) *lua.LTable
 	tb := l.NewTable()
 	tb.RawSetString("serverbound", lua.LBool(data.Flags.IsServerbound()))
 	tb.RawSetString("injected", lua.LBool(data.Flags.IsInjected()))
+	tb.RawSetString("mirrored", lua.LBool(data.Flags.IsMirrored()))
 	tb.RawSetString("flags", lua.LNumber(int(data.Flags)))
 	tb.RawSetString("source", lua.LString(data.Source.String()))
 	tb.RawSetString("dest", lua.LString(data.Dest.String()))
+	setAddrParts(tb, "source", data.Source)
+	setAddrParts(tb, "dest", data.Dest)
 	tb.RawSetString("proxy_id", lua.LNumber(data.ProxyId))
 	tb.RawSetString("data", lua.LString(string(data.Data)))
 	return tb
 }
 
+// Sets prefix_ip & prefix_port, IPv6 addresses don't have brackets. Nothing is set for addresses without a port, such as unix sockets.
+func setAddrParts(tb *lua.LTable, prefix string, addr net.Addr) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+	tb.RawSetString(prefix+"_ip", lua.LString(host))
+	tb.RawSetString(prefix+"_port", lua.LNumber(p))
+}
+
 func addFunction(l *lua.LState, tb *lua.LTable, name string, fn lua.LGFunction, args int) {
 	tb.RawSetString(name, l.NewFunction(func(l *lua.LState) int {
 		if l.GetTop() != args {
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	bufferPoolMinClass = 9  // Smallest pooled buffer is 512 bytes
+	bufferPoolMaxClass = 17 // Largest pooled buffer is 128KiB, enough for any datagram
+)
+
+// Pools packet buffers so proxies don't allocate a new one for every read.
+//
+// Buffers are grouped by capacity in powers of two, Get rounds up to the next one.
+// A buffer belongs to whoever got it until it's given to Put, after that it must not be touched.
+type BufferPool struct {
+	classes [bufferPoolMaxClass - bufferPoolMinClass + 1]sync.Pool // Free buffers by size class, as *[]byte
+	headers sync.Pool                                              // Unused *[]byte, so Put doesn't allocate
+}
+
+// Gets the size class index for a buffer of n bytes, ok is false if it's too large to pool
+func bufferClass(n int) (index int, ok bool) {
+	class := bits.Len(uint(n - 1))
+	if n <= 1 || class < bufferPoolMinClass {
+		class = bufferPoolMinClass
+	}
+	if class > bufferPoolMaxClass {
+		return 0, false
+	}
+	return class - bufferPoolMinClass, true
+}
+
+// Gets a buffer with a length of n, its contents are undefined.
+// Buffers larger than the largest class are allocated & never pooled.
+func (b *BufferPool) Get(n int) []byte {
+	index, ok := bufferClass(n)
+	if !ok {
+		return make([]byte, n)
+	}
+	if h, _ := b.classes[index].Get().(*[]byte); h != nil {
+		buf := (*h)[:n]
+		*h = nil
+		b.headers.Put(h)
+		return buf
+	}
+	return make([]byte, n, 1<<(index+bufferPoolMinClass))
+}
+
+// Returns a buffer from Get to the pool, it can be resliced but not grown.
+// Buffers that don't have the capacity of a class are ignored.
+func (b *BufferPool) Put(buf []byte) {
+	index, ok := bufferClass(cap(buf))
+	if !ok || cap(buf) != 1<<(index+bufferPoolMinClass) {
+		return
+	}
+	h, _ := b.headers.Get().(*[]byte)
+	if h == nil {
+		h = new([]byte)
+	}
+	*h = buf[:cap(buf)]
+	b.classes[index].Put(h)
+}
+
+// Create a new BufferPool
+func NewBufferPool() *BufferPool {
+	return &BufferPool{}
+}
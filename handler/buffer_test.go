@@ -0,0 +1,84 @@
+package handler_test
+
+import (
+	"ezproxy/handler"
+	"testing"
+)
+
+// Get, Ensure buffers have the requested length & are rounded up to a power of two
+//
+// Expect: Correct lengths & capacities
+func TestBufferPoolGetSize(t *testing.T) {
+	bp := handler.NewBufferPool()
+	for _, v := range []struct{ n, cap int }{{0, 512}, {1, 512}, {512, 512}, {513, 1024}, {4096, 4096}, {65536, 65536}, {65537, 131072}, {200000, 200000}} {
+		buf := bp.Get(v.n)
+		if len(buf) != v.n {
+			t.Errorf("Get(%d) returned length %d", v.n, len(buf))
+		}
+		if cap(buf) != v.cap {
+			t.Errorf("Get(%d) returned capacity %d, expected %d", v.n, cap(buf), v.cap)
+		}
+		bp.Put(buf)
+	}
+}
+
+// Put, Ensure resliced buffers can be put back & got again with the full length
+//
+// Expect: Buffer is reused with the requested length
+func TestBufferPoolPutResliced(t *testing.T) {
+	bp := handler.NewBufferPool()
+	buf := bp.Get(4096)
+	bp.Put(buf[:10])
+	// sync.Pool may drop it, but anything got must still be the right size
+	buf = bp.Get(4000)
+	if len(buf) != 4000 || cap(buf) != 4096 {
+		t.Fatalf("Got length %d capacity %d, expected 4000 & 4096", len(buf), cap(buf))
+	}
+}
+
+// Put, Ensure buffers that aren't from the pool are ignored
+//
+// Expect: Get never returns them
+func TestBufferPoolPutForeign(t *testing.T) {
+	bp := handler.NewBufferPool()
+	bp.Put(make([]byte, 1000))
+	bp.Put(nil)
+	buf := bp.Get(600)
+	if cap(buf) != 1024 {
+		t.Fatalf("Got a buffer with capacity %d, expected 1024", cap(buf))
+	}
+}
+
+var benchSink []byte
+
+// Allocating a buffer for every read, like proxies used to
+func BenchmarkBufferMake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchSink = make([]byte, 4096)
+	}
+}
+
+// Getting a buffer for every read & putting it back once it's handled
+func BenchmarkBufferPool(b *testing.B) {
+	bp := handler.NewBufferPool()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := bp.Get(4096)
+		benchSink = buf
+		bp.Put(buf)
+	}
+}
+
+// Same as BenchmarkBufferPool, from many goroutines
+func BenchmarkBufferPoolParallel(b *testing.B) {
+	bp := handler.NewBufferPool()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := bp.Get(1500)
+			buf[0] = 1
+			bp.Put(buf)
+		}
+	})
+}
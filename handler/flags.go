@@ -6,6 +6,7 @@ type CapFlags uint32
 const (
 	CapFlag_ToServer CapFlags = 1 << 0 // Direction, if set its Serverbound, if not is ClientBound
 	CapFlag_Injected CapFlags = 1 << 1 // Is injected
+	CapFlag_Mirrored CapFlags = 1 << 2 // Reply from a shadow server, see Mirror. It was never sent to the client
 )
 
 // Is this serverbound
@@ -22,3 +23,8 @@ func (c CapFlags) IsClientbound() bool {
 func (c CapFlags) IsInjected() bool {
 	return c&CapFlag_Injected != 0
 }
+
+// Is this a reply from a shadow server
+func (c CapFlags) IsMirrored() bool {
+	return c&CapFlag_Mirrored != 0
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Checks if a upstream is up, returns why it isn't. Must give up once ctx is done
+type HealthProbe func(ctx context.Context, addr net.Addr) error
+
+// Active health checks for upstreams, see ProxySpawner.SetHealthCheck
+type HealthCheck struct {
+	Probe    HealthProbe   // Checks one upstream
+	Interval time.Duration // Time between checks, 0 for 5 seconds
+	Timeout  time.Duration // Time a probe gets before it fails, 0 for 2 seconds
+	Rise     int           // Consecutive successes before a unhealthy upstream is picked again, 0 for 2
+	Fall     int           // Consecutive failures before a upstream stops being picked, 0 for 3
+}
+
+const (
+	defaultHealthInterval time.Duration = time.Second * 5
+	defaultHealthTimeout  time.Duration = time.Second * 2
+	defaultHealthRise     int           = 2
+	defaultHealthFall     int           = 3
+	healthChanSize        int           = 16 // Events a slow receiver can fall behind before they are dropped
+)
+
+// Fills in the defaults for unset values
+func (h HealthCheck) withDefaults() *HealthCheck {
+	if h.Interval <= 0 {
+		h.Interval = defaultHealthInterval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = defaultHealthTimeout
+	}
+	if h.Rise <= 0 {
+		h.Rise = defaultHealthRise
+	}
+	if h.Fall <= 0 {
+		h.Fall = defaultHealthFall
+	}
+	return &h
+}
+
+// A upstream became healthy or unhealthy, see ProxySpawner.GetHealthChan
+type UpstreamHealthEvent struct {
+	Address net.Addr
+	Healthy bool
+	Error   string // Why the last check failed, empty when healthy
+}
+
+// Health of one upstream, upstreams without checks are always healthy
+type upstreamHealth struct {
+	addr      net.Addr
+	healthy   bool
+	successes int // Consecutive successful checks
+	failures  int // Consecutive failed checks
+	lastError string
+}
+
+// Receiver of health events
+type healthChan struct {
+	ch  chan UpstreamHealthEvent
+	ctx context.Context
+}
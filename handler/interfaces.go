@@ -7,7 +7,7 @@ import (
 )
 
 // Called before a packet is sent
-// data  : Bytes to be sent
+// data  : Bytes to be sent, only valid until the callback returns. Copy it to keep it
 // flags : Send flags
 // proxy : Proxy interface
 // return: Should this packet be sent
@@ -17,13 +17,24 @@ type PacketSendCallback func(data []byte, flags CapFlags, proxy IProxyContainer)
 type ProxyErrorCallback func(err error, pc IProxyContainer)
 
 // Received packet
+//
+// Once sent on the packet channel the container owns Data, the proxy must not touch it again.
+// If Pool is set Data came from it & is put back once the packet has been handled.
 type ProxyPacketData struct {
 	Serverbound bool     // Is serverbound
 	Source      net.Addr // Source address
 	Dest        net.Addr // Dest address
 	Data        []byte   // Data
+	Eof         bool     // Source closed its write side, Data is empty. Only sent by proxies that implement IHalfCloser
+	// Not a packet, the proxy forwarded SplicedBytes itself while nothing was observing. Data is empty.
+	// Once this has been sent everything sent before it has been forwarded.
+	Spliced      bool
+	SplicedBytes uint64
+	Pool         *BufferPool // Pool Data came from, may be nil
 }
 
+// Packet from a recv channel, Data is a copy owned by the receiver.
+// All receivers of the same packet share it, so it must not be changed.
 type PacketChanData struct {
 	Flags   CapFlags
 	Source  net.Addr
@@ -78,6 +89,56 @@ type IProxy interface {
 	Network() string                                                                                // Gets the network we are on
 }
 
+// Optional for IProxy, lets one direction finish while the other keeps going.
+// Proxies that implement it send a ProxyPacketData with Eof set when a side closes its write side, the container then
+// calls the matching method once everything before it has been forwarded.
+type IHalfCloser interface {
+	CloseServerWrite() error // The client is done sending, close the write side of the server connection
+	CloseClientWrite() error // The server is done sending, close the write side of the client connection
+}
+
+// Optional for IProxy & IProxyContainer, moves the proxy to a new server while the client stays connected.
+// ProxyContainer always implements it & returns ErrUnsupported if its proxy doesn't.
+type IServerChanger interface {
+	ChangeServer(addr net.Addr) error // Connects to a new server & closes the old one, the client connection is kept. Data the old server hadn't sent is lost
+}
+
+// Optional for IProxy & IProxyContainer, gives the proxy a new client while the server stays connected.
+// ProxyContainer always implements it & returns ErrUnsupported if its proxy doesn't.
+type IClientChanger interface {
+	ChangeClient(client net.Conn) error // Uses client as the client connection & closes the old one, the server connection is kept. Data the old client hadn't sent is lost
+}
+
+// Optional for IProxy & IProxyContainer, lets more than one client share the proxies server connection.
+// ProxyContainer always implements it & returns ErrUnsupported if its proxy doesn't.
+type IClientAdder interface {
+	AddClient(client net.Conn, role ClientRole) error // Adds client alongside the first one, it gets everything sent to clients. Only ClientRoleWriter clients send data to the server
+}
+
+// Optional for IProxy & IProxyContainer, tells where the proxy connected & what else is known about the connection.
+// ProxyContainer always implements it, it uses the spawners server address & nil metadata if its proxy doesn't.
+type IMetadataProvider interface {
+	GetServerAddr() net.Addr        // Gets the server this proxy is connected to, this may differ from the spawners server address
+	GetMetadata() map[string]string // Gets extra info about the connection, such as the TLS SNI. May be nil
+}
+
+// Optional for IProxy & IProxyContainer, remembers which upstream the proxy was given so spawners can count proxies on each.
+// This is the address PickServerAddr returned, before it was resolved or had its port moved. Proxies return nil if they weren't given one.
+type IUpstreamTracker interface {
+	GetUpstream() net.Addr // Gets the server the proxy started on, the upstream it was given. ChangeServer doesn't change this
+}
+
+// Optional for IProxyContainer, copies client traffic to a shadow server.
+type IMirrorable interface {
+	SetMirror(m *Mirror) error // Copies client traffic to a shadow server, replacing the old mirror. nil stops mirroring
+	GetMirror() *MirrorStatus  // Gets the state of the mirror, nil if there isn't one
+}
+
+// Optional for IProxyContainer, lets a client take over the proxy by sending its token, see IClientAdopter.
+type IResumable interface {
+	GetResumeToken() string // Gets the token a client can send to take over this proxy
+}
+
 // Proxy spawner
 type IProxySpawner interface {
 	IConnectionAdder
@@ -96,6 +157,70 @@ type IProxySpawner interface {
 	HandleError(err error, pc IProxyContainer)                                                                     // Deprecated. Handles a error being thrown, if pc is nil the error is in IProxySpawner
 }
 
+// Optional for IProxySpawner, counts bytes proxies forwarded themselves in GetBytesSent.
+type IByteCounter interface {
+	AddBytesSent(n uint64) // Counts bytes a proxy forwarded without HandleSend
+}
+
+// Optional for IProxySpawner, changes the server new proxies connect to.
+type IServerSetter interface {
+	SetServerAddr(addr net.Addr) error // Changes the server new proxies connect to, running proxies stay on their server. With upstreams this is the first one, the rest are kept
+}
+
+// Optional for IProxySpawner, spreads new proxies over a list of upstreams, see IUpstreamPicker.
+type IUpstreamPool interface {
+	SetUpstreams(addrs []net.Addr, strategy UpstreamStrategy) error // Sets the servers new proxies connect to & how one is picked for each, the first is the server address
+	GetUpstreams() []UpstreamStatus                                 // Gets the upstreams & the number of running proxies on each
+	GetUpstreamStrategy() UpstreamStrategy                          // Gets how upstreams are picked
+}
+
+// Optional for IProxySpawner, checks its upstreams & takes unhealthy ones out of rotation.
+type IHealthChecker interface {
+	SetHealthCheck(check *HealthCheck) error                      // Starts checking upstreams, unhealthy ones aren't picked. nil stops checking
+	GetHealthChan(ctx context.Context) <-chan UpstreamHealthEvent // Gets a channel of upstream health changes, it's closed after ctx is done
+}
+
+// Optional for IProxySpawner, mirrors proxies to shadow servers.
+// ProxyContainer checks it for the mirror new proxies get & to record the shadow servers replies.
+type IMirrorManager interface {
+	SetMirror(m *Mirror) error                                          // Mirrors m.Sample of new proxies to m, running proxies aren't changed. nil stops mirroring new proxies
+	GetMirror() *Mirror                                                 // Gets the mirror new proxies may get, nil if there isn't one
+	MirrorProxy(id int, m *Mirror) error                                // Sets the mirror of proxy id, nil stops it
+	HandleMirrorReply(data []byte, source net.Addr, pc IProxyContainer) // Sends a shadow servers reply to the recv channels flagged CapFlag_Mirrored
+}
+
+// Optional for IProxySpawner, gives the next connection from a client to a running proxy, see IClientAdopter.
+type IClientExpecter interface {
+	ExpectClient(id int, host string, role ClientRole, timeout time.Duration) error // The next connection from host ("" for anyone) within timeout is given to proxy id with role
+}
+
+// Optional for IProxySpawner, named listeners that can be stopped & started while the spawner runs.
+type IListenerManager interface {
+	AddListener(l NamedListener) error // Adds a listener, it's started unless l.Stopped is set. Names must be unique
+	StartListener(name string) error   // Starts a stopped listener
+	StopListener(name string) error    // Stops a listener & waits for it to return, proxies on its socket such as UDP sessions are closed
+	GetListeners() []ListenerStatus    // Gets every listener in the order they were added
+}
+
+// Optional for IConnectionAdder, lets proxies forward data themselves when nothing is looking at packets.
+type IObservable interface {
+	IsObserved() bool                 // Is a filter callback, recv channel or mirror active
+	ObservedChanged() <-chan struct{} // Closed the next time IsObserved may have changed
+}
+
+// Optional for IConnectionAdder, lets listeners give a new client connection to a running proxy instead of making a new one.
+// The proxy keeps its ID, stats & server connection. The client replaces the proxies client or joins it, depending on its ClientRole.
+type IClientAdopter interface {
+	ResumeClient(token string, role ClientRole, client net.Conn) (IProxyContainer, error) // Gives client to the proxy with the resume token
+	AdoptExpectedClient(client net.Conn) (IProxyContainer, error)                         // Gives client to a proxy waiting for it from ExpectClient, nil & no error if none is
+}
+
+// Optional for IConnectionAdder, picks the server for each new proxy from a list of upstreams.
+// Listeners that don't check it connect every proxy to GetServerAddr.
+type IUpstreamPicker interface {
+	PickServerAddr(client net.Addr) net.Addr // Picks the server for a new proxy from client
+}
+
 type IConnectionAdder interface {
 	GetProxy(id int) (IProxyContainer, error)         // Gets a proxy by ID, if the proxy is not found a error is returned.
 	GetProxyAddr() net.Addr                           // Gets the address of the proxy
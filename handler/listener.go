@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Listener with a name so it can be stopped & started while the spawner runs, see IListenerManager.StartListener
+type NamedListener struct {
+	Name     string
+	Listener IProxyListener
+	Addr     net.Addr // Listened on instead of the proxy address, nil to use it. The listener sees it as GetProxyAddr
+	Stopped  bool     // Isn't started until StartListener is called
+}
+
+// State of a listener, see IListenerManager.GetListeners
+type ListenerStatus struct {
+	Name    string
+	Address net.Addr // Address the listener binds
+	Running bool
+	Error   string // Why it last stopped, empty if it was stopped with StopListener or never ran
+}
+
+// Time StopListener waits for a listener to return, listeners check their context about every 2 seconds
+const listenerStopTimeout time.Duration = time.Second * 3
+
+// Listener the spawner runs
+type spawnerListener struct {
+	NamedListener
+	cancel context.CancelCauseFunc // Stops the running listener, nil while stopped. Guarded by listenerLock
+	done   chan struct{}           // Closed when the running listener returns, guarded by listenerLock
+	err    error                   // Why it last stopped, guarded by listenerLock
+}
+
+// Passed to listeners with their own address, so GetProxyAddr is the address they bind.
+// Every other method, including the optional interfaces, is the spawners.
+type listenerAdder struct {
+	*ProxySpawner
+	addr net.Addr
+}
+
+func (l *listenerAdder) GetProxyAddr() net.Addr {
+	return l.addr
+}
+
+// Checks l can be added to listeners
+func checkNamedListener(l NamedListener, listeners []*spawnerListener) error {
+	if l.Name == "" {
+		return errors.New("listener name can't be empty")
+	}
+	if l.Listener == nil {
+		return fmt.Errorf("listener '%s' is nil", l.Name)
+	}
+	for _, v := range listeners {
+		if v.Name == l.Name {
+			return fmt.Errorf("listener '%s' already exists", l.Name)
+		}
+	}
+	return nil
+}
+
+// Adds a listener & starts it unless l.Stopped is set
+func (p *ProxySpawner) AddListener(l NamedListener) error {
+	p.listenerLock.Lock()
+	defer p.listenerLock.Unlock()
+	if p.context.Err() != nil {
+		return errors.New("spawner is closed")
+	}
+	if err := checkNamedListener(l, p.listeners); err != nil {
+		return err
+	}
+	sl := &spawnerListener{NamedListener: l}
+	p.listeners = append(p.listeners, sl)
+	if !l.Stopped {
+		p.startListener(sl)
+	}
+	p.logger.Info("Added listener", "Name", l.Name, "Stopped", l.Stopped)
+	return nil
+}
+
+// Starts a stopped listener
+func (p *ProxySpawner) StartListener(name string) error {
+	p.listenerLock.Lock()
+	defer p.listenerLock.Unlock()
+	if p.context.Err() != nil {
+		return errors.New("spawner is closed")
+	}
+	sl, err := p.getListener(name)
+	if err != nil {
+		return err
+	}
+	if sl.cancel != nil {
+		return fmt.Errorf("listener '%s' is already running", name)
+	}
+	p.startListener(sl)
+	p.logger.Info("Started listener", "Name", name)
+	return nil
+}
+
+// Stops a running listener & waits for it to return, the spawner keeps going.
+// Proxies with their own connections keep going, UDP sessions share the listeners socket so they are closed with it
+func (p *ProxySpawner) StopListener(name string) error {
+	p.listenerLock.Lock()
+	sl, err := p.getListener(name)
+	if err != nil {
+		p.listenerLock.Unlock()
+		return err
+	}
+	if sl.cancel == nil {
+		p.listenerLock.Unlock()
+		return fmt.Errorf("listener '%s' isn't running", name)
+	}
+	sl.cancel(ErrProxyClosedOk)
+	done := sl.done
+	p.listenerLock.Unlock()
+	select {
+	case <-done:
+	case <-time.After(listenerStopTimeout):
+		return fmt.Errorf("timed out stopping listener '%s'", name)
+	}
+	p.logger.Info("Stopped listener", "Name", name)
+	return nil
+}
+
+// Gets every listener in the order they were added
+func (p *ProxySpawner) GetListeners() []ListenerStatus {
+	p.listenerLock.Lock()
+	defer p.listenerLock.Unlock()
+	status := make([]ListenerStatus, 0, len(p.listeners))
+	for _, v := range p.listeners {
+		s := ListenerStatus{
+			Name:    v.Name,
+			Address: v.Addr,
+			Running: v.cancel != nil,
+		}
+		if s.Address == nil {
+			s.Address = p.proxyAddr
+		}
+		if v.err != nil {
+			s.Error = v.err.Error()
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// Must hold listenerLock
+func (p *ProxySpawner) getListener(name string) (*spawnerListener, error) {
+	for _, v := range p.listeners {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("listener '%s' not found", name)
+}
+
+// Must hold listenerLock
+func (p *ProxySpawner) startListener(sl *spawnerListener) {
+	ctx, cancel := context.WithCancelCause(p.context)
+	sl.cancel = cancel
+	sl.done = make(chan struct{})
+	sl.err = nil
+	var ca IConnectionAdder = p
+	if sl.Addr != nil {
+		ca = &listenerAdder{ProxySpawner: p, addr: sl.Addr}
+	}
+	p.wg.Add(1)
+	go p.runListener(sl, ca, ctx, sl.done)
+}
@@ -0,0 +1,168 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"net"
+	"testing"
+	"time"
+)
+
+// Listener that runs until its context is done, sends the proxy address it was given to started
+func createTestListener(started chan<- net.Addr) handler.IProxyListener {
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ca handler.IConnectionAdder) {
+		started <- ca.GetProxyAddr()
+		<-ctx.Done()
+	}
+}
+
+func waitStarted(t *testing.T, started <-chan net.Addr) net.Addr {
+	t.Helper()
+	select {
+	case addr := <-started:
+		return addr
+	case <-time.After(time.Second):
+		t.Fatalf("Listener wasn't started")
+	}
+	return nil
+}
+
+func getListenerStatus(ps *handler.ProxySpawner, name string) *handler.ListenerStatus {
+	for _, v := range ps.GetListeners() {
+		if v.Name == name {
+			return &v
+		}
+	}
+	return nil
+}
+
+// NewProxySpawnerWithListeners, Ensure invalid listeners are rejected
+//
+// Expect: Empty names, nil listeners & duplicate names fail
+func TestNewSpawnerListenersInvalid(t *testing.T) {
+	started := make(chan net.Addr, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for name, listeners := range map[string][]handler.NamedListener{
+		"empty name":     {{Name: "", Listener: createTestListener(started)}},
+		"nil listener":   {{Name: "tcp"}},
+		"duplicate name": {{Name: "tcp", Listener: createTestListener(started)}, {Name: "tcp", Listener: createTestListener(started)}},
+	} {
+		if _, err := handler.NewProxySpawnerWithListeners(NewMockAddr("Server"), NewMockAddr("Proxy"), ctx, listeners...); err == nil {
+			t.Errorf("Expected a error for %s", name)
+		}
+	}
+}
+
+// StopListener & StartListener, Ensure a listener can be stopped & started without closing the spawner
+//
+// Expect: Stopped listeners aren't started, the spawner stays alive after StopListener, stopping twice fails
+func TestListenerStopStart(t *testing.T) {
+	tcpStarted, udpStarted := make(chan net.Addr, 1), make(chan net.Addr, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps, err := handler.NewProxySpawnerWithListeners(NewMockAddr("Server"), NewMockAddr("Proxy"), ctx,
+		handler.NamedListener{Name: "tcp", Listener: createTestListener(tcpStarted)},
+		handler.NamedListener{Name: "udp", Listener: createTestListener(udpStarted), Stopped: true},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create spawner: %v", err)
+	}
+	defer ps.Close()
+	waitStarted(t, tcpStarted)
+	if s := getListenerStatus(ps, "udp"); s == nil || s.Running {
+		t.Fatalf("Expected udp to be stopped, got %v", s)
+	}
+	if err := ps.StopListener("tcp"); err != nil {
+		t.Fatalf("Failed to stop listener: %v", err)
+	}
+	if s := getListenerStatus(ps, "tcp"); s == nil || s.Running || s.Error != "" {
+		t.Errorf("Expected tcp to be stopped without a error, got %v", s)
+	}
+	if !ps.IsAlive() {
+		t.Fatalf("Spawner closed after stopping a listener")
+	}
+	if err := ps.StopListener("tcp"); err == nil {
+		t.Errorf("Stopped a listener that isn't running")
+	}
+	if err := ps.StartListener("udp"); err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	waitStarted(t, udpStarted)
+	if err := ps.StartListener("udp"); err == nil {
+		t.Errorf("Started a listener that is already running")
+	}
+	if err := ps.StartListener("tcp"); err != nil {
+		t.Fatalf("Failed to restart listener: %v", err)
+	}
+	waitStarted(t, tcpStarted)
+	if err := ps.StartListener("quic"); err == nil {
+		t.Errorf("Started a listener that doesn't exist")
+	}
+}
+
+// AddListener, Ensure listeners with their own address see it as the proxy address
+//
+// Expect: GetProxyAddr is Addr for the new listener, GetListeners has every listener in order
+func TestAddListener(t *testing.T) {
+	started := make(chan net.Addr, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxyAddr, udpAddr := NewMockAddr("Proxy"), NewMockAddr("ProxyUdp")
+	ps, err := handler.NewProxySpawnerWithListeners(NewMockAddr("Server"), proxyAddr, ctx,
+		handler.NamedListener{Name: "tcp", Listener: createTestListener(started)},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create spawner: %v", err)
+	}
+	defer ps.Close()
+	if addr := waitStarted(t, started); addr != proxyAddr {
+		t.Errorf("Expected the proxy address, got %v", addr)
+	}
+	if err := ps.AddListener(handler.NamedListener{Name: "tcp", Listener: createTestListener(started)}); err == nil {
+		t.Errorf("Added a listener with a name in use")
+	}
+	if err := ps.AddListener(handler.NamedListener{Name: "udp", Listener: createTestListener(started), Addr: udpAddr}); err != nil {
+		t.Fatalf("Failed to add listener: %v", err)
+	}
+	if addr := waitStarted(t, started); addr != udpAddr {
+		t.Errorf("Expected the listeners address, got %v", addr)
+	}
+	listeners := ps.GetListeners()
+	if len(listeners) != 2 || listeners[0].Name != "tcp" || listeners[1].Name != "udp" {
+		t.Fatalf("Incorrect listeners, got %v", listeners)
+	}
+	if listeners[0].Address != proxyAddr || listeners[1].Address != udpAddr || !listeners[1].Running {
+		t.Errorf("Incorrect listener status, got %v", listeners)
+	}
+}
+
+// Listener errors, Ensure the error is kept in the status
+//
+// Expect: The listener isn't running & Error is set, the spawner is closed
+func TestListenerError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps, err := handler.NewProxySpawnerWithListeners(NewMockAddr("Server"), NewMockAddr("Proxy"), ctx,
+		handler.NamedListener{Name: "tcp", Listener: func(ctx context.Context, cancel context.CancelCauseFunc, ca handler.IConnectionAdder) {
+			cancel(errors.New("test error"))
+		}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create spawner: %v", err)
+	}
+	select {
+	case <-ps.GetContext().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Spawner wasn't closed")
+	}
+	// Waits for the listener to return
+	ps.Close()
+	if s := getListenerStatus(ps, "tcp"); s == nil || s.Running || s.Error != "test error" {
+		t.Errorf("Expected tcp to be stopped with 'test error', got %v", s)
+	}
+	if err := ps.StartListener("tcp"); err == nil {
+		t.Errorf("Started a listener on a closed spawner")
+	}
+}
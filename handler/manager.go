@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Name of the route made from the top level of the config
+const DefaultRouteName = "default"
+
+// Owns the spawners of named routes, each route is a separate proxy with its own listeners, server & proxies.
+// The first route added is the default, it's used when no route name is given.
+type SpawnerManager struct {
+	routes map[string]IProxySpawner
+	names  []string // Route names in the order they were added
+	lock   sync.RWMutex
+}
+
+// Adds a route, names must be unique & not empty
+func (m *SpawnerManager) AddRoute(name string, ps IProxySpawner) error {
+	if name == "" {
+		return errors.New("route name can't be empty")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.routes[name]; ok {
+		return fmt.Errorf("route '%s' already exists", name)
+	}
+	m.routes[name] = ps
+	m.names = append(m.names, name)
+	return nil
+}
+
+// Gets the spawner of a route, "" is the default route
+func (m *SpawnerManager) GetRoute(name string) (IProxySpawner, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if name == "" {
+		if len(m.names) == 0 {
+			return nil, errors.New("there are no routes")
+		}
+		name = m.names[0]
+	}
+	ps, ok := m.routes[name]
+	if !ok {
+		return nil, fmt.Errorf("route '%s' not found", name)
+	}
+	return ps, nil
+}
+
+// Gets the name of the default route, "" if there are no routes
+func (m *SpawnerManager) GetDefaultRoute() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if len(m.names) == 0 {
+		return ""
+	}
+	return m.names[0]
+}
+
+// Gets every route name in the order they were added
+func (m *SpawnerManager) GetRouteNames() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]string(nil), m.names...)
+}
+
+// Closes every route
+func (m *SpawnerManager) Close() error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var errs []error
+	for _, name := range m.names {
+		if err := m.routes[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("route '%s': %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Blocks until every route has closed
+func (m *SpawnerManager) Wait() {
+	m.lock.RLock()
+	spawners := make([]IProxySpawner, 0, len(m.names))
+	for _, name := range m.names {
+		spawners = append(spawners, m.routes[name])
+	}
+	m.lock.RUnlock()
+	for _, ps := range spawners {
+		<-ps.GetContext().Done()
+	}
+}
+
+// Creates a manager without routes
+func NewSpawnerManager() *SpawnerManager {
+	return &SpawnerManager{
+		routes: make(map[string]IProxySpawner),
+		names:  make([]string, 0),
+	}
+}
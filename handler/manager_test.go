@@ -0,0 +1,88 @@
+package handler_test
+
+import (
+	"context"
+	"ezproxy/handler"
+	"ezproxy/mocks"
+	"slices"
+	"testing"
+	"time"
+)
+
+// SpawnerManager, Ensure routes are added & found by name, the first is the default
+//
+// Expect: Empty & duplicate names fail, "" gets the first route, unknown routes fail
+func TestSpawnerManagerRoutes(t *testing.T) {
+	m := handler.NewSpawnerManager()
+	if _, err := m.GetRoute(""); err == nil {
+		t.Errorf("Got a default route without routes")
+	}
+	if m.GetDefaultRoute() != "" {
+		t.Errorf("Got a default route name without routes")
+	}
+	first, second := mocks.NewIProxySpawner(t), mocks.NewIProxySpawner(t)
+	if err := m.AddRoute("", first); err == nil {
+		t.Errorf("Added a route without a name")
+	}
+	if err := m.AddRoute("first", first); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	if err := m.AddRoute("second", second); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	if err := m.AddRoute("first", second); err == nil {
+		t.Errorf("Added a route with a name in use")
+	}
+	if ps, err := m.GetRoute(""); err != nil || ps != first {
+		t.Errorf("Default route isn't the first route, got %v %v", ps, err)
+	}
+	if ps, err := m.GetRoute("second"); err != nil || ps != second {
+		t.Errorf("Got the wrong route, got %v %v", ps, err)
+	}
+	if _, err := m.GetRoute("third"); err == nil {
+		t.Errorf("Got a route that doesn't exist")
+	}
+	if m.GetDefaultRoute() != "first" {
+		t.Errorf("Expected the default route to be 'first', got '%s'", m.GetDefaultRoute())
+	}
+	if names := m.GetRouteNames(); !slices.Equal(names, []string{"first", "second"}) {
+		t.Errorf("Incorrect route names, got %v", names)
+	}
+}
+
+// SpawnerManager, Ensure Close closes every route & Wait returns once they are all closed
+//
+// Expect: Close called on each spawner, Wait blocks until the last context is done
+func TestSpawnerManagerCloseWait(t *testing.T) {
+	m := handler.NewSpawnerManager()
+	cancels := make([]context.CancelFunc, 0)
+	for _, name := range []string{"first", "second"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cancels = append(cancels, cancel)
+		ps := mocks.NewIProxySpawner(t)
+		ps.On("GetContext").Return(ctx)
+		ps.On("Close").Return(nil).Once()
+		m.AddRoute(name, ps)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.Wait()
+		close(done)
+	}()
+	cancels[0]()
+	select {
+	case <-done:
+		t.Fatalf("Wait returned while a route is still running")
+	case <-time.After(time.Millisecond * 50):
+	}
+	cancels[1]()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait didn't return after every route closed")
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Failed to close routes: %v", err)
+	}
+}
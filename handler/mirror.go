@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Which serverbound packets are copied to a shadow server
+type MirrorPolicy uint32
+
+const (
+	MirrorForwarded MirrorPolicy = 1 << 0                           // Client packets that were sent to the server
+	MirrorDropped   MirrorPolicy = 1 << 1                           // Client packets the filter callback dropped
+	MirrorInjected  MirrorPolicy = 1 << 2                           // Packets injected to the server
+	MirrorDefault   MirrorPolicy = MirrorForwarded | MirrorInjected // Everything the server gets
+	mirrorAll       MirrorPolicy = MirrorForwarded | MirrorDropped | MirrorInjected
+)
+
+var mirrorPolicyNames = map[string]MirrorPolicy{
+	"forwarded": MirrorForwarded,
+	"dropped":   MirrorDropped,
+	"injected":  MirrorInjected,
+}
+
+// Parses "forwarded", "dropped" & "injected" into a policy, no names is MirrorDefault
+func ParseMirrorPolicy(names []string) (MirrorPolicy, error) {
+	if len(names) == 0 {
+		return MirrorDefault, nil
+	}
+	policy := MirrorPolicy(0)
+	for _, name := range names {
+		p, ok := mirrorPolicyNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("invalid mirror policy '%s', must be 'forwarded', 'dropped' or 'injected'", name)
+		}
+		policy |= p
+	}
+	return policy, nil
+}
+
+// Copies the client traffic of a proxy to a shadow server, see IProxyContainer.SetMirror.
+// The shadow server gets its own connection & its replies are never sent to the client.
+type Mirror struct {
+	Addr   net.Addr     // Shadow server, dialed over the proxies network. Unix addresses are dialed as they are
+	Policy MirrorPolicy // Packets to copy, 0 for MirrorDefault
+	Record bool         // Send the shadows replies to recv channels flagged CapFlag_Mirrored, if not they are discarded
+	Sample float64      // Fraction of new proxies mirrored after ProxySpawner.SetMirror, 0 for all of them
+}
+
+// Checks m & fills in the defaults
+func (m Mirror) withDefaults() (*Mirror, error) {
+	if m.Addr == nil {
+		return nil, errors.New("mirror has no address")
+	}
+	if m.Policy&^mirrorAll != 0 {
+		return nil, fmt.Errorf("invalid mirror policy %d", m.Policy)
+	}
+	if m.Sample < 0 || m.Sample > 1 {
+		return nil, fmt.Errorf("mirror sample must be between 0 and 1, got %v", m.Sample)
+	}
+	if m.Policy == 0 {
+		m.Policy = MirrorDefault
+	}
+	if m.Sample == 0 {
+		m.Sample = 1
+	}
+	return &m, nil
+}
+
+// Picks a new proxy to be mirrored with the chance of Sample
+func (m *Mirror) sampled() bool {
+	return m.Sample <= 0 || rand.Float64() < m.Sample
+}
+
+// State of a proxies mirror, see IProxyContainer.GetMirror
+type MirrorStatus struct {
+	Address       net.Addr
+	Policy        MirrorPolicy
+	Record        bool
+	Active        bool   // A mirror stops when the shadow server can't be reached or falls behind, the proxy keeps going
+	BytesSent     uint64 // Bytes copied to the shadow server
+	BytesReceived uint64 // Bytes the shadow server replied with
+	Error         string // Why it stopped, empty while active
+}
+
+const (
+	mirrorQueueSize   int           = 256 // Packets a slow shadow server can fall behind before the mirror stops
+	mirrorBufferSize  int           = 65535
+	mirrorDialTimeout time.Duration = time.Second * 5
+)
+
+// Mirror was replaced or removed with SetMirror
+var errMirrorStopped = errors.New("mirror stopped")
+
+type mirrorPacket struct {
+	data []byte
+	eof  bool // The client closed its write side
+}
+
+// Running mirror of one proxy
+type proxyMirror struct {
+	cfg      Mirror
+	queue    chan mirrorPacket
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+// Starts copying to cfg.Addr until pc closes or the mirror is stopped
+func startMirror(pc *ProxyContainer, cfg Mirror) *proxyMirror {
+	ctx, cancel := context.WithCancelCause(pc.ctx)
+	m := &proxyMirror{
+		cfg:    cfg,
+		queue:  make(chan mirrorPacket, mirrorQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go m.run(pc)
+	return m
+}
+
+// Queues a copy of data, the mirror stops if the queue is full
+func (m *proxyMirror) copy(data []byte) {
+	m.enqueue(mirrorPacket{data: append([]byte(nil), data...)})
+}
+
+// Closes the write side to the shadow server once everything queued is sent
+func (m *proxyMirror) closeWrite() {
+	m.enqueue(mirrorPacket{eof: true})
+}
+
+func (m *proxyMirror) enqueue(pkt mirrorPacket) {
+	if m.ctx.Err() != nil {
+		return
+	}
+	select {
+	case m.queue <- pkt:
+	default:
+		m.cancel(errors.New("shadow server fell behind"))
+	}
+}
+
+// Dials the shadow server, writes what's queued & reads the replies
+func (m *proxyMirror) run(pc *ProxyContainer) {
+	network := m.cfg.Addr.Network()
+	if _, ok := m.cfg.Addr.(*net.UnixAddr); !ok {
+		network = "tcp"
+		if pc.Network() == "udp" {
+			network = "udp"
+		}
+	}
+	d := net.Dialer{Timeout: mirrorDialTimeout}
+	c, err := d.DialContext(m.ctx, network, m.cfg.Addr.String())
+	if err != nil {
+		m.cancel(fmt.Errorf("failed to dial shadow server: %v", err))
+		pc.logger.Warn("Mirror stopped", "Id", pc.id, "Address", m.cfg.Addr.String(), "Error", err.Error())
+		return
+	}
+	defer c.Close()
+	go m.read(pc, c)
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case pkt := <-m.queue:
+			if pkt.eof {
+				if cw, ok := c.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
+				continue
+			}
+			if _, err := c.Write(pkt.data); err != nil {
+				m.cancel(fmt.Errorf("failed to write to shadow server: %v", err))
+				pc.logger.Warn("Mirror stopped", "Id", pc.id, "Address", m.cfg.Addr.String(), "Error", err.Error())
+				return
+			}
+			m.sent.Add(uint64(len(pkt.data)))
+		}
+	}
+}
+
+// Reads replies until c is closed, the shadow server closing its side doesn't stop the copying
+func (m *proxyMirror) read(pc *ProxyContainer, c net.Conn) {
+	buffer := make([]byte, mirrorBufferSize)
+	for {
+		n, err := c.Read(buffer)
+		if n > 0 {
+			m.received.Add(uint64(n))
+			if mm, ok := pc.spawner.(IMirrorManager); ok && m.cfg.Record {
+				mm.HandleMirrorReply(buffer[:n], m.cfg.Addr, pc)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (m *proxyMirror) status() *MirrorStatus {
+	s := &MirrorStatus{
+		Address:       m.cfg.Addr,
+		Policy:        m.cfg.Policy,
+		Record:        m.cfg.Record,
+		Active:        m.ctx.Err() == nil,
+		BytesSent:     m.sent.Load(),
+		BytesReceived: m.received.Load(),
+	}
+	if !s.Active {
+		s.Error = context.Cause(m.ctx).Error()
+	}
+	return s
+}
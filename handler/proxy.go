@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net"
@@ -9,6 +11,9 @@ import (
 	"time"
 )
 
+// Number of random bytes in a resume token, it's sent as hex
+const resumeTokenSize = 16
+
 // Container for IProxy
 type ProxyContainer struct {
 	spawner         IProxySpawner
@@ -20,6 +25,10 @@ type ProxyContainer struct {
 	statsLock       sync.RWMutex
 	bytesSent       uint64
 	lastContactTime time.Time
+	resumeToken     string       // Random token a client can send to take over this proxy
+	upstream        net.Addr     // Server the proxy started on
+	mirror          *proxyMirror // Copies client traffic to a shadow server, may be nil
+	mirrorLock      sync.RWMutex
 	logger          *slog.Logger
 }
 
@@ -30,13 +39,29 @@ func (pc *ProxyContainer) handlePacket() {
 		case <-pc.ctx.Done():
 			return
 		case data := <-pc.pktChan:
+			if data.Eof {
+				pc.handleEof(data.Serverbound)
+				continue
+			}
+			if data.Spliced {
+				pc.addSpliced(data.SplicedBytes)
+				continue
+			}
 			// Setup the flags
 			flags := CapFlags(0)
 			if data.Serverbound {
 				flags |= CapFlag_ToServer
 			}
 			// Only send it if the callback says we can
-			if pc.spawner.HandleSend(data.Data, flags, pc) {
+			shouldSend := pc.spawner.HandleSend(data.Data, flags, pc)
+			if data.Serverbound {
+				if shouldSend {
+					pc.mirrorPacket(data.Data, MirrorForwarded)
+				} else {
+					pc.mirrorPacket(data.Data, MirrorDropped)
+				}
+			}
+			if shouldSend {
 				var err error
 				pc.logger.Debug("Forwarding packet", "Source", data.Source, "Dest", data.Dest, "Serverbound", data.Serverbound, "Data", data.Data, "Flags", flags)
 				if data.Serverbound {
@@ -59,10 +84,53 @@ func (pc *ProxyContainer) handlePacket() {
 			} else {
 				pc.logger.Debug("Filtering packet", "Source", data.Source, "Dest", data.Dest, "Serverbound", data.Serverbound, "Data", data.Data, "Flags", flags)
 			}
+			if data.Pool != nil {
+				data.Pool.Put(data.Data)
+			}
 		}
 	}
 }
 
+// Passes a half close on to the other side, the proxy is closed if it can't half close.
+func (pc *ProxyContainer) handleEof(serverbound bool) {
+	if serverbound {
+		if m := pc.getMirror(); m != nil {
+			m.closeWrite()
+		}
+	}
+	hc, ok := pc.px.(IHalfCloser)
+	if !ok {
+		pc.logger.Debug("Proxy sent EOF but can't half close, closing")
+		pc.ctxCancel(ErrProxyClosedOk)
+		return
+	}
+	pc.logger.Debug("Forwarding EOF", "Serverbound", serverbound)
+	var err error
+	if serverbound {
+		err = hc.CloseServerWrite()
+	} else {
+		err = hc.CloseClientWrite()
+	}
+	if err != nil {
+		pc.logger.Debug("Error in half closing", "Error", err.Error())
+		pc.spawner.HandleError(err, pc)
+	}
+}
+
+// Counts bytes the proxy forwarded itself
+func (pc *ProxyContainer) addSpliced(n uint64) {
+	if n == 0 {
+		return
+	}
+	pc.statsLock.Lock()
+	pc.lastContactTime = time.Now()
+	pc.bytesSent += n
+	pc.statsLock.Unlock()
+	if bc, ok := pc.spawner.(IByteCounter); ok {
+		bc.AddBytesSent(n)
+	}
+}
+
 // Inject data to client, calls the sendCallback and doesn't send it if the callback returns false
 func (pc *ProxyContainer) SendToClient(data []byte) error {
 	if pc.ctx.Err() != nil {
@@ -96,6 +164,7 @@ func (pc *ProxyContainer) SendToServer(data []byte) error {
 		// Don't send
 		return nil
 	}
+	pc.mirrorPacket(data, MirrorInjected)
 	err := pc.px.SendToServer(data)
 	if err != nil {
 		pc.logger.Debug("Error sending data to client", "Error", err.Error())
@@ -109,11 +178,120 @@ func (pc *ProxyContainer) SendToServer(data []byte) error {
 	return nil
 }
 
-// Get server address
+// Copies a serverbound packet to the mirror if its policy has kind
+func (pc *ProxyContainer) mirrorPacket(data []byte, kind MirrorPolicy) {
+	if m := pc.getMirror(); m != nil && m.cfg.Policy&kind != 0 {
+		m.copy(data)
+	}
+}
+
+func (pc *ProxyContainer) getMirror() *proxyMirror {
+	pc.mirrorLock.RLock()
+	defer pc.mirrorLock.RUnlock()
+	return pc.mirror
+}
+
+// Copies client traffic to a shadow server, replacing the old mirror. nil stops mirroring
+func (pc *ProxyContainer) SetMirror(m *Mirror) error {
+	if pc.ctx.Err() != nil {
+		return context.Cause(pc.ctx)
+	}
+	var next *proxyMirror
+	if m != nil {
+		cfg, err := m.withDefaults()
+		if err != nil {
+			return err
+		}
+		pc.logger.Debug("Mirroring proxy", "Id", pc.id, "Address", cfg.Addr.String(), "Policy", cfg.Policy, "Record", cfg.Record)
+		next = startMirror(pc, *cfg)
+	} else {
+		pc.logger.Debug("Stopping mirror", "Id", pc.id)
+	}
+	pc.mirrorLock.Lock()
+	old := pc.mirror
+	pc.mirror = next
+	pc.mirrorLock.Unlock()
+	if old != nil {
+		old.cancel(errMirrorStopped)
+	}
+	return nil
+}
+
+// Gets the state of the mirror, nil if there isn't one
+func (pc *ProxyContainer) GetMirror() *MirrorStatus {
+	if m := pc.getMirror(); m != nil {
+		return m.status()
+	}
+	return nil
+}
+
+// Get server address, the spawners if the proxy isn't a IMetadataProvider
 func (pc *ProxyContainer) GetServerAddr() net.Addr {
+	if mp, ok := pc.px.(IMetadataProvider); ok {
+		return mp.GetServerAddr()
+	}
 	return pc.spawner.GetServerAddr()
 }
 
+// Gets the server the proxy started on, the upstream the spawner picked for it
+func (pc *ProxyContainer) GetUpstream() net.Addr {
+	return pc.upstream
+}
+
+// Get connection metadata, nil if the proxy isn't a IMetadataProvider
+func (pc *ProxyContainer) GetMetadata() map[string]string {
+	if mp, ok := pc.px.(IMetadataProvider); ok {
+		return mp.GetMetadata()
+	}
+	return nil
+}
+
+// Moves the proxy to a new server, ErrUnsupported if the proxy isn't a IServerChanger
+func (pc *ProxyContainer) ChangeServer(addr net.Addr) error {
+	if pc.ctx.Err() != nil {
+		return context.Cause(pc.ctx)
+	}
+	sc, ok := pc.px.(IServerChanger)
+	if !ok {
+		return fmt.Errorf("can't change server: %w", ErrUnsupported)
+	}
+	pc.logger.Debug("Changing server", "Id", pc.id, "ServerAddress", addr.String())
+	return sc.ChangeServer(addr)
+}
+
+// Gives the proxy a new client, the old one is dropped. ErrUnsupported if the proxy isn't a IClientChanger
+func (pc *ProxyContainer) ChangeClient(client net.Conn) error {
+	if pc.ctx.Err() != nil {
+		return context.Cause(pc.ctx)
+	}
+	cc, ok := pc.px.(IClientChanger)
+	if !ok {
+		return fmt.Errorf("can't change client: %w", ErrUnsupported)
+	}
+	pc.logger.Debug("Changing client", "Id", pc.id, "Old", pc.px.GetClientAddr().String(), "New", client.RemoteAddr().String())
+	return cc.ChangeClient(client)
+}
+
+// Adds a client alongside the others, ErrUnsupported if the proxy isn't a IClientAdder
+func (pc *ProxyContainer) AddClient(client net.Conn, role ClientRole) error {
+	if pc.ctx.Err() != nil {
+		return context.Cause(pc.ctx)
+	}
+	if role != ClientRoleWriter && role != ClientRoleObserver {
+		return fmt.Errorf("can't add a client with the %s role", role)
+	}
+	ca, ok := pc.px.(IClientAdder)
+	if !ok {
+		return fmt.Errorf("can't add client: %w", ErrUnsupported)
+	}
+	pc.logger.Debug("Adding client", "Id", pc.id, "ClientAddr", client.RemoteAddr().String(), "Role", role.String())
+	return ca.AddClient(client, role)
+}
+
+func (pc *ProxyContainer) GetResumeToken() string {
+	return pc.resumeToken
+}
+
 // Get client address
 func (pc *ProxyContainer) GetClientAddr() net.Addr {
 	return pc.px.GetClientAddr()
@@ -162,6 +340,10 @@ func (pc *ProxyContainer) LastContactTimeAgo() time.Duration {
 
 // Creates a new container
 func NewProxyContainer(parent IProxySpawner, px IProxy, id int) (IProxyContainer, error) {
+	token := make([]byte, resumeTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to make resume token: %v", err)
+	}
 	pktChan := make(chan ProxyPacketData)
 	pCtx, pCtxCancel := context.WithCancelCause(parent.GetContext())
 	pc := &ProxyContainer{
@@ -175,6 +357,21 @@ func NewProxyContainer(parent IProxySpawner, px IProxy, id int) (IProxyContainer
 		statsLock:       sync.RWMutex{},
 		bytesSent:       0,
 		lastContactTime: time.Unix(0, 0),
+		resumeToken:     hex.EncodeToString(token),
+	}
+	pc.upstream = pc.GetServerAddr()
+	// The address the spawner picked, the server address is resolved & can't be matched to the upstreams
+	if ut, ok := px.(IUpstreamTracker); ok && ut.GetUpstream() != nil {
+		pc.upstream = ut.GetUpstream()
+	}
+	// Mirror before Init so the shadow server gets everything
+	if mm, ok := parent.(IMirrorManager); ok {
+		if m := mm.GetMirror(); m != nil && m.sampled() {
+			if cfg, err := m.withDefaults(); err == nil {
+				pc.logger.Debug("Mirroring new proxy", "Id", id, "Address", cfg.Addr.String())
+				pc.mirror = startMirror(pc, *cfg)
+			}
+		}
 	}
 	go pc.handlePacket()
 	pc.logger.Debug("Init on new IProxy", "Id", id, "Client", px.GetClientAddr())
@@ -6,6 +6,9 @@ import (
 	"ezproxy/handler"
 	"ezproxy/mocks"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +19,8 @@ type ProxyContainerInfo struct {
 	Container    *handler.ProxyContainer
 	Proxy        *mocks.IProxy
 	Spawner      *mocks.IProxySpawner
+	Mirrors      *mocks.IMirrorManager
+	Bytes        *mocks.IByteCounter
 	Ctx          context.Context
 	Cancel       context.CancelFunc
 	ProxyContext context.Context
@@ -23,14 +28,36 @@ type ProxyContainerInfo struct {
 	PktChan      chan<- handler.ProxyPacketData
 }
 
+// IProxySpawner that can mirror
+type mirrorSpawner struct {
+	*mocks.IProxySpawner
+	*mocks.IMirrorManager
+}
+
+// IProxySpawner that can mirror & count spliced bytes
+type counterSpawner struct {
+	mirrorSpawner
+	*mocks.IByteCounter
+}
+
 func NewProxyContainer(t *testing.T, clientAddr *MockAddr, id int) *ProxyContainerInfo {
+	return NewProxyContainerWith(t, clientAddr, id, nil)
+}
+
+// Same as NewProxyContainer, the container gets wrap(px) instead of px so it can implement optional interfaces
+func NewProxyContainerWith(t *testing.T, clientAddr *MockAddr, id int, wrap func(px *mocks.IProxy) handler.IProxy) *ProxyContainerInfo {
 	tCtx, tCan := context.WithCancel(context.Background())
 	sp := mocks.NewIProxySpawner(t)
 	sp.On("GetContext").Return(tCtx)
+	mm := mocks.NewIMirrorManager(t)
+	mm.On("GetMirror").Return(nil).Maybe()
+	bc := mocks.NewIByteCounter(t)
 	px := mocks.NewIProxy(t)
 	pci := &ProxyContainerInfo{
 		Proxy:   px,
 		Spawner: sp,
+		Mirrors: mm,
+		Bytes:   bc,
 		Ctx:     tCtx,
 		Cancel:  tCan,
 	}
@@ -42,7 +69,15 @@ func NewProxyContainer(t *testing.T, clientAddr *MockAddr, id int) *ProxyContain
 		pci.ProxyContext = a.Get(1).(context.Context)
 		pci.ProxyCancel = a.Get(2).(context.CancelCauseFunc)
 	}
-	pc, err := handler.NewProxyContainer(sp, px, id)
+	var ipx handler.IProxy = px
+	if wrap != nil {
+		ipx = wrap(px)
+	}
+	// Called once to remember the upstream unless the proxy knows its server, tests can set their own server after
+	if _, ok := ipx.(handler.IMetadataProvider); !ok {
+		sp.On("GetServerAddr").Return(NewMockAddr("TestUpstream")).Once()
+	}
+	pc, err := handler.NewProxyContainer(counterSpawner{mirrorSpawner{sp, mm}, bc}, ipx, id)
 	if err != nil {
 		t.Fatalf("Failed to create container: %v", err)
 	}
@@ -68,6 +103,7 @@ func TestNewProxyContainerArgumentsFail(t *testing.T) {
 	px := mocks.NewIProxy(t)
 	// This is called for logging - you can ignore it or remove it later.
 	px.On("GetClientAddr").Return(NewMockAddr("TestClient")).Maybe()
+	sp.On("GetServerAddr").Return(NewMockAddr("TestUpstream")).Maybe()
 	px.On("Init", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("Test error"))
 	_, err := handler.NewProxyContainer(sp, px, 0)
 	if err == nil {
@@ -210,6 +246,45 @@ func TestGetServerAddr(t *testing.T) {
 	}
 }
 
+// IProxy that knows its server & metadata
+type metadataProxy struct {
+	*mocks.IProxy
+	*mocks.IMetadataProvider
+}
+
+// GetServerAddr & GetMetadata, Ensure they come from the proxy if it's a `IMetadataProvider`
+//
+// Expect: The proxies server address & metadata, not the spawners server address
+func TestGetMetadata(t *testing.T) {
+	var mp *mocks.IMetadataProvider
+	pci := NewProxyContainerWith(t, NewMockAddr("TestClient"), 3, func(px *mocks.IProxy) handler.IProxy {
+		mp = mocks.NewIMetadataProvider(t)
+		mp.On("GetServerAddr").Return(NewMockAddr("TestServer"))
+		return metadataProxy{px, mp}
+	})
+	mp.On("GetMetadata").Return(map[string]string{"Sni": "example.com"})
+	md := pci.Container.GetMetadata()
+	if md["Sni"] != "example.com" {
+		t.Errorf("Invalid metadata, expected Sni to be 'example.com' got %+v", md)
+	}
+	if s := pci.Container.GetServerAddr(); s.String() != "TestServer" {
+		t.Errorf("Invalid server address, expected TestServer got %s", s.String())
+	}
+	if u := pci.Container.GetUpstream(); u.String() != "TestServer" {
+		t.Errorf("Invalid upstream, expected TestServer got %s", u.String())
+	}
+}
+
+// GetMetadata, Ensure proxies that aren't a `IMetadataProvider` have no metadata
+//
+// Expect: nil
+func TestGetMetadataUnsupported(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	if md := pci.Container.GetMetadata(); md != nil {
+		t.Errorf("Expected no metadata, got %+v", md)
+	}
+}
+
 func TestGetLastContactTime(t *testing.T) {
 	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
 	pci.Spawner.On("HandleSend", mock.Anything, mock.Anything, mock.Anything).Return(true)
@@ -434,3 +509,477 @@ func TestProxySendReturnError(t *testing.T) {
 		t.Fatalf("Expected GetLastContactTime to return %d, returned %d", 0, sent)
 	}
 }
+
+// IProxy that can half close
+type halfCloseProxy struct {
+	*mocks.IProxy
+	*mocks.IHalfCloser
+}
+
+// Eof, Ensure EOF packets are passed to the matching `IHalfCloser` method without going through `HandleSend`
+//
+// Expect: CloseServerWrite on serverbound EOF, CloseClientWrite on clientbound EOF, container stays open
+func TestProxyEofHalfClose(t *testing.T) {
+	tCtx, c := context.WithCancel(context.Background())
+	defer c()
+	sp := mocks.NewIProxySpawner(t)
+	sp.On("GetContext").Return(tCtx)
+	px := halfCloseProxy{mocks.NewIProxy(t), mocks.NewIHalfCloser(t)}
+	px.IProxy.On("GetClientAddr").Return(NewMockAddr("TestClient")).Maybe()
+	sp.On("GetServerAddr").Return(NewMockAddr("TestUpstream")).Maybe()
+	var pktChan chan<- handler.ProxyPacketData
+	px.IProxy.On("Init", mock.Anything, mock.Anything, mock.Anything).Return(nil).RunFn = func(a mock.Arguments) {
+		pktChan = a.Get(0).(chan<- handler.ProxyPacketData)
+	}
+	px.IHalfCloser.On("CloseServerWrite").Return(nil).Once()
+	px.IHalfCloser.On("CloseClientWrite").Return(nil).Once()
+	pc, err := handler.NewProxyContainer(sp, px, 0)
+	if err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	pktChan <- handler.ProxyPacketData{
+		Serverbound: true,
+		Source:      NewMockAddr("Source"),
+		Dest:        NewMockAddr("Dest"),
+		Eof:         true,
+	}
+	pktChan <- handler.ProxyPacketData{
+		Serverbound: false,
+		Source:      NewMockAddr("Source"),
+		Dest:        NewMockAddr("Dest"),
+		Eof:         true,
+	}
+	time.Sleep(time.Millisecond * 50)
+	if !pc.IsAlive() {
+		t.Errorf("Container was closed by EOF, the proxy should close itself")
+	}
+	if pc.GetBytesSent() != 0 {
+		t.Errorf("Expected GetBytesSent to return 0, returned %d", pc.GetBytesSent())
+	}
+}
+
+// Eof, Ensure a proxy that sends EOF without implementing `IHalfCloser` is closed
+//
+// Expect: Container closed with `handler.ErrProxyClosedOk`
+func TestProxyEofNoHalfClose(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	pci.PktChan <- handler.ProxyPacketData{
+		Serverbound: true,
+		Source:      NewMockAddr("Source"),
+		Dest:        NewMockAddr("Dest"),
+		Eof:         true,
+	}
+	time.Sleep(time.Millisecond * 50)
+	if pci.Container.IsAlive() {
+		t.Fatalf("Container wasn't closed on EOF")
+	}
+	cause := context.Cause(pci.ProxyContext)
+	if !errors.Is(cause, handler.ErrProxyClosedOk) {
+		t.Errorf("Container wasn't closed with ErrProxyClosedOk was %v", cause)
+	}
+}
+
+// Spliced, Ensure spliced byte counts are added to the stats without calling `HandleSend`
+//
+// Expect: bytesSent & lastContactTime updated, spawner told with `AddBytesSent`
+func TestProxySplicedBytes(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	pci.Bytes.On("AddBytesSent", uint64(1234)).Return().Once()
+	start := time.Now()
+	// Empty stats are only a barrier
+	pci.PktChan <- handler.ProxyPacketData{
+		Serverbound: true,
+		Source:      NewMockAddr("Source"),
+		Dest:        NewMockAddr("Dest"),
+		Spliced:     true,
+	}
+	pci.PktChan <- handler.ProxyPacketData{
+		Serverbound:  true,
+		Source:       NewMockAddr("Source"),
+		Dest:         NewMockAddr("Dest"),
+		Spliced:      true,
+		SplicedBytes: 1234,
+	}
+	time.Sleep(time.Millisecond * 50)
+	if pci.Container.GetBytesSent() != 1234 {
+		t.Errorf("Expected GetBytesSent to return 1234, returned %d", pci.Container.GetBytesSent())
+	}
+	if pci.Container.GetLastContactTime().Before(start) {
+		t.Errorf("lastContactTime wasn't updated")
+	}
+	if !pci.Container.IsAlive() {
+		t.Errorf("Container was closed by spliced stats")
+	}
+}
+
+// IProxy that can change server
+type serverChangerProxy struct {
+	*mocks.IProxy
+	*mocks.IServerChanger
+}
+
+// ChangeServer, Ensure the proxy is told to change server while the container is alive
+//
+// Expect: `px.ChangeServer` is called & its error returned, a error without calling it once closed
+func TestProxyChangeServer(t *testing.T) {
+	sc := mocks.NewIServerChanger(t)
+	pci := NewProxyContainerWith(t, NewMockAddr("TestClient"), 3, func(px *mocks.IProxy) handler.IProxy {
+		return serverChangerProxy{px, sc}
+	})
+	addr := NewMockAddr("NewServer")
+	sc.On("ChangeServer", addr).Return(nil).Once()
+	if err := pci.Container.ChangeServer(addr); err != nil {
+		t.Errorf("ChangeServer returned a error: %v", err)
+	}
+	sc.On("ChangeServer", addr).Return(errors.New("test error")).Once()
+	if err := pci.Container.ChangeServer(addr); err == nil {
+		t.Errorf("ChangeServer didn't return the proxy's error")
+	}
+	pci.Container.Close()
+	if err := pci.Container.ChangeServer(addr); err == nil {
+		t.Errorf("ChangeServer didn't return a error on a closed container")
+	}
+}
+
+// ChangeServer, Ensure a proxy that isn't a `IServerChanger` can't change server
+//
+// Expect: `handler.ErrUnsupported`
+func TestProxyChangeServerUnsupported(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	if err := pci.Container.ChangeServer(NewMockAddr("NewServer")); !errors.Is(err, handler.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+// IProxy that can change client
+type clientChangerProxy struct {
+	*mocks.IProxy
+	*mocks.IClientChanger
+}
+
+// ChangeClient, Ensure the proxy is given the new client while the container is alive
+//
+// Expect: `px.ChangeClient` is called & its error returned, a error without calling it once closed
+func TestProxyChangeClient(t *testing.T) {
+	cc := mocks.NewIClientChanger(t)
+	pci := NewProxyContainerWith(t, NewMockAddr("TestClient"), 3, func(px *mocks.IProxy) handler.IProxy {
+		return clientChangerProxy{px, cc}
+	})
+	client, other := net.Pipe()
+	defer client.Close()
+	defer other.Close()
+	cc.On("ChangeClient", client).Return(nil).Once()
+	if err := pci.Container.ChangeClient(client); err != nil {
+		t.Errorf("ChangeClient returned a error: %v", err)
+	}
+	cc.On("ChangeClient", client).Return(errors.New("test error")).Once()
+	if err := pci.Container.ChangeClient(client); err == nil {
+		t.Errorf("ChangeClient didn't return the proxy's error")
+	}
+	pci.Container.Close()
+	if err := pci.Container.ChangeClient(client); err == nil {
+		t.Errorf("ChangeClient didn't return a error on a closed container")
+	}
+}
+
+// ChangeClient, Ensure a proxy that isn't a `IClientChanger` can't change client
+//
+// Expect: `handler.ErrUnsupported`
+func TestProxyChangeClientUnsupported(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	client, other := net.Pipe()
+	defer client.Close()
+	defer other.Close()
+	if err := pci.Container.ChangeClient(client); !errors.Is(err, handler.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+// GetUpstream, Ensure the upstream is the server the proxy started on
+//
+// Expect: The server from when the container was made, even after the server changes
+func TestGetUpstream(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	pci.Spawner.On("GetServerAddr").Return(NewMockAddr("TestServer")).Maybe()
+	if u := pci.Container.GetUpstream(); u.String() != "TestUpstream" {
+		t.Errorf("Incorrect upstream, got %s expected TestUpstream", u.String())
+	}
+}
+
+// IProxy that knows the upstream it was given
+type upstreamProxy struct {
+	*mocks.IProxy
+	*mocks.IUpstreamTracker
+}
+
+// GetUpstream, Ensure the upstream the proxy was given is used over its resolved server
+//
+// Expect: The proxies upstream, the server without one
+func TestGetUpstreamFromProxy(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		upstream net.Addr
+		expect   string
+	}{
+		{"picked", NewMockAddr("backend:9000"), "backend:9000"},
+		{"not given", nil, "TestUpstream"},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			pci := NewProxyContainerWith(t, NewMockAddr("TestClient"), 3, func(px *mocks.IProxy) handler.IProxy {
+				ut := mocks.NewIUpstreamTracker(t)
+				ut.On("GetUpstream").Return(v.upstream)
+				return upstreamProxy{px, ut}
+			})
+			if u := pci.Container.GetUpstream(); u.String() != v.expect {
+				t.Errorf("Incorrect upstream, got %s expected %s", u.String(), v.expect)
+			}
+		})
+	}
+}
+
+// GetResumeToken, Ensure every container gets a different token
+//
+// Expect: Tokens are 32 hex characters & unique
+func TestGetResumeToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for k := range 10 {
+		token := NewProxyContainer(t, NewMockAddr("TestClient"), k).Container.GetResumeToken()
+		if len(token) != 32 {
+			t.Errorf("Token %q isn't 32 characters", token)
+		}
+		if seen[token] {
+			t.Errorf("Token %q was given twice", token)
+		}
+		seen[token] = true
+	}
+}
+
+// IProxy that can add clients
+type clientAdderProxy struct {
+	*mocks.IProxy
+	*mocks.IClientAdder
+}
+
+// AddClient, Ensure only writers & observers are given to the proxy while the container is alive
+//
+// Expect: `px.AddClient` is called & its error returned, a error without calling it for replace or once closed
+func TestProxyAddClient(t *testing.T) {
+	ca := mocks.NewIClientAdder(t)
+	pci := NewProxyContainerWith(t, NewMockAddr("TestClient"), 3, func(px *mocks.IProxy) handler.IProxy {
+		return clientAdderProxy{px, ca}
+	})
+	client, other := net.Pipe()
+	defer client.Close()
+	defer other.Close()
+	ca.On("AddClient", client, handler.ClientRoleWriter).Return(nil).Once()
+	if err := pci.Container.AddClient(client, handler.ClientRoleWriter); err != nil {
+		t.Errorf("AddClient returned a error: %v", err)
+	}
+	ca.On("AddClient", client, handler.ClientRoleObserver).Return(errors.New("test error")).Once()
+	if err := pci.Container.AddClient(client, handler.ClientRoleObserver); err == nil {
+		t.Errorf("AddClient didn't return the proxy's error")
+	}
+	if err := pci.Container.AddClient(client, handler.ClientRoleReplace); err == nil {
+		t.Errorf("AddClient added a client with the replace role")
+	}
+	pci.Container.Close()
+	if err := pci.Container.AddClient(client, handler.ClientRoleWriter); err == nil {
+		t.Errorf("AddClient didn't return a error on a closed container")
+	}
+}
+
+// AddClient, Ensure a proxy that isn't a `IClientAdder` can't add clients
+//
+// Expect: `handler.ErrUnsupported`
+func TestProxyAddClientUnsupported(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	client, other := net.Pipe()
+	defer client.Close()
+	defer other.Close()
+	if err := pci.Container.AddClient(client, handler.ClientRoleWriter); !errors.Is(err, handler.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+// Local TCP server standing in for a shadow server, its first connection is sent on the channel
+func newShadowServer(t *testing.T) (net.Addr, <-chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			conns <- c
+		}
+	}()
+	return l.Addr(), conns
+}
+
+func acceptShadow(t *testing.T, conns <-chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case c := <-conns:
+		t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(time.Second):
+		t.Fatalf("Mirror didn't connect to the shadow server")
+		return nil
+	}
+}
+
+// Mirror, Ensure serverbound packets are copied by the policy
+//
+// Expect: Forwarded & dropped packets are copied, clientbound & injected ones aren't. Invalid mirrors fail
+func TestProxyMirrorPolicy(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	defer pci.Cancel()
+	addr, conns := newShadowServer(t)
+	pci.Proxy.On("Network").Return("tcp")
+	pci.Proxy.On("SendToServer", mock.Anything).Return(nil)
+	pci.Proxy.On("SendToClient", mock.Anything).Return(nil)
+	pci.Spawner.On("HandleSend", []byte("forwarded"), mock.Anything, mock.Anything).Return(true)
+	pci.Spawner.On("HandleSend", []byte("dropped"), mock.Anything, mock.Anything).Return(false)
+	pci.Spawner.On("HandleSend", []byte("clientbound"), mock.Anything, mock.Anything).Return(true)
+	pci.Spawner.On("HandleSend", []byte("injected"), mock.Anything, mock.Anything).Return(true)
+	if err := pci.Container.SetMirror(&handler.Mirror{}); err == nil {
+		t.Errorf("Set a mirror without an address")
+	}
+	if pci.Container.GetMirror() != nil {
+		t.Errorf("Got a mirror status without a mirror")
+	}
+	err := pci.Container.SetMirror(&handler.Mirror{Addr: addr, Policy: handler.MirrorForwarded | handler.MirrorDropped})
+	if err != nil {
+		t.Fatalf("Failed to set mirror: %v", err)
+	}
+	shadow := acceptShadow(t, conns)
+	if err := pci.Container.SendToServer([]byte("injected")); err != nil {
+		t.Fatalf("Failed to inject: %v", err)
+	}
+	pci.PktChan <- handler.ProxyPacketData{Serverbound: false, Data: []byte("clientbound")}
+	pci.PktChan <- handler.ProxyPacketData{Serverbound: true, Data: []byte("forwarded")}
+	pci.PktChan <- handler.ProxyPacketData{Serverbound: true, Data: []byte("dropped")}
+	expected := "forwardeddropped"
+	shadow.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(expected))
+	if _, err := io.ReadFull(shadow, got); err != nil || string(got) != expected {
+		t.Fatalf("Shadow server got %q (%v), expected %q", got, err, expected)
+	}
+	shadow.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	if n, _ := shadow.Read(got); n != 0 {
+		t.Errorf("Shadow server got packets outside the policy %q", got[:n])
+	}
+	status := pci.Container.GetMirror()
+	if status == nil || !status.Active || status.Address != addr || status.Policy != handler.MirrorForwarded|handler.MirrorDropped {
+		t.Errorf("Incorrect mirror status, got %+v", status)
+	}
+	if err := pci.Container.SetMirror(nil); err != nil {
+		t.Fatalf("Failed to stop mirror: %v", err)
+	}
+	if pci.Container.GetMirror() != nil {
+		t.Errorf("Mirror wasn't removed")
+	}
+	shadow.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := shadow.Read(got); err != io.EOF {
+		t.Errorf("Shadow connection wasn't closed when the mirror stopped, got %v", err)
+	}
+	pci.Container.Close()
+	if err := pci.Container.SetMirror(&handler.Mirror{Addr: addr}); err == nil {
+		t.Errorf("Set a mirror on a closed container")
+	}
+}
+
+// Mirror, Ensure shadow replies are recorded & never sent to the client
+//
+// Expect: `HandleMirrorReply` gets the reply, the mirror stops when the container closes
+func TestProxyMirrorRecord(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	defer pci.Cancel()
+	addr, conns := newShadowServer(t)
+	pci.Proxy.On("Network").Return("tcp")
+	recorded := make(chan struct{})
+	pci.Mirrors.On("HandleMirrorReply", []byte("reply"), addr, pci.Container).Return().Once().Run(func(args mock.Arguments) {
+		close(recorded)
+	})
+	if err := pci.Container.SetMirror(&handler.Mirror{Addr: addr, Record: true}); err != nil {
+		t.Fatalf("Failed to set mirror: %v", err)
+	}
+	shadow := acceptShadow(t, conns)
+	shadow.Write([]byte("reply"))
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatalf("Shadow reply wasn't recorded")
+	}
+	if status := pci.Container.GetMirror(); status.BytesReceived != 5 {
+		t.Errorf("Expected 5 bytes received, got %d", status.BytesReceived)
+	}
+	pci.Container.Close()
+	if status := pci.Container.GetMirror(); status.Active || status.Error == "" {
+		t.Errorf("Mirror is still active after the container closed, got %+v", status)
+	}
+}
+
+// Mirror, Ensure a shadow server that can't be reached only stops the mirror
+//
+// Expect: Mirror isn't active & has a error, container stays alive
+func TestProxyMirrorDialFail(t *testing.T) {
+	pci := NewProxyContainer(t, NewMockAddr("TestClient"), 3)
+	defer pci.Cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	l.Close()
+	pci.Proxy.On("Network").Return("tcp")
+	if err := pci.Container.SetMirror(&handler.Mirror{Addr: l.Addr()}); err != nil {
+		t.Fatalf("Failed to set mirror: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for pci.Container.GetMirror().Active && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if status := pci.Container.GetMirror(); status.Active || !strings.Contains(status.Error, "failed to dial") {
+		t.Errorf("Mirror didn't stop after failing to dial, got %+v", status)
+	}
+	if !pci.Container.IsAlive() {
+		t.Errorf("Container closed when the mirror failed")
+	}
+}
+
+// Mirror, Ensure new proxies get the spawners mirror & serverbound EOF closes the shadows write side
+//
+// Expect: Shadow server gets the data then EOF
+func TestProxyMirrorEof(t *testing.T) {
+	tCtx, c := context.WithCancel(context.Background())
+	defer c()
+	addr, conns := newShadowServer(t)
+	sp := mirrorSpawner{mocks.NewIProxySpawner(t), mocks.NewIMirrorManager(t)}
+	sp.IProxySpawner.On("GetContext").Return(tCtx)
+	sp.IProxySpawner.On("HandleSend", mock.Anything, mock.Anything, mock.Anything).Return(true)
+	sp.IMirrorManager.On("GetMirror").Return(&handler.Mirror{Addr: addr})
+	px := halfCloseProxy{mocks.NewIProxy(t), mocks.NewIHalfCloser(t)}
+	px.IProxy.On("GetClientAddr").Return(NewMockAddr("TestClient")).Maybe()
+	sp.IProxySpawner.On("GetServerAddr").Return(NewMockAddr("TestUpstream")).Maybe()
+	px.IProxy.On("Network").Return("tcp")
+	px.IProxy.On("SendToServer", []byte("data")).Return(nil).Once()
+	var pktChan chan<- handler.ProxyPacketData
+	px.IProxy.On("Init", mock.Anything, mock.Anything, mock.Anything).Return(nil).RunFn = func(a mock.Arguments) {
+		pktChan = a.Get(0).(chan<- handler.ProxyPacketData)
+	}
+	px.IHalfCloser.On("CloseServerWrite").Return(nil).Once()
+	pc, err := handler.NewProxyContainer(sp, px, 0)
+	if err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	defer pc.Cancel(handler.ErrProxyClosedOk)
+	pktChan <- handler.ProxyPacketData{Serverbound: true, Data: []byte("data")}
+	pktChan <- handler.ProxyPacketData{Serverbound: true, Eof: true}
+	shadow := acceptShadow(t, conns)
+	shadow.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := io.ReadAll(shadow)
+	if err != nil || string(got) != "data" {
+		t.Errorf("Shadow server got %q (%v), expected %q then EOF", got, err, "data")
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import "fmt"
+
+// What a client given to a running proxy does, see IClientAdopter
+type ClientRole int
+
+const (
+	ClientRoleReplace  ClientRole = 0 // Replaces the proxies client, the old one is dropped
+	ClientRoleWriter   ClientRole = 1 // Joins the other clients, gets data from the server & its data is sent to the server
+	ClientRoleObserver ClientRole = 2 // Joins the other clients, only gets data from the server. Data it sends is dropped
+)
+
+func (r ClientRole) String() string {
+	switch r {
+	case ClientRoleReplace:
+		return "replace"
+	case ClientRoleWriter:
+		return "writer"
+	case ClientRoleObserver:
+		return "observer"
+	default:
+		return fmt.Sprintf("ClientRole(%d)", int(r))
+	}
+}
+
+// Checks if r is a known role
+func (r ClientRole) IsValid() bool {
+	return r >= ClientRoleReplace && r <= ClientRoleObserver
+}
+
+// Parses a role from its String form
+func ParseClientRole(s string) (ClientRole, error) {
+	for _, r := range []ClientRole{ClientRoleReplace, ClientRoleWriter, ClientRoleObserver} {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown client role %q", s)
+}
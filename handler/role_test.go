@@ -0,0 +1,21 @@
+package handler_test
+
+import (
+	"ezproxy/handler"
+	"testing"
+)
+
+func TestParseClientRole(t *testing.T) {
+	for _, r := range []handler.ClientRole{handler.ClientRoleReplace, handler.ClientRoleWriter, handler.ClientRoleObserver} {
+		got, err := handler.ParseClientRole(r.String())
+		if err != nil || got != r {
+			t.Errorf("expected '%s' to parse to %d got %d %v", r, r, got, err)
+		}
+	}
+	if _, err := handler.ParseClientRole("reader"); err == nil {
+		t.Errorf("expected a error for a unknown role")
+	}
+	if handler.ClientRole(3).IsValid() || handler.ClientRole(-1).IsValid() {
+		t.Errorf("expected roles outside the known ones to be invalid")
+	}
+}
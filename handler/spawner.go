@@ -2,10 +2,16 @@ package handler
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,7 +28,14 @@ type ProxySpawner struct {
 	connections        map[int]IProxyContainer // Connections
 	connectionLock     sync.Mutex              // Lock for connections
 	totalSent          uint64                  // Total bytes sent
-	serverAddr         net.Addr                // Server address
+	upstreams          []net.Addr              // Servers new proxies connect to, the first is the server address. Guarded by serverAddrLock
+	strategy           UpstreamStrategy        // How upstreams are picked, guarded by serverAddrLock
+	serverAddrLock     sync.RWMutex
+	upstreamNext       atomic.Uint64              // Next upstream for round-robin & least-connections ties
+	health             map[string]*upstreamHealth // Health by upstream address, guarded by healthLock. Upstreams without an entry are healthy
+	healthChans        []*healthChan
+	healthCancel       context.CancelFunc // Stops the running health checks, guarded by healthLock
+	healthLock         sync.Mutex
 	proxyAddr          net.Addr                // Proxy address
 	context            context.Context         // Context for the spawner, this is the parent of all contexts
 	contextCancel      context.CancelCauseFunc // Cancel function
@@ -35,7 +48,24 @@ type ProxySpawner struct {
 	rcChanLock         sync.Mutex
 	callbackCtx        context.Context
 	callbackCancel     context.CancelFunc
+	callbackLock       sync.RWMutex // Guards sendCallback, callbackCtx & callbackCancel
 	totalSentWriteLock sync.Mutex
+	observedLock       sync.Mutex
+	observedChanged    chan struct{}             // Closed & replaced when an observer is added or removed
+	expected           map[string]expectedClient // Proxies waiting for a new client, by client host
+	expectedLock       sync.Mutex
+	mirror             *Mirror          // Mirror new proxies may get, guarded by mirrorLock
+	mirrored           map[int]struct{} // Proxies with a mirror that keep the spawner observed, guarded by mirrorLock
+	mirrorLock         sync.Mutex
+	listeners          []*spawnerListener // Listeners in the order they were added, guarded by listenerLock
+	listenerLock       sync.Mutex
+}
+
+// Proxy waiting for a new client from ExpectClient
+type expectedClient struct {
+	id    int
+	role  ClientRole
+	until time.Time
 }
 
 // Adds a new proxy, returns the proxies ID or a error if something goes wrong
@@ -67,23 +97,39 @@ func (p *ProxySpawner) AddConnection(px IProxy) (IProxyContainer, error) {
 	return pc, nil
 }
 
-func (p *ProxySpawner) addListener(h IProxyListener) {
+// Runs a listener until stopCtx is cancelled or it fails, done is closed once it returns. p.wg must have been added to.
+func (p *ProxySpawner) runListener(sl *spawnerListener, ca IConnectionAdder, stopCtx context.Context, done chan struct{}) {
+	var cause error
+	defer func() {
+		p.listenerLock.Lock()
+		if sl.done == done {
+			sl.cancel(ErrProxyClosedOk)
+			sl.cancel = nil
+			if !errors.Is(cause, ErrProxyClosedOk) {
+				sl.err = cause
+			}
+		}
+		p.listenerLock.Unlock()
+		close(done)
+		p.wg.Done()
+	}()
 	if p.context.Err() != nil {
-		p.logger.Error("Attempted to addListener with dead context", "Error", p.context.Err(), "Cause", context.Cause(p.context))
+		p.logger.Error("Attempted to run listener with dead context", "Error", p.context.Err(), "Cause", context.Cause(p.context))
+		cause = context.Cause(p.context)
 		return
 	}
 	retryCount := 0
-	p.wg.Add(1)
-	defer p.wg.Done()
 	for {
-		p.logger.Debug("Running a new listener")
-		ctx, cancel := context.WithCancelCause(p.context)
-		h(ctx, cancel, p)
+		p.logger.Debug("Running a new listener", "Name", sl.Name)
+		ctx, cancel := context.WithCancelCause(stopCtx)
+		sl.Listener(ctx, cancel, ca)
 		if ctx.Err() == nil {
-			p.contextCancel(errors.New("listener didn't cancel context after return"))
+			cause = errors.New("listener didn't cancel context after return")
+			cancel(cause)
+			p.contextCancel(cause)
 			return
 		}
-		cause := context.Cause(ctx)
+		cause = context.Cause(ctx)
 		if errors.Is(cause, ErrProxyRetry) {
 			// Retry the connection
 			if retryCount >= 3 {
@@ -126,27 +172,23 @@ func (p *ProxySpawner) HandleSend(data []byte, flags CapFlags, pc IProxyContaine
 	p.totalSentWriteLock.Lock()
 	p.totalSent += uint64(len(data))
 	p.totalSentWriteLock.Unlock()
-	if p.sendCallback != nil {
-		if p.callbackCtx.Err() != nil {
-			p.logger.Debug("Callback context was closed, freeing filter callback", "Cause", context.Cause(p.callbackCtx), "Error", p.callbackCtx.Err().Error())
-			p.callbackCancel()
-			p.callbackCancel = nil
-			p.callbackCtx = nil
-			p.sendCallback = nil
-		} else {
-			p.logger.Debug("Calling sendCallback", "Data", data, "Flags", flags)
-			cbResult := p.sendCallback(data, flags, pc)
-			// If the callback says drop & this isn't injected we return early, not sending to recvChans
-			// if injected or allowed we keep going.
-			if !cbResult && !flags.IsInjected() {
-				return false
-			}
+	if sendCallback := p.getCallback(); sendCallback != nil {
+		p.logger.Debug("Calling sendCallback", "Data", data, "Flags", flags)
+		cbResult := sendCallback(data, flags, pc)
+		// If the callback says drop & this isn't injected we return early, not sending to recvChans
+		// if injected or allowed we keep going.
+		if !cbResult && !flags.IsInjected() {
+			return false
 		}
 	} else {
 		p.logger.Debug("No sendCallback, forwarding packet", "Data", data, "Flags", flags)
 	}
 	// Only send to the channels if we aren't dropping the packet
 	p.rcChanLock.Lock()
+	if len(p.rcChan) > 0 {
+		// data may be a pooled buffer that is reused once this returns
+		pktData.Data = append([]byte(nil), data...)
+	}
 	for i, v := range p.rcChan {
 		if v.ctx.Err() == nil {
 			select {
@@ -161,6 +203,117 @@ func (p *ProxySpawner) HandleSend(data []byte, flags CapFlags, pc IProxyContaine
 	return true
 }
 
+// Sends a reply from pcs shadow server to the recv channels, it doesn't go through the filter callback or count as sent
+func (p *ProxySpawner) HandleMirrorReply(data []byte, source net.Addr, pc IProxyContainer) {
+	p.rcChanLock.Lock()
+	defer p.rcChanLock.Unlock()
+	if len(p.rcChan) == 0 {
+		return
+	}
+	pktData := PacketChanData{
+		Flags:   CapFlag_Mirrored,
+		Source:  source,
+		Dest:    pc.GetClientAddr(),
+		Data:    append([]byte(nil), data...),
+		ProxyId: pc.GetId(),
+	}
+	for i, v := range p.rcChan {
+		if v.ctx.Err() == nil {
+			select {
+			case v.Recv <- pktData:
+			default:
+				p.logger.Warn("Packet data not handled on channel", "Index", i)
+			}
+		}
+	}
+}
+
+// Counts bytes a proxy forwarded itself
+func (p *ProxySpawner) AddBytesSent(n uint64) {
+	p.totalSentWriteLock.Lock()
+	p.totalSent += n
+	p.totalSentWriteLock.Unlock()
+}
+
+// Gets the filter callback, nil if there isn't one. A callback whose context is done is freed
+func (p *ProxySpawner) getCallback() PacketSendCallback {
+	p.callbackLock.RLock()
+	cb, cbCtx := p.sendCallback, p.callbackCtx
+	p.callbackLock.RUnlock()
+	if cb == nil || cbCtx.Err() == nil {
+		return cb
+	}
+	p.callbackLock.Lock()
+	defer p.callbackLock.Unlock()
+	// It may have been replaced while unlocked
+	if p.callbackCtx == cbCtx {
+		p.freeCallback()
+	}
+	return nil
+}
+
+// Frees a filter callback whose context is done, callbackLock must be held
+func (p *ProxySpawner) freeCallback() {
+	p.logger.Debug("Callback context was closed, freeing filter callback", "Cause", context.Cause(p.callbackCtx), "Error", p.callbackCtx.Err().Error())
+	p.callbackCancel()
+	p.callbackCancel = nil
+	p.callbackCtx = nil
+	p.sendCallback = nil
+}
+
+// Checks if a filter callback, recv channel or mirror is active, if not proxies don't need to send packets through HandleSend
+func (p *ProxySpawner) IsObserved() bool {
+	p.callbackLock.RLock()
+	cbCtx, callback := p.callbackCtx, p.sendCallback != nil
+	p.callbackLock.RUnlock()
+	if callback && cbCtx != nil && cbCtx.Err() == nil {
+		return true
+	}
+	p.mirrorLock.Lock()
+	mirroring := p.mirror != nil || len(p.mirrored) != 0
+	p.mirrorLock.Unlock()
+	if mirroring {
+		return true
+	}
+	p.rcChanLock.Lock()
+	defer p.rcChanLock.Unlock()
+	for _, v := range p.rcChan {
+		if v.ctx.Err() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets a channel that is closed the next time IsObserved may have changed
+func (p *ProxySpawner) ObservedChanged() <-chan struct{} {
+	p.observedLock.Lock()
+	defer p.observedLock.Unlock()
+	return p.observedChanged
+}
+
+// Wakes everything waiting on ObservedChanged, again once ctx is done.
+// Nothing is woken once the spawner is closed, so nothing touches it after Close
+func (p *ProxySpawner) notifyObserved(ctx context.Context) {
+	p.observedLock.Lock()
+	changed := p.observedChanged
+	p.observedChanged = make(chan struct{})
+	p.observedLock.Unlock()
+	// Closed last so waiters see the new channel
+	close(changed)
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				if p.context.Err() == nil {
+					p.notifyObserved(nil)
+				}
+			case <-p.context.Done():
+			}
+		}()
+	}
+}
+
 // Deprecated: Log errors or cancel the context
 //
 // Error callback
@@ -181,7 +334,7 @@ func (p *ProxySpawner) HandleError(err error, pc IProxyContainer) {
 // Pruner for all proxies
 // Runs every second and removes anything that's IsAlive is false
 func (p *ProxySpawner) pruner() {
-	p.wg.Add(1)
+	defer p.wg.Done()
 	ticker := time.NewTicker(time.Second * 1)
 	for {
 
@@ -204,6 +357,11 @@ func (p *ProxySpawner) pruner() {
 				}
 			}
 			p.connectionLock.Unlock()
+			p.mirrorLock.Lock()
+			for _, v := range deleteKeys {
+				delete(p.mirrored, v)
+			}
+			p.mirrorLock.Unlock()
 			// Prune recvChans
 			deleteKeys = make([]int, 0)
 			for i, v := range p.rcChan {
@@ -235,9 +393,456 @@ func (p *ProxySpawner) GetProxyAddr() net.Addr {
 	return p.proxyAddr
 }
 
-// Get the address of the server
+// Get the address of the server, this is the first upstream
 func (p *ProxySpawner) GetServerAddr() net.Addr {
-	return p.serverAddr
+	p.serverAddrLock.RLock()
+	defer p.serverAddrLock.RUnlock()
+	return p.upstreams[0]
+}
+
+// Changes the server new proxies connect to, it must differ from the proxy address.
+// This is the first upstream, the other upstreams & the strategy are kept
+func (p *ProxySpawner) SetServerAddr(addr net.Addr) error {
+	if addr.Network() == p.proxyAddr.Network() && addr.String() == p.proxyAddr.String() {
+		return errors.New("server address and proxy address must be different")
+	}
+	p.serverAddrLock.Lock()
+	// Copied, GetUpstreams & PickServerAddr may still be using the old list
+	upstreams := append([]net.Addr{}, p.upstreams...)
+	upstreams[0] = addr
+	p.upstreams = upstreams
+	p.serverAddrLock.Unlock()
+	p.logger.Info("Changed server address", "ServerAddress", addr.String())
+	return nil
+}
+
+// Sets the servers new proxies connect to & how one is picked for each, running proxies stay on their server.
+// The first upstream is also the server address, none can be the proxy address.
+func (p *ProxySpawner) SetUpstreams(addrs []net.Addr, strategy UpstreamStrategy) error {
+	if len(addrs) == 0 {
+		return errors.New("at least one upstream is needed")
+	}
+	if !strategy.IsValid() {
+		return fmt.Errorf("invalid upstream strategy %d", int(strategy))
+	}
+	names := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.Network() == p.proxyAddr.Network() && addr.String() == p.proxyAddr.String() {
+			return errors.New("upstream addresses and proxy address must be different")
+		}
+		names = append(names, addr.String())
+	}
+	p.serverAddrLock.Lock()
+	p.upstreams = append([]net.Addr{}, addrs...)
+	p.strategy = strategy
+	p.serverAddrLock.Unlock()
+	p.logger.Info("Changed upstreams", "Upstreams", names, "Strategy", strategy.String())
+	return nil
+}
+
+// Gets the upstreams with their health & the number of running proxies that started on each
+func (p *ProxySpawner) GetUpstreams() []UpstreamStatus {
+	p.serverAddrLock.RLock()
+	upstreams := p.upstreams
+	p.serverAddrLock.RUnlock()
+	counts := p.upstreamCounts()
+	status := make([]UpstreamStatus, 0, len(upstreams))
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	for _, addr := range upstreams {
+		s := UpstreamStatus{Address: addr, Connections: counts[addr.String()], Healthy: true}
+		if h, ok := p.health[addr.String()]; ok {
+			s.Healthy, s.Error = h.healthy, h.lastError
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// Starts checking the health of every upstream, unhealthy upstreams aren't picked for new proxies until they recover.
+// Replaces the running checks, nil stops them & every upstream is healthy again.
+func (p *ProxySpawner) SetHealthCheck(check *HealthCheck) error {
+	if check != nil && check.Probe == nil {
+		return errors.New("health check has no probe")
+	}
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	if p.healthCancel != nil {
+		p.healthCancel()
+		p.healthCancel = nil
+	}
+	for _, h := range p.health {
+		if !h.healthy {
+			p.sendHealthEvent(UpstreamHealthEvent{Address: h.addr, Healthy: true})
+		}
+	}
+	p.health = make(map[string]*upstreamHealth)
+	if check == nil {
+		p.logger.Info("Stopped health checks")
+		return nil
+	}
+	check = check.withDefaults()
+	var ctx context.Context
+	ctx, p.healthCancel = context.WithCancel(p.context)
+	go p.healthLoop(ctx, check)
+	p.logger.Info("Started health checks", "Interval", check.Interval, "Timeout", check.Timeout, "Rise", check.Rise, "Fall", check.Fall)
+	return nil
+}
+
+// Checks every upstream each interval until ctx is done
+func (p *ProxySpawner) healthLoop(ctx context.Context, check *HealthCheck) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	for {
+		p.checkUpstreams(ctx, check)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probes every upstream at once & updates their health
+func (p *ProxySpawner) checkUpstreams(ctx context.Context, check *HealthCheck) {
+	p.serverAddrLock.RLock()
+	upstreams := p.upstreams
+	p.serverAddrLock.RUnlock()
+	results := make([]error, len(upstreams))
+	var wg sync.WaitGroup
+	for k, addr := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pCtx, cancel := context.WithTimeout(ctx, check.Timeout)
+			defer cancel()
+			results[k] = check.Probe(pCtx, addr)
+		}()
+	}
+	wg.Wait()
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	// Checks were stopped or replaced while probing
+	if ctx.Err() != nil {
+		return
+	}
+	current := make(map[string]*upstreamHealth, len(upstreams))
+	for k, addr := range upstreams {
+		h, ok := p.health[addr.String()]
+		if !ok {
+			h = &upstreamHealth{addr: addr, healthy: true}
+		}
+		current[addr.String()] = h
+		if err := results[k]; err != nil {
+			h.successes = 0
+			h.failures++
+			h.lastError = err.Error()
+			p.logger.Debug("Upstream health check failed", "Upstream", addr.String(), "Failures", h.failures, "Error", err.Error())
+			if h.healthy && h.failures >= check.Fall {
+				h.healthy = false
+				p.logger.Warn("Upstream is unhealthy", "Upstream", addr.String(), "Error", err.Error())
+				p.sendHealthEvent(UpstreamHealthEvent{Address: addr, Healthy: false, Error: h.lastError})
+			}
+			continue
+		}
+		h.failures = 0
+		h.successes++
+		if !h.healthy && h.successes >= check.Rise {
+			h.healthy = true
+			h.lastError = ""
+			p.logger.Info("Upstream is healthy", "Upstream", addr.String())
+			p.sendHealthEvent(UpstreamHealthEvent{Address: addr, Healthy: true})
+		}
+	}
+	// Upstreams that were removed are forgotten
+	p.health = current
+}
+
+// Sends a event to every health receiver, receivers that are behind miss it. healthLock must be held
+func (p *ProxySpawner) sendHealthEvent(event UpstreamHealthEvent) {
+	for _, hc := range p.healthChans {
+		if hc.ctx.Err() != nil {
+			continue
+		}
+		select {
+		case hc.ch <- event:
+		default:
+			p.logger.Debug("Health receiver is behind, dropping event", "Upstream", event.Address.String())
+		}
+	}
+}
+
+// Gets a channel of upstream health changes, it's closed after ctx is done
+func (p *ProxySpawner) GetHealthChan(ctx context.Context) <-chan UpstreamHealthEvent {
+	hc := &healthChan{ch: make(chan UpstreamHealthEvent, healthChanSize), ctx: ctx}
+	p.healthLock.Lock()
+	p.healthChans = append(p.healthChans, hc)
+	p.healthLock.Unlock()
+	go func() {
+		<-ctx.Done()
+		p.healthLock.Lock()
+		for k, v := range p.healthChans {
+			if v == hc {
+				p.healthChans = append(p.healthChans[:k], p.healthChans[k+1:]...)
+				break
+			}
+		}
+		p.healthLock.Unlock()
+		// Nothing sends to it once it's removed
+		close(hc.ch)
+	}()
+	return hc.ch
+}
+
+// Gets the upstreams that can be picked, every upstream if none are healthy
+func (p *ProxySpawner) healthyUpstreams(upstreams []net.Addr) []net.Addr {
+	p.healthLock.Lock()
+	defer p.healthLock.Unlock()
+	if len(p.health) == 0 {
+		return upstreams
+	}
+	healthy := make([]net.Addr, 0, len(upstreams))
+	for _, addr := range upstreams {
+		if h, ok := p.health[addr.String()]; !ok || h.healthy {
+			healthy = append(healthy, addr)
+		}
+	}
+	if len(healthy) == 0 {
+		p.logger.Debug("No healthy upstreams, picking from all of them")
+		return upstreams
+	}
+	return healthy
+}
+
+// Gets how upstreams are picked
+func (p *ProxySpawner) GetUpstreamStrategy() UpstreamStrategy {
+	p.serverAddrLock.RLock()
+	defer p.serverAddrLock.RUnlock()
+	return p.strategy
+}
+
+// Counts running proxies by the upstream they started on, proxies that aren't a IUpstreamTracker aren't counted
+func (p *ProxySpawner) upstreamCounts() map[string]int {
+	counts := make(map[string]int)
+	p.connectionLock.Lock()
+	defer p.connectionLock.Unlock()
+	for _, pc := range p.connections {
+		ut, ok := pc.(IUpstreamTracker)
+		if !ok || !pc.IsAlive() {
+			continue
+		}
+		if addr := ut.GetUpstream(); addr != nil {
+			counts[addr.String()]++
+		}
+	}
+	return counts
+}
+
+// Picks the upstream for a new proxy from client with the upstream strategy, unhealthy upstreams are skipped.
+// Clients on unix sockets have no IP, with UpstreamClientHash they all get the same upstream.
+func (p *ProxySpawner) PickServerAddr(client net.Addr) net.Addr {
+	p.serverAddrLock.RLock()
+	upstreams, strategy := p.upstreams, p.strategy
+	p.serverAddrLock.RUnlock()
+	upstreams = p.healthyUpstreams(upstreams)
+	if len(upstreams) == 1 {
+		return upstreams[0]
+	}
+	n := uint64(len(upstreams))
+	switch strategy {
+	case UpstreamLeastConnections:
+		counts := p.upstreamCounts()
+		start := p.upstreamNext.Add(1) - 1
+		best := upstreams[start%n]
+		for k := uint64(1); k < n; k++ {
+			addr := upstreams[(start+k)%n]
+			if counts[addr.String()] < counts[best.String()] {
+				best = addr
+			}
+		}
+		return best
+	case UpstreamRandom:
+		return upstreams[rand.Uint64N(n)]
+	case UpstreamClientHash:
+		h := fnv.New64a()
+		h.Write([]byte(clientHost(client)))
+		return upstreams[h.Sum64()%n]
+	default:
+		return upstreams[(p.upstreamNext.Add(1)-1)%n]
+	}
+}
+
+// Resolves a address from a user, such as the API, for SetServerAddr or ChangeServer.
+// "unix:" & "unixgram:" prefixes resolve unix sockets, anything else is a (IP):(Port) address. UDP proxies use the same IP & port.
+func ResolveAddr(address string) (net.Addr, error) {
+	for _, network := range []string{"unixgram", "unix"} {
+		if path, found := strings.CutPrefix(address, network+":"); found {
+			return net.ResolveUnixAddr(network, path)
+		}
+	}
+	return net.ResolveTCPAddr("tcp", address)
+}
+
+// Gets the host part of a client address, unix sockets have no host
+func clientHost(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	default:
+		return ""
+	}
+}
+
+// Mirrors m.Sample of new proxies to m, they are picked by the container. Running proxies keep their mirror.
+// nil stops mirroring new proxies
+func (p *ProxySpawner) SetMirror(m *Mirror) error {
+	if m != nil {
+		var err error
+		if m, err = m.withDefaults(); err != nil {
+			return err
+		}
+		p.logger.Info("Mirroring new proxies", "Address", m.Addr.String(), "Sample", m.Sample, "Policy", m.Policy, "Record", m.Record)
+	} else {
+		p.logger.Info("Stopped mirroring new proxies")
+	}
+	p.mirrorLock.Lock()
+	if p.mirror != nil {
+		// Proxies picked by the old sample were only kept observed by it
+		for _, pc := range p.GetAllProxies() {
+			if mp, ok := pc.(IMirrorable); ok && mp.GetMirror() != nil {
+				p.mirrored[pc.GetId()] = struct{}{}
+			}
+		}
+	}
+	p.mirror = m
+	p.mirrorLock.Unlock()
+	p.notifyObserved(nil)
+	return nil
+}
+
+// Gets the mirror new proxies may get, nil if there isn't one
+func (p *ProxySpawner) GetMirror() *Mirror {
+	p.mirrorLock.Lock()
+	defer p.mirrorLock.Unlock()
+	if p.mirror == nil {
+		return nil
+	}
+	m := *p.mirror
+	return &m
+}
+
+// Sets the mirror of proxy id, nil stops it
+func (p *ProxySpawner) MirrorProxy(id int, m *Mirror) error {
+	pc, err := p.GetProxy(id)
+	if err != nil {
+		return err
+	}
+	mp, ok := pc.(IMirrorable)
+	if !ok {
+		return fmt.Errorf("can't mirror proxy: %w", ErrUnsupported)
+	}
+	if err := mp.SetMirror(m); err != nil {
+		return err
+	}
+	p.mirrorLock.Lock()
+	if m != nil {
+		p.mirrored[id] = struct{}{}
+	} else {
+		delete(p.mirrored, id)
+	}
+	p.mirrorLock.Unlock()
+	// Spliced proxies have to start passing packets to the container
+	p.notifyObserved(nil)
+	return nil
+}
+
+// Gives the next client connection from host to proxy id with role, instead of making a new proxy for it. host is a IP or "" for any client.
+// Only listeners that check IClientAdopter do this, a later call for the same host replaces the earlier one.
+func (p *ProxySpawner) ExpectClient(id int, host string, role ClientRole, timeout time.Duration) error {
+	if _, err := p.GetProxy(id); err != nil {
+		return err
+	}
+	if !role.IsValid() {
+		return fmt.Errorf("invalid client role %d", int(role))
+	}
+	if host != "" {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return errors.New("host must be a IP address")
+		}
+		host = ip.String()
+	}
+	p.expectedLock.Lock()
+	p.expected[host] = expectedClient{id: id, role: role, until: time.Now().Add(timeout)}
+	p.expectedLock.Unlock()
+	p.logger.Info("Expecting new client", "Id", id, "Host", host, "Role", role.String(), "Timeout", timeout)
+	return nil
+}
+
+// Replaces the client of pc or adds client to it, depending on role
+func giveClient(pc IProxyContainer, client net.Conn, role ClientRole) error {
+	if role == ClientRoleReplace {
+		cc, ok := pc.(IClientChanger)
+		if !ok {
+			return fmt.Errorf("can't change client: %w", ErrUnsupported)
+		}
+		return cc.ChangeClient(client)
+	}
+	ca, ok := pc.(IClientAdder)
+	if !ok {
+		return fmt.Errorf("can't add client: %w", ErrUnsupported)
+	}
+	return ca.AddClient(client, role)
+}
+
+// Gives client to the proxy waiting for its host, or one waiting for any client
+func (p *ProxySpawner) AdoptExpectedClient(client net.Conn) (IProxyContainer, error) {
+	var ec expectedClient
+	found := false
+	p.expectedLock.Lock()
+	for _, host := range []string{clientHost(client.RemoteAddr()), ""} {
+		e, ok := p.expected[host]
+		if !ok {
+			continue
+		}
+		// Used or expired either way
+		delete(p.expected, host)
+		if time.Now().Before(e.until) {
+			ec, found = e, true
+			break
+		}
+	}
+	p.expectedLock.Unlock()
+	if !found {
+		return nil, nil
+	}
+	pc, err := p.GetProxy(ec.id)
+	if err != nil {
+		return nil, err
+	}
+	if err := giveClient(pc, client, ec.role); err != nil {
+		return nil, err
+	}
+	p.logger.Info("Gave expected client to proxy", "Id", ec.id, "ClientAddr", client.RemoteAddr().String(), "Role", ec.role.String())
+	return pc, nil
+}
+
+// Gives client to the proxy with the resume token
+func (p *ProxySpawner) ResumeClient(token string, role ClientRole, client net.Conn) (IProxyContainer, error) {
+	for _, pc := range p.GetAllProxies() {
+		rp, ok := pc.(IResumable)
+		if !ok || subtle.ConstantTimeCompare([]byte(rp.GetResumeToken()), []byte(token)) != 1 {
+			continue
+		}
+		if err := giveClient(pc, client, role); err != nil {
+			return nil, err
+		}
+		p.logger.Info("Resumed proxy", "Id", pc.GetId(), "ClientAddr", client.RemoteAddr().String(), "Role", role.String())
+		return pc, nil
+	}
+	return nil, errors.New("no proxy has that resume token")
 }
 
 // Gets a proxy by ID, returns a error if its not found
@@ -252,9 +857,9 @@ func (p *ProxySpawner) GetProxy(id int) (IProxyContainer, error) {
 
 // Get all proxies
 func (p *ProxySpawner) GetAllProxies() []IProxyContainer {
-	c := make([]IProxyContainer, 0, len(p.connections))
 	p.connectionLock.Lock()
 	defer p.connectionLock.Unlock()
+	c := make([]IProxyContainer, 0, len(p.connections))
 	for _, v := range p.connections {
 		c = append(c, v)
 	}
@@ -268,7 +873,10 @@ func (p *ProxySpawner) Close() error {
 		v.cancel()
 		close(v.Recv)
 	}
+	// Listeners are started under listenerLock once the context is checked, so none are added to wg after this
+	p.listenerLock.Lock()
 	p.contextCancel(ErrSpawnerClosedOk)
+	p.listenerLock.Unlock()
 	doneCh := make(chan bool)
 	// The pruner should be removing stuff
 	go func() {
@@ -297,19 +905,18 @@ func (p *ProxySpawner) CloseProxy(id int) error {
 }
 
 func (p *ProxySpawner) TrySetFilterCallback(cb PacketSendCallback, ctx context.Context) error {
+	p.callbackLock.Lock()
+	defer p.callbackLock.Unlock()
 	if p.callbackCtx != nil {
 		if p.callbackCtx.Err() == nil {
 			return errors.New("callback already exists")
 		}
-		p.logger.Debug("Callback context was closed, freeing filter callback", "Cause", context.Cause(p.callbackCtx), "Error", p.callbackCtx.Err().Error())
-		p.callbackCancel()
-		p.callbackCancel = nil
-		p.callbackCtx = nil
-		p.sendCallback = nil
+		p.freeCallback()
 	}
 	p.logger.Debug("Setting new filter callback")
 	p.callbackCtx, p.callbackCancel = context.WithCancel(ctx)
 	p.sendCallback = cb
+	p.notifyObserved(p.callbackCtx)
 	return nil
 }
 
@@ -373,6 +980,7 @@ func (p *ProxySpawner) GetRecvChan(ctx context.Context) (recv <-chan PacketChanD
 	p.rcChanLock.Lock()
 	p.rcChan = append(p.rcChan, &r)
 	p.rcChanLock.Unlock()
+	p.notifyObserved(r.ctx)
 	return r.Recv, r.ctx, r.cancel
 }
 
@@ -386,9 +994,31 @@ func NewProxySpawner(server net.Addr, proxy net.Addr, ctx context.Context, liste
 // Creates a new proxy spawner
 // logger may be nil, at least one listener must exist.
 func NewProxySpawnerWithContainer(server net.Addr, proxy net.Addr, containerMaker CreateIProxyContainer, ctx context.Context, listeners ...IProxyListener) (*ProxySpawner, error) {
+	named := make([]NamedListener, 0, len(listeners))
+	for k, h := range listeners {
+		named = append(named, NamedListener{Name: fmt.Sprintf("listener-%d", k), Listener: h})
+	}
+	return newProxySpawner(server, proxy, containerMaker, ctx, named)
+}
+
+// Creates a new proxy spawner with listeners that can be stopped & started by name
+// Uses default container (NewProxyContainer)
+// At least one listener must exist, it doesn't need to be started.
+func NewProxySpawnerWithListeners(server net.Addr, proxy net.Addr, ctx context.Context, listeners ...NamedListener) (*ProxySpawner, error) {
+	return newProxySpawner(server, proxy, NewProxyContainer, ctx, listeners)
+}
+
+func newProxySpawner(server net.Addr, proxy net.Addr, containerMaker CreateIProxyContainer, ctx context.Context, listeners []NamedListener) (*ProxySpawner, error) {
 	if len(listeners) == 0 {
 		return nil, errors.New("no listeners given")
 	}
+	spawnerListeners := make([]*spawnerListener, 0, len(listeners))
+	for _, l := range listeners {
+		if err := checkNamedListener(l, spawnerListeners); err != nil {
+			return nil, err
+		}
+		spawnerListeners = append(spawnerListeners, &spawnerListener{NamedListener: l})
+	}
 	if server.Network() == proxy.Network() && server.String() == proxy.String() {
 		return nil, errors.New("server address and proxy address must be different")
 	}
@@ -399,7 +1029,8 @@ func NewProxySpawnerWithContainer(server net.Addr, proxy net.Addr, containerMake
 		connections:        make(map[int]IProxyContainer),
 		connectionLock:     sync.Mutex{},
 		totalSent:          0,
-		serverAddr:         server,
+		upstreams:          []net.Addr{server},
+		strategy:           UpstreamRoundRobin,
 		proxyAddr:          proxy,
 		context:            psContext,
 		contextCancel:      cancel,
@@ -413,9 +1044,17 @@ func NewProxySpawnerWithContainer(server net.Addr, proxy net.Addr, containerMake
 		callbackCtx:        nil,
 		callbackCancel:     nil,
 		totalSentWriteLock: sync.Mutex{},
+		observedLock:       sync.Mutex{},
+		observedChanged:    make(chan struct{}),
+		expected:           make(map[string]expectedClient),
+		mirrored:           make(map[int]struct{}),
+		listeners:          spawnerListeners,
 	}
-	for _, h := range listeners {
-		go ps.addListener(h)
+	// Nothing else has the spawner yet so listenerLock isn't needed
+	for _, sl := range ps.listeners {
+		if !sl.Stopped {
+			ps.startListener(sl)
+		}
 	}
 	if ps.context.Err() != nil {
 		err := context.Cause(ps.context)
@@ -424,6 +1063,7 @@ func NewProxySpawnerWithContainer(server net.Addr, proxy net.Addr, containerMake
 		return nil, err
 	}
 	ps.logger.Debug("Starting pruner")
+	ps.wg.Add(1)
 	go ps.pruner()
 	return ps, nil
 }
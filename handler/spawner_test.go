@@ -8,6 +8,7 @@ import (
 	"ezproxy/mocks"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -62,6 +63,13 @@ func (m *MockSpawnerInfo) Close() {
 	m.StopListener <- ListenerCloseNormal
 }
 
+// Close, then waits for the listener & the spawners goroutines to return so they don't race the mocks
+func (m *MockSpawnerInfo) CloseWait() {
+	m.Close()
+	<-m.ListenerDone
+	m.Spawner.Close()
+}
+
 // Helper function to make a test spawner
 func createMockSpawner(t *testing.T) *MockSpawnerInfo {
 	ipl := mocks.NewIProxyListener(t)
@@ -725,7 +733,7 @@ func TestGetBytesSent(t *testing.T) {
 // Expect: Ensure channels are unique
 func TestGetRecvChanUnique(t *testing.T) {
 	si := createMockSpawner(t)
-	defer si.Close()
+	defer si.CloseWait()
 	c1, rCtx1, rCtxCan1 := si.Spawner.GetRecvChan(si.Context)
 	_ = rCtx1
 	defer rCtxCan1()
@@ -841,7 +849,7 @@ func TestGetRecvChanGetsData(t *testing.T) {
 // Expect: Channel context should not close
 func TestGetRecvChanDontCloseContext(t *testing.T) {
 	si := createMockSpawner(t)
-	defer si.Close()
+	defer si.CloseWait()
 	_, _, rCtxCan1 := si.Spawner.GetRecvChan(si.Context)
 	rCtxCan1()
 	if !si.Spawner.IsAlive() {
@@ -1047,9 +1055,13 @@ func TestListenerEarlyReturnNoContext(t *testing.T) {
 	ms.StopListener <- ListenerCloseLeaveContext
 	// We do need to wait for the listener to be closed though.
 	<-ms.ListenerDone
-	if ms.Spawner.IsAlive() {
+	select {
+	case <-ms.Spawner.GetContext().Done():
+	case <-time.After(time.Millisecond * 500):
 		t.Fatalf("Listener closing without return didn't fail")
 	}
+	// Waits for the listener to return so the mocks don't race it
+	ms.Spawner.Close()
 }
 
 // Misc, Ensure the spawner context isn't cancelled if a listener cancels with `ErrProxyClosedOk`
@@ -1068,6 +1080,7 @@ func TestCancelListenerOk(t *testing.T) {
 	case <-time.After(time.Millisecond * 500):
 		// Worst case level wait
 	}
+	ms.Spawner.Close()
 }
 
 // Misc, Ensure the spawner context is cancelled if a listener cancels with any other error
@@ -1084,6 +1097,7 @@ func TestCancelListenerError(t *testing.T) {
 		// Worst case level wait
 		t.Fatalf("Context wasn't closed after listener was closed with a error")
 	}
+	ms.Spawner.Close()
 }
 
 // Misc, Ensure listeners are retired with `ErrProxyRetry` & cancels after max retries (3)
@@ -1116,3 +1130,589 @@ func TestCancelListenerRetry(t *testing.T) {
 		t.Fatalf("Listener is retried")
 	}
 }
+
+// IsObserved, Ensure a filter callback or recv channel counts as observing only while its context is alive
+//
+// Expect: IsObserved follows the contexts, ObservedChanged is closed on each change
+func TestIsObserved(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.CloseWait()
+	if si.Spawner.IsObserved() {
+		t.Fatalf("Observed without an observer")
+	}
+	changed := si.Spawner.ObservedChanged()
+	rCtx, rCan := context.WithCancel(si.Context)
+	si.Spawner.GetRecvChan(rCtx)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("ObservedChanged wasn't closed when a recv channel was added")
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a recv channel")
+	}
+	changed = si.Spawner.ObservedChanged()
+	rCan()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("ObservedChanged wasn't closed when the recv channel closed")
+	}
+	if si.Spawner.IsObserved() {
+		t.Errorf("Observed after the recv channel closed")
+	}
+	changed = si.Spawner.ObservedChanged()
+	cbCtx, cbCan := context.WithCancel(si.Context)
+	err := si.Spawner.TrySetFilterCallback(func(data []byte, flags handler.CapFlags, pc handler.IProxyContainer) bool { return true }, cbCtx)
+	if err != nil {
+		t.Fatalf("Failed to set filter callback: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("ObservedChanged wasn't closed when a filter callback was set")
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a filter callback")
+	}
+	changed = si.Spawner.ObservedChanged()
+	cbCan()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("ObservedChanged wasn't closed when the filter callback closed")
+	}
+	if si.Spawner.IsObserved() {
+		t.Errorf("Observed after the filter callback closed")
+	}
+}
+
+// IsObserved, Ensure it can be called while filter callbacks are set & freed
+//
+// Expect: No data race under -race, the last callback is observed
+func TestIsObservedConcurrent(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.CloseWait()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 1000 {
+			si.Spawner.IsObserved()
+		}
+	}()
+	cb := func(data []byte, flags handler.CapFlags, pc handler.IProxyContainer) bool { return true }
+	for range 100 {
+		cbCtx, cbCan := context.WithCancel(si.Context)
+		if err := si.Spawner.TrySetFilterCallback(cb, cbCtx); err != nil {
+			t.Fatalf("Failed to set filter callback: %v", err)
+		}
+		cbCan()
+	}
+	<-done
+	if err := si.Spawner.TrySetFilterCallback(cb, si.Context); err != nil {
+		t.Fatalf("Failed to set filter callback: %v", err)
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a filter callback")
+	}
+}
+
+// AddBytesSent, Ensure bytes forwarded by proxies are counted
+//
+// Expect: GetBytesSent includes the added bytes
+func TestAddBytesSent(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	si.Spawner.AddBytesSent(100)
+	si.Spawner.AddBytesSent(23)
+	if bs := si.Spawner.GetBytesSent(); bs != 123 {
+		t.Fatalf("Got invalid number of bytes, expected 123 got %d", bs)
+	}
+}
+
+// GetRecvChan, Ensure recv channels get a copy of the data, so pooled buffers can be reused
+//
+// Expect: Data on the channel doesn't change when the original does
+func TestGetRecvChanCopiesData(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.CloseWait()
+	c1, _, rCtxCan1 := si.Spawner.GetRecvChan(si.Context)
+	defer rCtxCan1()
+	pc := mocks.NewIProxyContainer(t)
+	pc.On("GetId").Return(5).Maybe()
+	pc.On("GetClientAddr").Return(NewMockAddr("TestClient"))
+	pc.On("GetServerAddr").Return(NewMockAddr("TestServer"))
+	recv := make(chan handler.PacketChanData, 1)
+	go func() {
+		recv <- <-c1
+	}()
+	// Let the reader start waiting, sends on recv channels don't block
+	time.Sleep(time.Millisecond * 50)
+	test_data := []byte("HELLO WORLD")
+	si.Spawner.HandleSend(test_data, handler.CapFlag_ToServer, pc)
+	copy(test_data, "GOODBYE")
+	select {
+	case v := <-recv:
+		if !bytes.Equal(v.Data, []byte("HELLO WORLD")) {
+			t.Fatalf("Data on channel changed with the original, got %q", v.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Got no data on channel")
+	}
+}
+
+// SetServerAddr, Ensure the server address changes & can't be set to the proxy address
+//
+// Expect: GetServerAddr returns the new address, a error for the proxy address
+func TestSetServerAddr(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	addr := NewMockAddr("New Server Net")
+	if err := si.Spawner.SetServerAddr(addr); err != nil {
+		t.Fatalf("Failed to set server address: %v", err)
+	}
+	if sAddr := si.Spawner.GetServerAddr(); sAddr != addr {
+		t.Errorf("Incorrect server address, got %+v expected %+v", sAddr, addr)
+	}
+	if err := si.Spawner.SetServerAddr(NewMockAddr(si.ProxyAddr.String())); err == nil {
+		t.Errorf("Set the server address to the proxy address")
+	}
+	if sAddr := si.Spawner.GetServerAddr(); sAddr != addr {
+		t.Errorf("Server address changed after a error, got %+v expected %+v", sAddr, addr)
+	}
+}
+
+// SetServerAddr with upstreams, Ensure only the first upstream is changed
+//
+// Expect: The other upstreams & the strategy are kept
+func TestSetServerAddrUpstreams(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	addrs := []net.Addr{NewMockAddr("Upstream 1"), NewMockAddr("Upstream 2"), NewMockAddr("Upstream 3")}
+	if err := si.Spawner.SetUpstreams(addrs, handler.UpstreamLeastConnections); err != nil {
+		t.Fatalf("Failed to set upstreams: %v", err)
+	}
+	addr := NewMockAddr("New Server")
+	if err := si.Spawner.SetServerAddr(addr); err != nil {
+		t.Fatalf("Failed to set server address: %v", err)
+	}
+	status := si.Spawner.GetUpstreams()
+	if len(status) != 3 || status[0].Address != addr || status[1].Address != addrs[1] || status[2].Address != addrs[2] {
+		t.Errorf("Expected only the first upstream to change, got %+v", status)
+	}
+	if s := si.Spawner.GetUpstreamStrategy(); s != handler.UpstreamLeastConnections {
+		t.Errorf("Strategy changed, got %s", s)
+	}
+}
+
+// SetUpstreams, Ensure the upstreams & strategy change, the first upstream is the server address
+//
+// Expect: Empty lists, invalid strategies & the proxy address are rejected without changing anything
+func TestSetUpstreams(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	addrs := []net.Addr{NewMockAddr("Upstream 1"), NewMockAddr("Upstream 2")}
+	if err := si.Spawner.SetUpstreams(addrs, handler.UpstreamLeastConnections); err != nil {
+		t.Fatalf("Failed to set upstreams: %v", err)
+	}
+	if sAddr := si.Spawner.GetServerAddr(); sAddr != addrs[0] {
+		t.Errorf("Server address isn't the first upstream, got %+v expected %+v", sAddr, addrs[0])
+	}
+	if s := si.Spawner.GetUpstreamStrategy(); s != handler.UpstreamLeastConnections {
+		t.Errorf("Incorrect strategy, got %s", s)
+	}
+	if err := si.Spawner.SetUpstreams(nil, handler.UpstreamRandom); err == nil {
+		t.Errorf("Set no upstreams")
+	}
+	if err := si.Spawner.SetUpstreams(addrs, handler.UpstreamStrategy(10)); err == nil {
+		t.Errorf("Set a invalid strategy")
+	}
+	if err := si.Spawner.SetUpstreams([]net.Addr{addrs[0], NewMockAddr(si.ProxyAddr.String())}, handler.UpstreamRandom); err == nil {
+		t.Errorf("Set the proxy address as a upstream")
+	}
+	status := si.Spawner.GetUpstreams()
+	if len(status) != 2 || status[0].Address != addrs[0] || status[1].Address != addrs[1] {
+		t.Errorf("Upstreams changed after a error, got %+v", status)
+	}
+	if s := si.Spawner.GetUpstreamStrategy(); s != handler.UpstreamLeastConnections {
+		t.Errorf("Strategy changed after a error, got %s", s)
+	}
+}
+
+// IProxyContainer that knows its upstream
+type upstreamContainer struct {
+	*mocks.IProxyContainer
+	*mocks.IUpstreamTracker
+}
+
+// PickServerAddr, Ensure each strategy picks the upstream it should
+//
+// Expect: Round-robin takes turns, client-hash is the same for one IP, least-connections picks the emptiest upstream
+func TestPickServerAddr(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	addrs := []net.Addr{NewMockAddr("Upstream 1"), NewMockAddr("Upstream 2"), NewMockAddr("Upstream 3")}
+	client := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 1234}
+	if err := si.Spawner.SetUpstreams(addrs, handler.UpstreamRoundRobin); err != nil {
+		t.Fatalf("Failed to set upstreams: %v", err)
+	}
+	first := si.Spawner.PickServerAddr(client)
+	for k := range 6 {
+		expected := addrs[(slices.Index(addrs, first)+k+1)%len(addrs)]
+		if got := si.Spawner.PickServerAddr(client); got != expected {
+			t.Errorf("Round-robin picked %+v expected %+v", got, expected)
+		}
+	}
+	si.Spawner.SetUpstreams(addrs, handler.UpstreamClientHash)
+	hashed := si.Spawner.PickServerAddr(client)
+	for range 5 {
+		other := &net.TCPAddr{IP: client.IP, Port: 4321}
+		if got := si.Spawner.PickServerAddr(other); got != hashed {
+			t.Errorf("Client-hash picked %+v for the same IP, expected %+v", got, hashed)
+		}
+	}
+	si.Spawner.SetUpstreams(addrs, handler.UpstreamRandom)
+	for range 10 {
+		if got := si.Spawner.PickServerAddr(client); !slices.Contains(addrs, got) {
+			t.Errorf("Random picked %+v which isn't a upstream", got)
+		}
+	}
+	// 2 proxies on the first upstream, 1 on the last
+	for k, upstream := range []net.Addr{addrs[0], addrs[0], addrs[2]} {
+		pc := upstreamContainer{mocks.NewIProxyContainer(t), mocks.NewIUpstreamTracker(t)}
+		pc.IProxyContainer.On("IsAlive").Return(true).Maybe()
+		pc.IUpstreamTracker.On("GetUpstream").Return(upstream).Maybe()
+		si.CreateContainer.On("Execute", mock.Anything, mock.Anything, k).Return(pc, nil)
+		if _, err := si.Spawner.AddConnection(mocks.NewIProxy(t)); err != nil {
+			t.Fatalf("Failed to add connection: %v", err)
+		}
+	}
+	si.Spawner.SetUpstreams(addrs, handler.UpstreamLeastConnections)
+	for range 3 {
+		if got := si.Spawner.PickServerAddr(client); got != addrs[1] {
+			t.Errorf("Least-connections picked %+v expected %+v", got, addrs[1])
+		}
+	}
+	for k, v := range si.Spawner.GetUpstreams() {
+		if expected := []int{2, 0, 1}[k]; v.Connections != expected {
+			t.Errorf("Upstream %d has %d connections expected %d", k, v.Connections, expected)
+		}
+	}
+}
+
+// SetHealthCheck, Ensure unhealthy upstreams leave rotation & come back after enough successes
+//
+// Expect: Events on the health channel, unhealthy upstreams aren't picked unless they all are
+func TestSetHealthCheck(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	addrs := []net.Addr{NewMockAddr("Upstream 1"), NewMockAddr("Upstream 2")}
+	if err := si.Spawner.SetUpstreams(addrs, handler.UpstreamRoundRobin); err != nil {
+		t.Fatalf("Failed to set upstreams: %v", err)
+	}
+	if err := si.Spawner.SetHealthCheck(&handler.HealthCheck{}); err == nil {
+		t.Errorf("Set a health check without a probe")
+	}
+	var downLock sync.Mutex
+	down := map[string]bool{"Upstream 2": true}
+	setDown := func(name string, isDown bool) {
+		downLock.Lock()
+		down[name] = isDown
+		downLock.Unlock()
+	}
+	probe := func(ctx context.Context, addr net.Addr) error {
+		downLock.Lock()
+		defer downLock.Unlock()
+		if down[addr.String()] {
+			return errors.New("test down")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := si.Spawner.GetHealthChan(ctx)
+	err := si.Spawner.SetHealthCheck(&handler.HealthCheck{Probe: probe, Interval: time.Millisecond * 10, Rise: 2, Fall: 2})
+	if err != nil {
+		t.Fatalf("Failed to set health check: %v", err)
+	}
+	expectEvent := func(addr net.Addr, healthy bool) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Address != addr || e.Healthy != healthy {
+				t.Errorf("Incorrect health event, got %+v expected %s healthy %v", e, addr, healthy)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No health event for %s", addr)
+		}
+	}
+	expectEvent(addrs[1], false)
+	for range 4 {
+		if got := si.Spawner.PickServerAddr(nil); got != addrs[0] {
+			t.Errorf("Picked unhealthy upstream %+v", got)
+		}
+	}
+	if status := si.Spawner.GetUpstreams(); !status[0].Healthy || status[1].Healthy || status[1].Error != "test down" {
+		t.Errorf("Incorrect upstream health, got %+v", status)
+	}
+	// Every upstream is down, any can be picked
+	setDown("Upstream 1", true)
+	expectEvent(addrs[0], false)
+	seen := make(map[net.Addr]bool)
+	for range 4 {
+		seen[si.Spawner.PickServerAddr(nil)] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Didn't pick every upstream when they were all unhealthy, picked %+v", seen)
+	}
+	setDown("Upstream 1", false)
+	setDown("Upstream 2", false)
+	expectEvent(addrs[0], true)
+	expectEvent(addrs[1], true)
+	// Stopping checks makes every upstream healthy
+	setDown("Upstream 2", true)
+	expectEvent(addrs[1], false)
+	if err := si.Spawner.SetHealthCheck(nil); err != nil {
+		t.Fatalf("Failed to stop health checks: %v", err)
+	}
+	expectEvent(addrs[1], true)
+	if status := si.Spawner.GetUpstreams(); !status[0].Healthy || !status[1].Healthy {
+		t.Errorf("Upstreams are unhealthy without health checks, got %+v", status)
+	}
+	cancel()
+	time.Sleep(time.Millisecond * 10)
+	if _, ok := <-events; ok {
+		t.Errorf("Health channel wasn't closed with its context")
+	}
+}
+
+// ResolveAddr, Ensure unix prefixes are resolved as unix sockets & anything else as TCP
+//
+// Expect: Correct networks & addresses, a error for invalid addresses
+func TestResolveAddr(t *testing.T) {
+	for _, v := range []struct{ address, network, str string }{
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"[::1]:8080", "tcp", "[::1]:8080"},
+		{"unix:/tmp/ezp.sock", "unix", "/tmp/ezp.sock"},
+		{"unixgram:/tmp/ezp.sock", "unixgram", "/tmp/ezp.sock"},
+	} {
+		addr, err := handler.ResolveAddr(v.address)
+		if err != nil {
+			t.Errorf("Failed to resolve %q: %v", v.address, err)
+			continue
+		}
+		if addr.Network() != v.network || addr.String() != v.str {
+			t.Errorf("Resolved %q to %s %s, expected %s %s", v.address, addr.Network(), addr, v.network, v.str)
+		}
+	}
+	if _, err := handler.ResolveAddr("not an address"); err == nil {
+		t.Errorf("Resolved a invalid address")
+	}
+}
+
+// Connection with a set remote address
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (r *remoteAddrConn) RemoteAddr() net.Addr { return r.remote }
+
+// Helper to make a client connection from ip
+func newClientConn(t *testing.T, ip string) net.Conn {
+	c, other := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		other.Close()
+	})
+	return &remoteAddrConn{Conn: c, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+// IProxyContainer that clients can be given to
+type clientContainer struct {
+	*mocks.IProxyContainer
+	*mocks.IClientChanger
+	*mocks.IClientAdder
+	*mocks.IResumable
+}
+
+func newClientContainer(t *testing.T) *clientContainer {
+	return &clientContainer{mocks.NewIProxyContainer(t), mocks.NewIClientChanger(t), mocks.NewIClientAdder(t), mocks.NewIResumable(t)}
+}
+
+// ExpectClient, Ensure the next client from the host is given to the proxy once & only before the timeout
+//
+// Expect: AdoptExpectedClient calls ChangeClient for the matching host, nil for others
+func TestExpectClient(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	pc := newClientContainer(t)
+	pc.IProxyContainer.On("IsAlive").Return(true).Maybe()
+	si.CreateContainer.On("Execute", mock.Anything, mock.Anything, 0).Return(pc, nil)
+	if _, err := si.Spawner.AddConnection(mocks.NewIProxy(t)); err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+	if err := si.Spawner.ExpectClient(1, "", handler.ClientRoleReplace, time.Second); err == nil {
+		t.Errorf("Expected a client for a proxy that doesn't exist")
+	}
+	if err := si.Spawner.ExpectClient(0, "not an ip", handler.ClientRoleReplace, time.Second); err == nil {
+		t.Errorf("Expected a client from a invalid host")
+	}
+	if err := si.Spawner.ExpectClient(0, "", handler.ClientRole(5), time.Second); err == nil {
+		t.Errorf("Expected a client with a invalid role")
+	}
+	if err := si.Spawner.ExpectClient(0, "127.0.0.2", handler.ClientRoleReplace, time.Second); err != nil {
+		t.Fatalf("Failed to expect client: %v", err)
+	}
+	other := newClientConn(t, "127.0.0.3")
+	if got, err := si.Spawner.AdoptExpectedClient(other); got != nil || err != nil {
+		t.Errorf("Gave a client from another host to the proxy, %v %v", got, err)
+	}
+	client := newClientConn(t, "127.0.0.2")
+	pc.IClientChanger.On("ChangeClient", client).Return(nil).Once()
+	got, err := si.Spawner.AdoptExpectedClient(client)
+	if err != nil || got != pc {
+		t.Fatalf("Client wasn't given to the proxy, %v %v", got, err)
+	}
+	// Only the next client
+	if got, err := si.Spawner.AdoptExpectedClient(newClientConn(t, "127.0.0.2")); got != nil || err != nil {
+		t.Errorf("Gave a second client to the proxy, %v %v", got, err)
+	}
+	if err := si.Spawner.ExpectClient(0, "", handler.ClientRoleReplace, time.Millisecond); err != nil {
+		t.Fatalf("Failed to expect client: %v", err)
+	}
+	time.Sleep(time.Millisecond * 10)
+	if got, err := si.Spawner.AdoptExpectedClient(newClientConn(t, "127.0.0.2")); got != nil || err != nil {
+		t.Errorf("Gave a client to the proxy after the timeout, %v %v", got, err)
+	}
+	if err := si.Spawner.ExpectClient(0, "", handler.ClientRoleObserver, time.Second); err != nil {
+		t.Fatalf("Failed to expect client: %v", err)
+	}
+	observer := newClientConn(t, "127.0.0.4")
+	pc.IClientAdder.On("AddClient", observer, handler.ClientRoleObserver).Return(nil).Once()
+	if got, err := si.Spawner.AdoptExpectedClient(observer); err != nil || got != pc {
+		t.Errorf("Observer wasn't added to the proxy, %v %v", got, err)
+	}
+}
+
+// ResumeClient, Ensure the client is given to the proxy with the token
+//
+// Expect: ChangeClient called on the matching proxy, a error for unknown tokens
+func TestResumeClient(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	pcs := make([]*clientContainer, 0)
+	for k := range 3 {
+		pc := newClientContainer(t)
+		pc.IProxyContainer.On("IsAlive").Return(true).Maybe()
+		pc.IProxyContainer.On("GetId").Return(k).Maybe()
+		pc.IResumable.On("GetResumeToken").Return(fmt.Sprintf("token%d", k)).Maybe()
+		si.CreateContainer.On("Execute", mock.Anything, mock.Anything, k).Return(pc, nil)
+		if _, err := si.Spawner.AddConnection(mocks.NewIProxy(t)); err != nil {
+			t.Fatalf("Failed to add connection: %v", err)
+		}
+		pcs = append(pcs, pc)
+	}
+	client := newClientConn(t, "127.0.0.2")
+	pcs[1].IClientChanger.On("ChangeClient", client).Return(nil).Once()
+	got, err := si.Spawner.ResumeClient("token1", handler.ClientRoleReplace, client)
+	if err != nil || got != pcs[1] {
+		t.Fatalf("Client wasn't given to proxy 1, %v %v", got, err)
+	}
+	if _, err := si.Spawner.ResumeClient("token5", handler.ClientRoleReplace, client); err == nil {
+		t.Errorf("Resumed a proxy with a unknown token")
+	}
+	pcs[2].IClientChanger.On("ChangeClient", client).Return(errors.New("test error")).Once()
+	if _, err := si.Spawner.ResumeClient("token2", handler.ClientRoleReplace, client); err == nil {
+		t.Errorf("ChangeClient error wasn't returned")
+	}
+	pcs[0].IClientAdder.On("AddClient", client, handler.ClientRoleWriter).Return(nil).Once()
+	if got, err := si.Spawner.ResumeClient("token0", handler.ClientRoleWriter, client); err != nil || got != pcs[0] {
+		t.Errorf("Writer wasn't added to proxy 0, %v %v", got, err)
+	}
+}
+
+// IProxyContainer that can mirror
+type mirrorContainer struct {
+	*mocks.IProxyContainer
+	*mocks.IMirrorable
+}
+
+// SetMirror & MirrorProxy, Ensure the default mirror & mirrored proxies keep the spawner observed
+//
+// Expect: Invalid mirrors fail, observed while a default mirror or a mirrored proxy exists, ErrUnsupported for containers that can't mirror
+func TestSetMirror(t *testing.T) {
+	si := createMockSpawner(t)
+	defer si.Close()
+	if err := si.Spawner.SetMirror(&handler.Mirror{}); err == nil {
+		t.Errorf("Set a mirror without an address")
+	}
+	if err := si.Spawner.SetMirror(&handler.Mirror{Addr: NewMockAddr("Shadow"), Sample: 2}); err == nil {
+		t.Errorf("Set a mirror with a sample over 1")
+	}
+	pcs := make([]*mirrorContainer, 0)
+	for k := range 2 {
+		pc := &mirrorContainer{mocks.NewIProxyContainer(t), mocks.NewIMirrorable(t)}
+		pc.IProxyContainer.On("IsAlive").Return(true).Maybe()
+		pc.IProxyContainer.On("GetId").Return(k).Maybe()
+		si.CreateContainer.On("Execute", mock.Anything, mock.Anything, k).Return(pc, nil)
+		if _, err := si.Spawner.AddConnection(mocks.NewIProxy(t)); err != nil {
+			t.Fatalf("Failed to add connection: %v", err)
+		}
+		pcs = append(pcs, pc)
+	}
+	changed := si.Spawner.ObservedChanged()
+	if err := si.Spawner.SetMirror(&handler.Mirror{Addr: NewMockAddr("Shadow"), Sample: 0.5}); err != nil {
+		t.Fatalf("Failed to set mirror: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("ObservedChanged wasn't closed when a mirror was set")
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a mirror")
+	}
+	if m := si.Spawner.GetMirror(); m == nil || m.Sample != 0.5 || m.Policy != handler.MirrorDefault {
+		t.Errorf("Incorrect default mirror, got %+v", m)
+	}
+	// Proxy 0 was picked by the sample, it keeps the spawner observed
+	pcs[0].IMirrorable.On("GetMirror").Return(&handler.MirrorStatus{Active: true})
+	pcs[1].IMirrorable.On("GetMirror").Return(nil)
+	if err := si.Spawner.SetMirror(nil); err != nil {
+		t.Fatalf("Failed to remove mirror: %v", err)
+	}
+	if si.Spawner.GetMirror() != nil {
+		t.Errorf("Default mirror wasn't removed")
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a mirrored proxy")
+	}
+	pcs[0].IMirrorable.On("SetMirror", (*handler.Mirror)(nil)).Return(nil).Once()
+	if err := si.Spawner.MirrorProxy(0, nil); err != nil {
+		t.Fatalf("Failed to stop mirroring proxy 0: %v", err)
+	}
+	if si.Spawner.IsObserved() {
+		t.Errorf("Observed without a mirror")
+	}
+	m := &handler.Mirror{Addr: NewMockAddr("Shadow")}
+	pcs[1].IMirrorable.On("SetMirror", m).Return(nil).Once()
+	if err := si.Spawner.MirrorProxy(1, m); err != nil {
+		t.Fatalf("Failed to mirror proxy 1: %v", err)
+	}
+	if !si.Spawner.IsObserved() {
+		t.Errorf("Not observed with a mirrored proxy")
+	}
+	if err := si.Spawner.MirrorProxy(5, m); err == nil {
+		t.Errorf("Mirrored a proxy that doesn't exist")
+	}
+	plain := mocks.NewIProxyContainer(t)
+	plain.On("IsAlive").Return(true).Maybe()
+	si.CreateContainer.On("Execute", mock.Anything, mock.Anything, 2).Return(plain, nil)
+	if _, err := si.Spawner.AddConnection(mocks.NewIProxy(t)); err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+	if err := si.Spawner.MirrorProxy(2, m); !errors.Is(err, handler.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a proxy that can't mirror, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+)
+
+// How a spawner picks the server for a new proxy from its upstreams, see ProxySpawner.SetUpstreams
+type UpstreamStrategy int
+
+const (
+	UpstreamRoundRobin       UpstreamStrategy = 0 // Each upstream in turn
+	UpstreamLeastConnections UpstreamStrategy = 1 // Upstream with the fewest running proxies, ties are taken in turn
+	UpstreamRandom           UpstreamStrategy = 2 // Any upstream
+	UpstreamClientHash       UpstreamStrategy = 3 // Hash of the client IP, a client keeps its upstream while the list doesn't change
+)
+
+func (s UpstreamStrategy) String() string {
+	switch s {
+	case UpstreamRoundRobin:
+		return "round-robin"
+	case UpstreamLeastConnections:
+		return "least-connections"
+	case UpstreamRandom:
+		return "random"
+	case UpstreamClientHash:
+		return "client-hash"
+	default:
+		return fmt.Sprintf("UpstreamStrategy(%d)", int(s))
+	}
+}
+
+// Checks if s is a known strategy
+func (s UpstreamStrategy) IsValid() bool {
+	return s >= UpstreamRoundRobin && s <= UpstreamClientHash
+}
+
+// Parses a strategy from its String form, "" is round-robin
+func ParseUpstreamStrategy(s string) (UpstreamStrategy, error) {
+	if s == "" {
+		return UpstreamRoundRobin, nil
+	}
+	for _, v := range []UpstreamStrategy{UpstreamRoundRobin, UpstreamLeastConnections, UpstreamRandom, UpstreamClientHash} {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown upstream strategy %q", s)
+}
+
+// Upstream, its health & the number of running proxies it was picked for
+type UpstreamStatus struct {
+	Address     net.Addr
+	Connections int
+	Healthy     bool   // Always true without health checks
+	Error       string // Why the last health check failed, empty if it didn't
+}
@@ -0,0 +1,21 @@
+package handler_test
+
+import (
+	"ezproxy/handler"
+	"testing"
+)
+
+func TestParseUpstreamStrategy(t *testing.T) {
+	for _, s := range []handler.UpstreamStrategy{handler.UpstreamRoundRobin, handler.UpstreamLeastConnections, handler.UpstreamRandom, handler.UpstreamClientHash} {
+		got, err := handler.ParseUpstreamStrategy(s.String())
+		if err != nil || got != s {
+			t.Errorf("expected '%s' to parse to %d got %d %v", s, s, got, err)
+		}
+	}
+	if got, err := handler.ParseUpstreamStrategy(""); err != nil || got != handler.UpstreamRoundRobin {
+		t.Errorf("expected '' to parse to round-robin got %d %v", got, err)
+	}
+	if _, err := handler.ParseUpstreamStrategy("fastest"); err == nil {
+		t.Errorf("expected a error for a unknown strategy")
+	}
+}
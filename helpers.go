@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"ezproxy/api"
 	"ezproxy/handler"
 	"ezproxy/proxy"
@@ -10,9 +11,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+	"nhooyr.io/websocket"
 )
 
 const (
@@ -40,34 +45,207 @@ func versionToString(v uint32) string {
 	return fmt.Sprintf("%d.%dr%d%s", major, minor, rev, suffix)
 }
 
-func getLocalIp() (net.Addr, error) {
-	c, err := net.Dial("udp", "192.168.0.254:1234")
-	if err != nil {
-		return nil, err
+// Gets the local IP used to reach other hosts, IPv4 is preferred and IPv6 is used on IPv6 only hosts.
+// Nothing is sent, dialing UDP only picks a route.
+func getLocalIp() (net.IP, error) {
+	var err error
+	for _, target := range []string{"192.168.0.254:1234", "[2001:db8::1]:1234"} {
+		var c net.Conn
+		c, err = net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		ip := c.LocalAddr().(*net.UDPAddr).IP
+		c.Close()
+		return ip, nil
 	}
-	return c.LocalAddr(), nil
+	return nil, err
 }
 
 type ConfigAddress struct {
-	Address string `yaml:"Address"` // Leave empty to use local address
+	Address string `yaml:"Address"` // Leave empty to use local address, "::" listens on every IPv4 & IPv6 address
 	Port    uint16 `yaml:"Port"`
+	Path    string `yaml:"Path"`    // Unix socket path, used instead of Address & Port if set
+	Network string `yaml:"Network"` // Unix socket type, "unix" or "unixgram". Defaults to "unix"
 }
 
 func (c *ConfigAddress) IsEmpty() bool {
-	return c.Address != "" || c.Port != 0
+	return c.Address == "" && c.Port == 0 && c.Path == ""
 }
 
 func (c *ConfigAddress) ToString() string {
+	if c.Path != "" {
+		return c.Path
+	}
 	if c.Address == "" {
-		addr, err := getLocalIp()
+		ip, err := getLocalIp()
 		if err != nil {
 			slog.Default().Error("Failed to get local IP address", "Error", err.Error())
 			panic("Failed to get local IP address")
 		}
-		ip := strings.Split(addr.String(), ":")[0]
-		return fmt.Sprintf("%s:%d", ip, c.Port)
+		return net.JoinHostPort(ip.String(), strconv.Itoa(int(c.Port)))
+	}
+	// IPv6 addresses may already be bracketed
+	host := strings.TrimSuffix(strings.TrimPrefix(c.Address, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(int(c.Port)))
+}
+
+// Gets the unix socket type, "" if this is an IP address
+func (c *ConfigAddress) UnixNetwork() string {
+	if c.Path == "" {
+		return ""
+	}
+	if c.Network == "" {
+		return "unix"
+	}
+	return c.Network
+}
+
+// Resolves the address, unix sockets resolve to a *net.UnixAddr and anything else to a *net.TCPAddr
+func (c *ConfigAddress) Resolve() (net.Addr, error) {
+	switch c.UnixNetwork() {
+	case "":
+		return net.ResolveTCPAddr("tcp", c.ToString())
+	case "unix", "unixgram":
+		return net.ResolveUnixAddr(c.UnixNetwork(), c.Path)
+	default:
+		return nil, fmt.Errorf("invalid Network '%s', must be 'unix' or 'unixgram'", c.Network)
+	}
+}
+
+type ConfigPortRange struct {
+	Enable   bool   `yaml:"Enable"`
+	LastPort uint16 `yaml:"LastPort"`
+}
+
+type ConfigNetworks struct {
+	Start   []string `yaml:"Start"`   // Listeners started with the proxy, "tcp" and "udp". Empty starts every listener
+	TcpPort uint16   `yaml:"TcpPort"` // Port the tcp listener uses instead of ProxyAddress.Port, 0 to use it
+	UdpPort uint16   `yaml:"UdpPort"` // Port the udp listener uses instead of ProxyAddress.Port, 0 to use it
+}
+
+// Names of the listeners a route can have, the stream listener is "tcp" & the datagram listener is "udp", even on unix sockets
+const (
+	listenerTcp = "tcp"
+	listenerUdp = "udp"
+)
+
+// Checks the listener names in Start
+func (c *ConfigNetworks) validate() error {
+	for _, name := range c.Start {
+		if name != listenerTcp && name != listenerUdp {
+			return fmt.Errorf("invalid Networks.Start '%s', must be 'tcp' or 'udp'", name)
+		}
 	}
-	return fmt.Sprintf("%s:%d", c.Address, c.Port)
+	return nil
+}
+
+// Should the listener start with the proxy
+func (c *ConfigNetworks) starts(name string) bool {
+	return len(c.Start) == 0 || slices.Contains(c.Start, name)
+}
+
+type ConfigUpstreams struct {
+	Strategy string          `yaml:"Strategy"`
+	Servers  []ConfigAddress `yaml:"Servers"`
+}
+
+// Parses Strategy, "" is round-robin
+func (c *ConfigUpstreams) ToStrategy() (handler.UpstreamStrategy, error) {
+	s, err := handler.ParseUpstreamStrategy(c.Strategy)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Upstreams.Strategy '%s', must be 'round-robin', 'least-connections', 'random' or 'client-hash'", c.Strategy)
+	}
+	return s, nil
+}
+
+type ConfigHealthCheck struct {
+	Enable   bool   `yaml:"Enable"`
+	Network  string `yaml:"Network"`
+	Send     string `yaml:"Send"`
+	Expect   string `yaml:"Expect"`
+	Interval int    `yaml:"Interval"`
+	Timeout  int    `yaml:"Timeout"`
+	Rise     int    `yaml:"Rise"`
+	Fall     int    `yaml:"Fall"`
+}
+
+// Creates the health check, svNet is the unix network of the server address. nil if it isn't enabled
+func (c *ConfigHealthCheck) ToHealthCheck(svNet string) (*handler.HealthCheck, error) {
+	if !c.Enable {
+		return nil, nil
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{{"Interval", c.Interval}, {"Timeout", c.Timeout}, {"Rise", c.Rise}, {"Fall", c.Fall}} {
+		if v.value < 0 {
+			return nil, fmt.Errorf("invalid HealthCheck.%s %d, must be 0 for the default or more", v.name, v.value)
+		}
+	}
+	datagram := svNet == "unixgram"
+	switch c.Network {
+	case "":
+	case "tcp":
+		datagram = false
+	case "udp":
+		datagram = true
+	default:
+		return nil, fmt.Errorf("invalid HealthCheck.Network '%s', must be 'tcp' or 'udp'", c.Network)
+	}
+	if (datagram && svNet == "unix") || (!datagram && svNet == "unixgram") {
+		return nil, fmt.Errorf("HealthCheck.Network '%s' can't be used with a %s ServerAddress", c.Network, svNet)
+	}
+	cfg := proxy.HealthProbeConfig{Datagram: datagram}
+	if c.Send != "" {
+		cfg.Send = []byte(c.Send)
+	}
+	if c.Expect != "" {
+		cfg.Expect = []byte(c.Expect)
+	}
+	probe, err := proxy.NewHealthProbe(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HealthCheck: %v", err)
+	}
+	return &handler.HealthCheck{
+		Probe:    probe,
+		Interval: time.Duration(c.Interval) * time.Millisecond,
+		Timeout:  time.Duration(c.Timeout) * time.Millisecond,
+		Rise:     c.Rise,
+		Fall:     c.Fall,
+	}, nil
+}
+
+type ConfigMirror struct {
+	Enable  bool          `yaml:"Enable"`
+	Address ConfigAddress `yaml:"Address"`
+	Sample  float64       `yaml:"Sample"`
+	Policy  []string      `yaml:"Policy"`
+	Record  bool          `yaml:"Record"`
+}
+
+// Creates the mirror for new proxies, nil if it isn't enabled
+func (c *ConfigMirror) ToMirror() (*handler.Mirror, error) {
+	if !c.Enable {
+		return nil, nil
+	}
+	if c.Sample < 0 || c.Sample > 1 {
+		return nil, fmt.Errorf("invalid Mirror.Sample %v, must be between 0 and 1", c.Sample)
+	}
+	policy, err := handler.ParseMirrorPolicy(c.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mirror.Policy: %v", err)
+	}
+	addr, err := c.Address.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mirror.Address: %v", err)
+	}
+	return &handler.Mirror{
+		Addr:   addr,
+		Policy: policy,
+		Record: c.Record,
+		Sample: c.Sample,
+	}, nil
 }
 
 type ConfigLogging struct {
@@ -104,19 +282,101 @@ type ConfigLua struct {
 	Enable bool   `yaml:"Enable"`
 	Path   string `yaml:"Path"`
 	Mode   string `yaml:"Mode"`
+	Route  string `yaml:"Route"`
+}
+
+type ConfigTls struct {
+	Enable             bool   `yaml:"Enable"`
+	Mode               string `yaml:"Mode"`
+	CertFile           string `yaml:"CertFile"`
+	KeyFile            string `yaml:"KeyFile"`
+	CaCertFile         string `yaml:"CaCertFile"`
+	CaKeyFile          string `yaml:"CaKeyFile"`
+	ServerTls          bool   `yaml:"ServerTls"`
+	ServerName         string `yaml:"ServerName"`
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify"`
+}
+
+type ConfigSni struct {
+	Enable bool                     `yaml:"Enable"`
+	Routes map[string]ConfigAddress `yaml:"Routes"`
+}
+
+type ConfigSocks struct {
+	Enable   bool   `yaml:"Enable"`
+	Username string `yaml:"Username"`
+	Password string `yaml:"Password"`
+}
+
+type ConfigHttpConnect struct {
+	Enable   bool   `yaml:"Enable"`
+	Username string `yaml:"Username"`
+	Password string `yaml:"Password"`
+}
+
+type ConfigWebSocket struct {
+	Enable         bool     `yaml:"Enable"`
+	OriginPatterns []string `yaml:"OriginPatterns"`
+	MessageType    string   `yaml:"MessageType"`
+}
+
+type ConfigProxyProtocol struct {
+	Send   int  `yaml:"Send"`
+	Accept bool `yaml:"Accept"`
+}
+
+type ConfigPerformance struct {
+	Splice          bool `yaml:"Splice"`
+	ReadSize        int  `yaml:"ReadSize"`
+	MaxDatagramSize int  `yaml:"MaxDatagramSize"`
+}
+
+// Timeout is how long clients get to send a resume line, clients waiting for the server first are delayed by it
+type ConfigResume struct {
+	Enable  bool `yaml:"Enable"`
+	Timeout int  `yaml:"Timeout"`
+}
+
+type ConfigReconnect struct {
+	Enable     bool `yaml:"Enable"`
+	Buffer     int  `yaml:"Buffer"`
+	Deadline   int  `yaml:"Deadline"`
+	MinBackoff int  `yaml:"MinBackoff"`
+	MaxBackoff int  `yaml:"MaxBackoff"`
+}
+
+// A proxy with its own listeners, server & policies
+type ConfigRoute struct {
+	Name          string              `yaml:"Name"`
+	ProxyAddress  ConfigAddress       `yaml:"ProxyAddress"`
+	ServerAddress ConfigAddress       `yaml:"ServerAddress"`
+	PortRange     ConfigPortRange     `yaml:"PortRange"`
+	Networks      ConfigNetworks      `yaml:"Networks"`
+	Upstreams     ConfigUpstreams     `yaml:"Upstreams"`
+	HealthCheck   ConfigHealthCheck   `yaml:"HealthCheck"`
+	Mirror        ConfigMirror        `yaml:"Mirror"`
+	Tls           ConfigTls           `yaml:"Tls"`
+	Sni           ConfigSni           `yaml:"Sni"`
+	Socks         ConfigSocks         `yaml:"Socks"`
+	HttpConnect   ConfigHttpConnect   `yaml:"HttpConnect"`
+	WebSocket     ConfigWebSocket     `yaml:"WebSocket"`
+	ProxyProtocol ConfigProxyProtocol `yaml:"ProxyProtocol"`
+	Performance   ConfigPerformance   `yaml:"Performance"`
+	Resume        ConfigResume        `yaml:"Resume"`
+	Reconnect     ConfigReconnect     `yaml:"Reconnect"`
 }
 
 type ConfigData struct {
-	ProxyAddress  ConfigAddress `yaml:"ProxyAddress"`
-	ServerAddress ConfigAddress `yaml:"ServerAddress"`
-	Api           ConfigApi     `yaml:"Api"`
-	Logging       ConfigLogging `yaml:"Logging"`
-	Lua           ConfigLua     `yaml:"Lua"`
-	Debug         ConfigDebug   `yaml:"Debug"`
+	ConfigRoute `yaml:",inline"`
+	Routes      []ConfigRoute `yaml:"Routes"`
+	Api         ConfigApi     `yaml:"Api"`
+	Logging     ConfigLogging `yaml:"Logging"`
+	Lua         ConfigLua     `yaml:"Lua"`
+	Debug       ConfigDebug   `yaml:"Debug"`
 }
 
 func (c *ConfigData) IsEmpty() bool {
-	if !c.ProxyAddress.IsEmpty() {
+	if !c.ProxyAddress.IsEmpty() || len(c.Routes) != 0 {
 		return false
 	}
 	if !c.ServerAddress.IsEmpty() {
@@ -134,6 +394,32 @@ func (c *ConfigData) IsEmpty() bool {
 	return true
 }
 
+// Gets every route, the top level is the first route unless it has no ProxyAddress & Routes is set.
+// The top level route is named "default" if it has no Name, every other route needs a unique Name.
+func (c *ConfigData) GetRoutes() ([]*ConfigRoute, error) {
+	routes := make([]*ConfigRoute, 0, len(c.Routes)+1)
+	if !c.ProxyAddress.IsEmpty() || len(c.Routes) == 0 {
+		if c.Name == "" {
+			c.Name = handler.DefaultRouteName
+		}
+		routes = append(routes, &c.ConfigRoute)
+	}
+	for k := range c.Routes {
+		if c.Routes[k].Name == "" {
+			return nil, fmt.Errorf("invalid Routes[%d], it has no Name", k)
+		}
+		routes = append(routes, &c.Routes[k])
+	}
+	names := make(map[string]struct{}, len(routes))
+	for _, v := range routes {
+		if _, ok := names[v.Name]; ok {
+			return nil, fmt.Errorf("route '%s' is defined more than once", v.Name)
+		}
+		names[v.Name] = struct{}{}
+	}
+	return routes, nil
+}
+
 func loadCfg() *ConfigData {
 	cfgData, err := os.ReadFile("config.yaml")
 	if err != nil {
@@ -181,25 +467,298 @@ func setupLogger(cfg *ConfigData) {
 	slog.SetDefault(logger)
 }
 
-func setupSpawnerAndApi(cfg *ConfigData) handler.IProxySpawner {
-	logger := slog.Default()
-	pxAddr, err := net.ResolveTCPAddr("tcp", cfg.ProxyAddress.ToString())
+// Creates the listeners a route asks for, ca is only set if TLS interception is enabled.
+func setupListeners(cfg *ConfigRoute) (listeners []handler.NamedListener, ca *proxy.CertAuthority, err error) {
+	enabled := 0
+	for _, v := range []bool{cfg.Tls.Enable, cfg.Sni.Enable, cfg.Socks.Enable, cfg.HttpConnect.Enable, cfg.WebSocket.Enable} {
+		if v {
+			enabled++
+		}
+	}
+	if enabled > 1 {
+		return nil, nil, errors.New("only one of Tls, Sni, Socks, HttpConnect and WebSocket can be enabled")
+	}
+	// Unix stream sockets can't carry datagrams & unixgram sockets can't carry streams
+	pxNet, svNet := cfg.ProxyAddress.UnixNetwork(), cfg.ServerAddress.UnixNetwork()
+	for k, v := range cfg.Upstreams.Servers {
+		if v.UnixNetwork() != svNet {
+			return nil, nil, fmt.Errorf("invalid Upstreams.Servers[%d], it must be the same type of address as ServerAddress", k)
+		}
+	}
+	if _, err := cfg.Upstreams.ToStrategy(); err != nil {
+		return nil, nil, err
+	}
+	if cfg.PortRange.Enable {
+		if enabled != 0 {
+			return nil, nil, errors.New("PortRange can only be used by the TCP and UDP listeners")
+		}
+		if pxNet != "" || svNet != "" {
+			return nil, nil, errors.New("PortRange needs IP addresses for ProxyAddress and ServerAddress")
+		}
+		if cfg.ProxyAddress.Port == 0 {
+			return nil, nil, errors.New("PortRange needs a ProxyAddress.Port")
+		}
+		if cfg.PortRange.LastPort < cfg.ProxyAddress.Port {
+			return nil, nil, fmt.Errorf("invalid PortRange.LastPort %d, must be at least ProxyAddress.Port %d", cfg.PortRange.LastPort, cfg.ProxyAddress.Port)
+		}
+	}
+	if enabled != 0 {
+		// Only the TCP listener takes these, the others would silently ignore them
+		for _, v := range []struct {
+			name string
+			set  bool
+		}{
+			{"ProxyProtocol.Send", cfg.ProxyProtocol.Send != 0},
+			{"ProxyProtocol.Accept", cfg.ProxyProtocol.Accept},
+			{"Performance.Splice", cfg.Performance.Splice},
+			{"Performance.ReadSize", cfg.Performance.ReadSize != 0 && cfg.Performance.ReadSize != proxy.DefaultReadSize},
+			{"Resume", cfg.Resume.Enable},
+			{"Reconnect", cfg.Reconnect.Enable},
+		} {
+			if v.set {
+				return nil, nil, fmt.Errorf("%s can only be used by the TCP and UDP listeners, not with Tls, Sni, Socks, HttpConnect or WebSocket", v.name)
+			}
+		}
+	}
+	if err := cfg.Networks.validate(); err != nil {
+		return nil, nil, err
+	}
+	if (cfg.Networks.TcpPort != 0 || cfg.Networks.UdpPort != 0) && pxNet != "" {
+		return nil, nil, errors.New("Networks.TcpPort and Networks.UdpPort need an IP address for ProxyAddress")
+	}
+	stream := pxNet != "unixgram" && svNet != "unixgram"
+	datagram := pxNet != "unix" && svNet != "unix"
+	if cfg.ProxyProtocol.Send < 0 || cfg.ProxyProtocol.Send > 2 {
+		return nil, nil, fmt.Errorf("invalid ProxyProtocol.Send %d, must be 0, 1 or 2", cfg.ProxyProtocol.Send)
+	}
+	if cfg.Performance.ReadSize < 0 {
+		return nil, nil, fmt.Errorf("invalid Performance.ReadSize %d, must be 0 for the default or more", cfg.Performance.ReadSize)
+	}
+	if cfg.Performance.MaxDatagramSize < 0 || cfg.Performance.MaxDatagramSize > 65535 {
+		return nil, nil, fmt.Errorf("invalid Performance.MaxDatagramSize %d, must be 0 for the default or up to 65535", cfg.Performance.MaxDatagramSize)
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{{"Buffer", cfg.Reconnect.Buffer}, {"Deadline", cfg.Reconnect.Deadline}, {"MinBackoff", cfg.Reconnect.MinBackoff}, {"MaxBackoff", cfg.Reconnect.MaxBackoff}} {
+		if v.value < 0 {
+			return nil, nil, fmt.Errorf("invalid Reconnect.%s %d, must be 0 for the default or more", v.name, v.value)
+		}
+	}
+	if cfg.Resume.Timeout < 0 {
+		return nil, nil, fmt.Errorf("invalid Resume.Timeout %d, must be 0 for the default or more", cfg.Resume.Timeout)
+	}
+	tcpOpts := proxy.ListenerOptions{
+		SendProxyHeader:   proxy.ProxyProtocolVersion(cfg.ProxyProtocol.Send),
+		AcceptProxyHeader: cfg.ProxyProtocol.Accept,
+		Splice:            cfg.Performance.Splice,
+		ReadSize:          cfg.Performance.ReadSize,
+		Resume:            cfg.Resume.Enable,
+		ResumeTimeout:     time.Duration(cfg.Resume.Timeout) * time.Millisecond,
+	}
+	if cfg.Reconnect.Enable {
+		tcpOpts.Reconnect = &proxy.ReconnectPolicy{
+			Buffer:     cfg.Reconnect.Buffer,
+			Deadline:   time.Duration(cfg.Reconnect.Deadline) * time.Millisecond,
+			MinBackoff: time.Duration(cfg.Reconnect.MinBackoff) * time.Millisecond,
+			MaxBackoff: time.Duration(cfg.Reconnect.MaxBackoff) * time.Millisecond,
+		}
+	}
+	udpOpts := proxy.ListenerOptions{
+		MaxDatagramSize: cfg.Performance.MaxDatagramSize,
+	}
+	if cfg.PortRange.Enable {
+		tcpOpts.LastPort, err = shiftLastPort(cfg, cfg.Networks.TcpPort)
+		if err != nil {
+			return nil, nil, err
+		}
+		udpOpts.LastPort, err = shiftLastPort(cfg, cfg.Networks.UdpPort)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if tcpOpts.SendProxyHeader == proxy.ProxyProtocolV2 {
+		udpOpts.SendProxyHeader = proxy.ProxyProtocolV2
+	}
+	var streamListener handler.IProxyListener
+	switch {
+	case cfg.Tls.Enable:
+		tlsCfg := proxy.TlsConfig{
+			CertFile:           cfg.Tls.CertFile,
+			KeyFile:            cfg.Tls.KeyFile,
+			ServerTls:          cfg.Tls.ServerTls,
+			ServerName:         cfg.Tls.ServerName,
+			InsecureSkipVerify: cfg.Tls.InsecureSkipVerify,
+		}
+		switch cfg.Tls.Mode {
+		case "", "static":
+		case "intercept":
+			ca, err = proxy.LoadOrCreateCertAuthority(cfg.Tls.CaCertFile, cfg.Tls.CaKeyFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			tlsCfg.Ca = ca
+		default:
+			return nil, nil, fmt.Errorf("invalid Tls.Mode '%s', must be 'static' or 'intercept'", cfg.Tls.Mode)
+		}
+		streamListener, err = proxy.NewTlsListener(tlsCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+	case cfg.Sni.Enable:
+		routes := make(map[string]net.Addr, len(cfg.Sni.Routes))
+		for name, addr := range cfg.Sni.Routes {
+			routes[name], err = addr.Resolve()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to resolve SNI route '%s': %v", name, err)
+			}
+		}
+		streamListener = proxy.NewSniListener(routes)
+	case cfg.Socks.Enable:
+		if pxNet == "unixgram" {
+			return nil, nil, errors.New("can't use a unixgram ProxyAddress with Socks")
+		}
+		// UDP is relayed through the SOCKS connection
+		stream, datagram = true, false
+		streamListener = proxy.NewSocks5Listener(proxy.Socks5Config{
+			Username: cfg.Socks.Username,
+			Password: cfg.Socks.Password,
+		})
+	case cfg.HttpConnect.Enable:
+		if pxNet == "unixgram" {
+			return nil, nil, errors.New("can't use a unixgram ProxyAddress with HttpConnect")
+		}
+		stream, datagram = true, false
+		streamListener = proxy.NewHttpConnectListener(proxy.HttpConnectConfig{
+			Username: cfg.HttpConnect.Username,
+			Password: cfg.HttpConnect.Password,
+		})
+	case cfg.WebSocket.Enable:
+		wsCfg := proxy.WsBridgeConfig{
+			OriginPatterns: cfg.WebSocket.OriginPatterns,
+		}
+		switch cfg.WebSocket.MessageType {
+		case "", "binary":
+			wsCfg.MessageType = websocket.MessageBinary
+		case "text":
+			wsCfg.MessageType = websocket.MessageText
+		default:
+			return nil, nil, fmt.Errorf("invalid WebSocket.MessageType '%s', must be 'binary' or 'text'", cfg.WebSocket.MessageType)
+		}
+		streamListener = proxy.NewWsBridgeListener(wsCfg)
+	default:
+		streamListener = proxy.NewTcpListener(tcpOpts)
+	}
+	if stream {
+		l, err := newNamedListener(cfg, listenerTcp, cfg.Networks.TcpPort, streamListener)
+		if err != nil {
+			return nil, nil, err
+		}
+		listeners = append(listeners, l)
+	} else if enabled != 0 {
+		return nil, nil, errors.New("the enabled listener needs stream sockets, it can't be used with unixgram addresses")
+	}
+	if datagram {
+		l, err := newNamedListener(cfg, listenerUdp, cfg.Networks.UdpPort, proxy.NewUdpListener(udpOpts))
+		if err != nil {
+			return nil, nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, nil, errors.New("the proxy and server addresses have no network in common")
+	}
+	for _, name := range cfg.Networks.Start {
+		if !slices.ContainsFunc(listeners, func(l handler.NamedListener) bool { return l.Name == name }) {
+			return nil, nil, fmt.Errorf("can't start the %s listener, the proxy and server addresses don't support it", name)
+		}
+	}
+	return listeners, ca, nil
+}
+
+// Names h, it listens on port instead of ProxyAddress.Port if port isn't 0
+func newNamedListener(cfg *ConfigRoute, name string, port uint16, h handler.IProxyListener) (handler.NamedListener, error) {
+	l := handler.NamedListener{
+		Name:     name,
+		Listener: h,
+		Stopped:  !cfg.Networks.starts(name),
+	}
+	if port != 0 {
+		addr := cfg.ProxyAddress
+		addr.Port = port
+		resolved, err := addr.Resolve()
+		if err != nil {
+			return l, fmt.Errorf("failed to resolve %s listener address '%s': %v", name, addr.ToString(), err)
+		}
+		l.Addr = resolved
+	}
+	return l, nil
+}
+
+// Gets the last port of a listener on port (0 for ProxyAddress.Port), the range is the same size as ProxyAddress.Port to PortRange.LastPort
+func shiftLastPort(cfg *ConfigRoute, port uint16) (int, error) {
+	if port == 0 {
+		return int(cfg.PortRange.LastPort), nil
+	}
+	last := int(port) + int(cfg.PortRange.LastPort-cfg.ProxyAddress.Port)
+	if last > 65535 {
+		return 0, fmt.Errorf("PortRange from port %d goes past port 65535", port)
+	}
+	return last, nil
+}
+
+// Creates the spawner of a route, ca is only set if TLS interception is enabled.
+func setupSpawner(cfg *ConfigRoute) (ps *handler.ProxySpawner, ca *proxy.CertAuthority, err error) {
+	logger := slog.Default().With("Route", cfg.Name)
+	pxAddr, err := cfg.ProxyAddress.Resolve()
 	if err != nil {
-		logger.Error("Failed to resolve proxy address", "Error", err.Error(), "Address", cfg.ProxyAddress.ToString())
-		return nil
+		return nil, nil, fmt.Errorf("failed to resolve proxy address '%s': %v", cfg.ProxyAddress.ToString(), err)
 	}
-	svAddr, err := net.ResolveTCPAddr("tcp", cfg.ServerAddress.ToString())
+	svAddr, err := cfg.ServerAddress.Resolve()
 	if err != nil {
-		logger.Error("Failed to resolve server address", "Error", err.Error(), "Address", cfg.ServerAddress.ToString())
-		return nil
+		return nil, nil, fmt.Errorf("failed to resolve server address '%s': %v", cfg.ServerAddress.ToString(), err)
 	}
-	ctx, cancel := context.WithCancelCause(context.Background())
-	logger.Debug("Setup proxySpawner", "Server", svAddr.String(), "Proxy", pxAddr.String())
-	ps, err := handler.NewProxySpawner(svAddr, pxAddr, ctx, proxy.TcpListener, proxy.UdpListener)
+	listeners, ca, err := setupListeners(cfg)
 	if err != nil {
-		logger.Error("Failed to create ProxySpawner", "Error", err.Error())
-		cancel(err)
-		return nil
+		return nil, nil, fmt.Errorf("failed to setup listeners: %v", err)
+	}
+	healthCheck, err := cfg.HealthCheck.ToHealthCheck(cfg.ServerAddress.UnixNetwork())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to setup health checks: %v", err)
+	}
+	mirror, err := cfg.Mirror.ToMirror()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to setup mirror: %v", err)
+	}
+	logger.Debug("Setup proxySpawner", "Server", svAddr.String(), "Proxy", pxAddr.String(), "Tls", cfg.Tls.Enable, "Sni", cfg.Sni.Enable, "Socks", cfg.Socks.Enable, "HttpConnect", cfg.HttpConnect.Enable, "WebSocket", cfg.WebSocket.Enable)
+	ps, err = handler.NewProxySpawnerWithListeners(svAddr, pxAddr, context.Background(), listeners...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create ProxySpawner: %v", err)
+	}
+	if len(cfg.Upstreams.Servers) != 0 {
+		upstreams := []net.Addr{svAddr}
+		for k, v := range cfg.Upstreams.Servers {
+			addr, err := v.Resolve()
+			if err != nil {
+				ps.Close()
+				return nil, nil, fmt.Errorf("failed to resolve upstream address %d '%s': %v", k, v.ToString(), err)
+			}
+			upstreams = append(upstreams, addr)
+		}
+		// Checked by setupListeners
+		strategy, _ := cfg.Upstreams.ToStrategy()
+		if err := ps.SetUpstreams(upstreams, strategy); err != nil {
+			ps.Close()
+			return nil, nil, fmt.Errorf("failed to set upstreams: %v", err)
+		}
+	}
+	if healthCheck != nil {
+		// Only fails without a probe
+		ps.SetHealthCheck(healthCheck)
+	}
+	if mirror != nil {
+		// Checked by ToMirror
+		ps.SetMirror(mirror)
 	}
 	ps.SetErrorCallback(func(err error, pc handler.IProxyContainer) {
 		if pc == nil {
@@ -208,24 +767,51 @@ func setupSpawnerAndApi(cfg *ConfigData) handler.IProxySpawner {
 			logger.Error("Proxy error", "Id", pc.GetId(), "Network", pc.Network(), "Error", err.Error())
 		}
 	})
+	return ps, ca, nil
+}
+
+func setupSpawnerAndApi(cfg *ConfigData) *handler.SpawnerManager {
+	logger := slog.Default()
+	routeCfgs, err := cfg.GetRoutes()
+	if err != nil {
+		logger.Error("Invalid routes", "Error", err.Error())
+		return nil
+	}
+	routes := handler.NewSpawnerManager()
+	cas := make(map[string]*proxy.CertAuthority)
+	for _, v := range routeCfgs {
+		ps, ca, err := setupSpawner(v)
+		if err != nil {
+			logger.Error("Failed to setup route", "Route", v.Name, "Error", err.Error())
+			routes.Close()
+			return nil
+		}
+		// Names are checked by GetRoutes
+		routes.AddRoute(v.Name, ps)
+		if ca != nil {
+			cas[v.Name] = ca
+		}
+	}
 	if cfg.Api.Enable {
-		web := api.NewWebApi(http.DefaultServeMux, cfg.Api.UseAuth, ps)
+		web := api.NewWebApiWithRoutes(http.DefaultServeMux, cfg.Api.UseAuth, routes)
+		for name, ca := range cas {
+			web.SetRouteCaCertificate(name, ca.CertPem())
+		}
 		if cfg.Debug.Enable && cfg.Api.UseAuth {
 			logger.Info("Adding debug api key", "Key", cfg.Debug.ApiKey)
 			err = web.AddAuth(cfg.Debug.ApiKey, api.AuthAll)
 			if err != nil {
-				logger.Error("Failed to add default admin auth", "Error", err.Error)
-				cancel(err)
+				logger.Error("Failed to add default admin auth", "Error", err.Error())
+				routes.Close()
 				return nil
 			}
 		}
 		logger.Debug("Starting API", "Address", cfg.Api.Address.ToString())
 		go http.ListenAndServe(cfg.Api.Address.ToString(), nil)
 	}
-	return ps
+	return routes
 }
 
-func run(px handler.IProxySpawner) {
-	psCtx := px.GetContext()
-	<-psCtx.Done()
+func run(routes *handler.SpawnerManager) {
+	routes.Wait()
 }
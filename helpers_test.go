@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// setupListeners, Ensure options only the TCP listener takes are rejected with the other stream listeners
+//
+// Expect: A error naming the option for each mode, the default ReadSize & the TCP listener are fine
+func TestSetupListenersTcpOnlyOptions(t *testing.T) {
+	base := func() ConfigRoute {
+		return ConfigRoute{
+			ProxyAddress:  ConfigAddress{Address: "127.0.0.1", Port: 9000},
+			ServerAddress: ConfigAddress{Address: "127.0.0.1", Port: 9001},
+		}
+	}
+	modes := map[string]func(cfg *ConfigRoute){
+		"Tls":         func(cfg *ConfigRoute) { cfg.Tls.Enable = true },
+		"Sni":         func(cfg *ConfigRoute) { cfg.Sni.Enable = true },
+		"Socks":       func(cfg *ConfigRoute) { cfg.Socks.Enable = true },
+		"HttpConnect": func(cfg *ConfigRoute) { cfg.HttpConnect.Enable = true },
+		"WebSocket":   func(cfg *ConfigRoute) { cfg.WebSocket.Enable = true },
+	}
+	options := map[string]func(cfg *ConfigRoute){
+		"ProxyProtocol.Send":   func(cfg *ConfigRoute) { cfg.ProxyProtocol.Send = 2 },
+		"ProxyProtocol.Accept": func(cfg *ConfigRoute) { cfg.ProxyProtocol.Accept = true },
+		"Performance.Splice":   func(cfg *ConfigRoute) { cfg.Performance.Splice = true },
+		"Performance.ReadSize": func(cfg *ConfigRoute) { cfg.Performance.ReadSize = 1024 },
+		"Resume":               func(cfg *ConfigRoute) { cfg.Resume.Enable = true },
+		"Reconnect":            func(cfg *ConfigRoute) { cfg.Reconnect.Enable = true },
+	}
+	for mode, setMode := range modes {
+		for option, setOption := range options {
+			cfg := base()
+			setMode(&cfg)
+			setOption(&cfg)
+			if _, _, err := setupListeners(&cfg); err == nil || !strings.Contains(err.Error(), option) {
+				t.Errorf("%s with %s: Expected a error naming the option, got %v", mode, option, err)
+			}
+		}
+	}
+	cfg := base()
+	cfg.Sni.Enable = true
+	cfg.Performance.ReadSize = 4096
+	if _, _, err := setupListeners(&cfg); err != nil {
+		t.Errorf("Sni with the default ReadSize: Expected no error, got %v", err)
+	}
+	cfg = base()
+	for _, setOption := range options {
+		setOption(&cfg)
+	}
+	if _, _, err := setupListeners(&cfg); err != nil {
+		t.Errorf("TCP listener with every option: Expected no error, got %v", err)
+	}
+}
@@ -15,7 +15,7 @@ func main() {
 	cfg := loadCfg()
 	setupLogger(cfg)
 	slog.Default().Warn("Compiled with LUA support, this is a experimental feature and will likely change in the future, it is also very likely to cause crashes if your lua code is bad")
-	ps := setupSpawnerAndApi(cfg)
+	routes := setupSpawnerAndApi(cfg)
 	if cfg.Lua.Enable {
 		mode := ezp_lua.LuaRunMain
 		switch cfg.Lua.Mode {
@@ -27,11 +27,11 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Invalid Lua.Mode, must be 'main' or 'callback', was %s\n", cfg.Lua.Mode)
 			return
 		}
-		err := ezp_lua.NewLuaBindingFromFile(ps, cfg.Lua.Path, mode)
+		err := ezp_lua.NewLuaBindingWithRoutes(routes, cfg.Lua.Route, cfg.Lua.Path, mode)
 		if err != nil {
-			slog.Default().Warn("LUA bindings failed to execute", "Error", err, "Path", "test.lua")
+			slog.Default().Warn("LUA bindings failed to execute", "Error", err, "Path", "test.lua", "Route", cfg.Lua.Route)
 			return
 		}
 	}
-	run(ps)
+	run(routes)
 }
@@ -11,6 +11,6 @@ func main() {
 	if cfg.Lua.Enable {
 		slog.Warn("LUA enabled in config, but this version of EzProxy was built without lua_bindings build tag")
 	}
-	ps := setupSpawnerAndApi(cfg)
-	run(ps)
+	routes := setupSpawnerAndApi(cfg)
+	run(routes)
 }
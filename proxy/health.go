@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Most bytes read from a stream looking for the expected reply
+const healthReplyMaxLength int = 4096
+
+// Settings for NewHealthProbe
+type HealthProbeConfig struct {
+	Datagram bool   // Probe over UDP, or unixgram for unixgram upstreams, instead of TCP. Needs Send & Expect
+	Send     []byte // Sent once connected, nil to only connect
+	Expect   []byte // A reply must contain this, nil to not wait for one
+}
+
+// Creates a health probe that connects to the upstream, sends cfg.Send & waits for a reply containing cfg.Expect.
+// Stream replies are read until they contain it, datagrams until one does.
+func NewHealthProbe(cfg HealthProbeConfig) (handler.HealthProbe, error) {
+	if cfg.Datagram && (len(cfg.Send) == 0 || len(cfg.Expect) == 0) {
+		return nil, errors.New("datagram health checks need data to send & a reply to expect")
+	}
+	return func(ctx context.Context, addr net.Addr) error {
+		c, err := dialHealthProbe(ctx, cfg.Datagram, addr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// Unblocks reads & writes if ctx is cancelled before its deadline
+		stop := context.AfterFunc(ctx, func() { c.Close() })
+		defer stop()
+		if deadline, ok := ctx.Deadline(); ok {
+			c.SetDeadline(deadline)
+		}
+		if len(cfg.Send) != 0 {
+			if _, err := c.Write(cfg.Send); err != nil {
+				return fmt.Errorf("failed to send probe: %v", err)
+			}
+		}
+		if len(cfg.Expect) == 0 {
+			return nil
+		}
+		if err := readHealthReply(c, cfg.Datagram, cfg.Expect); err != nil {
+			if ctx.Err() != nil {
+				// The read error is only from c being closed or its deadline
+				return errors.New("didn't get the expected reply in time")
+			}
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// Connects to the upstream in the probes network
+func dialHealthProbe(ctx context.Context, datagram bool, addr net.Addr) (net.Conn, error) {
+	if datagram {
+		dAddr, err := resolveDatagramAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialDatagram(dAddr)
+	}
+	sAddr, err := resolveStreamAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, sAddr.Network(), sAddr.String())
+}
+
+// Reads from c until the reply contains expect
+func readHealthReply(c net.Conn, datagram bool, expect []byte) error {
+	var reply []byte
+	buffer := make([]byte, healthReplyMaxLength)
+	for {
+		n, err := c.Read(buffer)
+		if datagram {
+			reply = buffer[:n]
+		} else {
+			reply = append(reply, buffer[:n]...)
+		}
+		if bytes.Contains(reply, expect) {
+			return nil
+		}
+		if err == io.EOF {
+			return errors.New("server closed before sending the expected reply")
+		}
+		if err != nil {
+			return fmt.Errorf("didn't get the expected reply: %v", err)
+		}
+		if len(reply) >= healthReplyMaxLength {
+			return errors.New("reply didn't contain the expected data")
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"ezproxy/handler"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	httpConnectHandshakeTimeout time.Duration = time.Second * 10 // Time a client has to send the CONNECT request
+	httpConnectDialTimeout      time.Duration = time.Second * 10 // Time to connect to the requested host
+)
+
+// HTTP CONNECT settings for a HttpConnectListener
+type HttpConnectConfig struct {
+	Username string // If set clients must send a matching Basic Proxy-Authorization header
+	Password string
+}
+
+// Checks a Proxy-Authorization header against cfg
+func httpConnectAuthorized(cfg *HttpConnectConfig, header string) bool {
+	scheme, encoded, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, pass, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return false
+	}
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.Password)) == 1
+	return userOk && passOk
+}
+
+// Writes a response with no body, extra is added as headers
+func httpConnectReply(c net.Conn, status int, extra ...string) error {
+	msg := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for _, h := range extra {
+		msg += h + "\r\n"
+	}
+	if status != http.StatusOK {
+		msg += "Content-Length: 0\r\nConnection: close\r\n"
+	}
+	_, err := c.Write([]byte(msg + "\r\n"))
+	return err
+}
+
+// Reads the CONNECT request, connects to the host & adds the proxy.
+func addHttpConnectConnection(ps handler.IConnectionAdder, cfg *HttpConnectConfig, c net.Conn) {
+	logger := slog.Default()
+	c.SetDeadline(time.Now().Add(httpConnectHandshakeTimeout))
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		logger.Debug("Failed to read CONNECT request", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		httpConnectReply(c, http.StatusBadRequest)
+		c.Close()
+		return
+	}
+	req.Body.Close()
+	if req.Method != http.MethodConnect {
+		logger.Debug("Not a CONNECT request", "Method", req.Method, "ClientAddr", c.RemoteAddr().String())
+		httpConnectReply(c, http.StatusMethodNotAllowed, "Allow: CONNECT")
+		c.Close()
+		return
+	}
+	if cfg.Username != "" && !httpConnectAuthorized(cfg, req.Header.Get("Proxy-Authorization")) {
+		logger.Debug("CONNECT authentication failed", "ClientAddr", c.RemoteAddr().String())
+		httpConnectReply(c, http.StatusProxyAuthRequired, `Proxy-Authenticate: Basic realm="ezproxy"`)
+		c.Close()
+		return
+	}
+	dest := req.Host
+	if _, _, err := net.SplitHostPort(dest); err != nil {
+		logger.Debug("Invalid CONNECT host", "Error", err.Error(), "Destination", dest)
+		httpConnectReply(c, http.StatusBadRequest)
+		c.Close()
+		return
+	}
+	s, err := net.DialTimeout("tcp", dest, httpConnectDialTimeout)
+	if err != nil {
+		logger.Debug("Failed to connect to CONNECT destination", "Error", err.Error(), "Destination", dest)
+		status := http.StatusBadGateway
+		if isTimeoutError(err) {
+			status = http.StatusGatewayTimeout
+		}
+		httpConnectReply(c, status)
+		c.Close()
+		return
+	}
+	if err := httpConnectReply(c, http.StatusOK); err != nil {
+		c.Close()
+		s.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+	// The client may have sent data after the request before we replied, it's already in the reader
+	var client net.Conn = c
+	if br.Buffered() > 0 {
+		read, _ := br.Peek(br.Buffered())
+		client = newPrefixConn(c, read)
+	}
+	px := newTcpProxy(client, s)
+	px.metadata = map[string]string{
+		"HttpConnect": "connect",
+		"Destination": dest,
+	}
+	logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", s.RemoteAddr().String(), "Destination", dest)
+	if _, err := ps.AddConnection(px); err != nil {
+		logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		s.Close()
+	}
+}
+
+// Creates a HTTP/1.1 CONNECT proxy listener, the server each client connects to comes from its request instead of the spawners server address.
+// If cfg.Username is set clients must authenticate with Basic Proxy-Authorization.
+func NewHttpConnectListener(cfg HttpConnectConfig) handler.IProxyListener {
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		acceptTcp(ctx, cancel, ps, func(c net.Conn, _ net.Addr, _ net.Addr) {
+			go addHttpConnectConnection(ps, &cfg, c)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Sends request on a new connection to the listener at addr & reads the response
+func httpConnectRequest(t *testing.T, addr string, request string) (net.Conn, *http.Response) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.Write([]byte(request))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	c.SetReadDeadline(time.Time{})
+	return c, resp
+}
+
+// NewHttpConnectListener, Ensure CONNECT requests are proxied & others are rejected
+//
+// Expect: 200 & data reaches the host, data sent with the request isn't lost, other methods get 405, bad auth gets 407
+func TestHttpConnectListener(t *testing.T) {
+	dest := startTagServer(t, "tcp", "127.0.0.1:0", "ok:")
+	ps, pAddr := startSpawner(t, "tcp", mustTcpAddr(t, "127.0.0.1:1"), NewHttpConnectListener(HttpConnectConfig{Username: "user", Password: "pass"}))
+	auth := "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")) + "\r\n"
+	c, resp := httpConnectRequest(t, pAddr.String(), "CONNECT "+dest.String()+" HTTP/1.1\r\nHost: "+dest.String()+"\r\n"+auth+"\r\nearly")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if got, err := readWithin(c, 8, time.Second); err != nil || string(got) != "ok:early" {
+		t.Errorf("Expected the data sent with the request to reach the host, got %q %v", got, err)
+	}
+	c.Write([]byte("ping"))
+	if got, err := readWithin(c, 7, time.Second); err != nil || string(got) != "ok:ping" {
+		t.Errorf("Expected the hosts reply, got %q %v", got, err)
+	}
+	if proxies := ps.GetAllProxies(); len(proxies) != 1 || getMetadata(proxies[0])["Destination"] != dest.String() {
+		t.Errorf("Expected a proxy to %s", dest)
+	}
+	for _, v := range []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"GET", "GET / HTTP/1.1\r\nHost: a\r\n" + auth + "\r\n", http.StatusMethodNotAllowed},
+		{"no auth", "CONNECT " + dest.String() + " HTTP/1.1\r\nHost: " + dest.String() + "\r\n\r\n", http.StatusProxyAuthRequired},
+		{"bad password", "CONNECT " + dest.String() + " HTTP/1.1\r\nHost: " + dest.String() + "\r\nProxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("user:nope")) + "\r\n\r\n", http.StatusProxyAuthRequired},
+		{"no port", "CONNECT localhost HTTP/1.1\r\nHost: localhost\r\n" + auth + "\r\n", http.StatusBadRequest},
+	} {
+		if _, resp := httpConnectRequest(t, pAddr.String(), v.request); resp.StatusCode != v.status {
+			t.Errorf("%s: Expected %d, got %d", v.name, v.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the HAProxy PROXY protocol
+type ProxyProtocolVersion int
+
+const (
+	ProxyProtocolNone ProxyProtocolVersion = 0 // Don't use the PROXY protocol
+	ProxyProtocolV1   ProxyProtocolVersion = 1 // Human readable header, TCP only
+	ProxyProtocolV2   ProxyProtocolVersion = 2 // Binary header, TCP & UDP
+)
+
+// Signature every v2 header starts with
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyV2VersionProxy byte = 0x21 // Version 2, PROXY command
+	proxyV2VersionLocal byte = 0x20 // Version 2, LOCAL command
+
+	proxyV2FamUnspec byte = 0x00
+	proxyV2FamTcp4   byte = 0x11
+	proxyV2FamUdp4   byte = 0x12
+	proxyV2FamTcp6   byte = 0x21
+	proxyV2FamUdp6   byte = 0x22
+
+	proxyHeaderTimeout time.Duration = time.Second * 5 // Time a load balancer has to send the PROXY header
+	proxyV1MaxLength   int           = 107             // Longest v1 header, including the CRLF
+)
+
+// Gets the IP & port of addr, ok is false if it isn't an IP address
+func addrIpPort(addr net.Addr) (ip net.IP, port int, ok bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, a.IP != nil
+	case *net.UDPAddr:
+		return a.IP, a.Port, a.IP != nil
+	default:
+		return nil, 0, false
+	}
+}
+
+// Builds a PROXY protocol header saying a connection came from client to dest, the address the client connected to.
+// Addresses that aren't IPs, like unix sockets, are sent as UNKNOWN in v1 and with the LOCAL command in v2.
+// udp is only allowed for v2.
+func buildProxyHeader(version ProxyProtocolVersion, udp bool, client net.Addr, dest net.Addr) ([]byte, error) {
+	srcIp, srcPort, srcOk := addrIpPort(client)
+	dstIp, dstPort, dstOk := addrIpPort(dest)
+	ipOk := srcOk && dstOk
+	// Both addresses have to be the same family, IPv4 is mapped to IPv6 if they aren't
+	v4 := ipOk && srcIp.To4() != nil && dstIp.To4() != nil
+	switch version {
+	case ProxyProtocolV1:
+		if udp {
+			return nil, errors.New("PROXY protocol v1 doesn't support UDP")
+		}
+		if !ipOk {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		if v4 {
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIp.To4(), dstIp.To4(), srcPort, dstPort)), nil
+		}
+		// net.IP.String prints mapped addresses as IPv4, v1 needs them in IPv6 form
+		return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(srcIp), ipv6String(dstIp), srcPort, dstPort)), nil
+	case ProxyProtocolV2:
+		header := append([]byte{}, proxyV2Signature...)
+		if !ipOk {
+			header = append(header, proxyV2VersionLocal, proxyV2FamUnspec)
+			return binary.BigEndian.AppendUint16(header, 0), nil
+		}
+		var fam byte
+		var addrs []byte
+		if v4 {
+			fam = proxyV2FamTcp4
+			if udp {
+				fam = proxyV2FamUdp4
+			}
+			addrs = append(append(addrs, srcIp.To4()...), dstIp.To4()...)
+		} else {
+			fam = proxyV2FamTcp6
+			if udp {
+				fam = proxyV2FamUdp6
+			}
+			addrs = append(append(addrs, srcIp.To16()...), dstIp.To16()...)
+		}
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcPort))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstPort))
+		header = append(header, proxyV2VersionProxy, fam)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+		return append(header, addrs...), nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", version)
+	}
+}
+
+// Formats ip in IPv6 form, even if it's an IPv4 address
+func ipv6String(ip net.IP) string {
+	ip = ip.To16()
+	if ip.To4() == nil {
+		return ip.String()
+	}
+	return "::ffff:" + ip.To4().String()
+}
+
+// Connection with the addresses from a PROXY header
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+	local  net.Addr
+}
+
+func (p *proxiedConn) RemoteAddr() net.Addr { return p.remote }
+func (p *proxiedConn) LocalAddr() net.Addr  { return p.local }
+
+// Reads a v1 header after "PROXY ", one byte at a time so nothing past the header is read
+func readProxyV1(c net.Conn, read []byte) (src net.Addr, dst net.Addr, err error) {
+	b := []byte{0}
+	for !bytes.HasSuffix(read, []byte("\r\n")) {
+		if len(read) >= proxyV1MaxLength {
+			return nil, nil, errors.New("PROXY v1 header too long")
+		}
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil, nil, err
+		}
+		read = append(read, b[0])
+	}
+	fields := strings.Fields(string(read))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header %q", read)
+	}
+	v4 := fields[1] == "TCP4"
+	srcAddr, err := parseProxyV1Addr(fields[2], fields[4], v4)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 source: %v", err)
+	}
+	dstAddr, err := parseProxyV1Addr(fields[3], fields[5], v4)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 destination: %v", err)
+	}
+	return srcAddr, dstAddr, nil
+}
+
+// Parses a v1 address, it must be a literal IP of the family in the header. Names are never resolved
+func parseProxyV1Addr(host string, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("'%s' isn't an IP address", host)
+	}
+	if v4 != (ip.To4() != nil && !strings.Contains(host, ":")) {
+		return nil, fmt.Errorf("'%s' isn't the family of the header", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// Reads a v2 header after the signature
+func readProxyV2(c net.Conn) (src net.Addr, dst net.Addr, err error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return nil, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(c, body); err != nil {
+		return nil, nil, err
+	}
+	if header[0]&0xf0 != 0x20 {
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 version %d", header[0]>>4)
+	}
+	// LOCAL, the connection is from the load balancer itself
+	if header[0] == proxyV2VersionLocal {
+		return nil, nil, nil
+	}
+	if header[0] != proxyV2VersionProxy {
+		return nil, nil, fmt.Errorf("unknown PROXY v2 command %d", header[0]&0x0f)
+	}
+	var size int
+	switch header[1] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// Unspecified or unix, keep the real addresses
+		return nil, nil, nil
+	}
+	// Anything after the addresses is TLVs, which are ignored
+	if len(body) < size*2+4 {
+		return nil, nil, errors.New("PROXY v2 header too short for its addresses")
+	}
+	srcIp := net.IP(append([]byte{}, body[:size]...))
+	dstIp := net.IP(append([]byte{}, body[size:size*2]...))
+	srcPort := int(binary.BigEndian.Uint16(body[size*2:]))
+	dstPort := int(binary.BigEndian.Uint16(body[size*2+2:]))
+	return &net.TCPAddr{IP: srcIp, Port: srcPort}, &net.TCPAddr{IP: dstIp, Port: dstPort}, nil
+}
+
+// Reads a PROXY v1 or v2 header from c, returning a connection that reports the addresses in it.
+// Connections without a header are an error. Headers that don't carry addresses (UNKNOWN & LOCAL) keep the real addresses.
+func acceptProxyHeader(c net.Conn) (net.Conn, error) {
+	c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	// Both versions are longer than the v2 signature
+	start := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(c, start); err != nil {
+		return nil, err
+	}
+	var src, dst net.Addr
+	var err error
+	switch {
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		src, dst, err = readProxyV1(c, start)
+	case bytes.Equal(start, proxyV2Signature):
+		src, dst, err = readProxyV2(c)
+	default:
+		return nil, errors.New("connection didn't start with a PROXY header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return c, nil
+	}
+	return &proxiedConn{Conn: c, remote: src, local: dst}, nil
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Gets a connection that reads data then EOF
+func connWithData(t *testing.T, data []byte) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// Builds a v2 header with a raw version/command byte, family & body
+func rawProxyV2(verCmd byte, fam byte, body []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, verCmd, fam)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+func mustTcpAddr(t *testing.T, addr string) *net.TCPAddr {
+	t.Helper()
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", addr, err)
+	}
+	return a
+}
+
+// buildProxyHeader, Ensure every version & family is built correctly
+//
+// Expect: Exact v1 lines & v2 bytes, non IP addresses are UNKNOWN or LOCAL, v1 UDP & unknown versions fail
+func TestBuildProxyHeader(t *testing.T) {
+	v4Src, v4Dst := mustTcpAddr(t, "1.2.3.4:1000"), mustTcpAddr(t, "5.6.7.8:2000")
+	v6Src, v6Dst := mustTcpAddr(t, "[2001:db8::1]:1000"), mustTcpAddr(t, "[2001:db8::2]:2000")
+	unix := &net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unix"}
+	v2Local := rawProxyV2(proxyV2VersionLocal, proxyV2FamUnspec, nil)
+	v2Tcp4 := rawProxyV2(proxyV2VersionProxy, proxyV2FamTcp4, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x03, 0xe8, 0x07, 0xd0})
+	v2Udp6Body := append(append(append([]byte{}, v6Src.IP.To16()...), v6Dst.IP.To16()...), 0x03, 0xe8, 0x07, 0xd0)
+	v2Udp6 := rawProxyV2(proxyV2VersionProxy, proxyV2FamUdp6, v2Udp6Body)
+	for _, v := range []struct {
+		name    string
+		version ProxyProtocolVersion
+		udp     bool
+		src     net.Addr
+		dst     net.Addr
+		expect  []byte
+		fails   bool
+	}{
+		{"v1 TCP4", ProxyProtocolV1, false, v4Src, v4Dst, []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\n"), false},
+		{"v1 TCP6", ProxyProtocolV1, false, v6Src, v6Dst, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 2000\r\n"), false},
+		{"v1 mixed families", ProxyProtocolV1, false, v4Src, v6Dst, []byte("PROXY TCP6 ::ffff:1.2.3.4 2001:db8::2 1000 2000\r\n"), false},
+		{"v1 UNKNOWN", ProxyProtocolV1, false, unix, unix, []byte("PROXY UNKNOWN\r\n"), false},
+		{"v1 UDP", ProxyProtocolV1, true, v4Src, v4Dst, nil, true},
+		{"v2 TCP4", ProxyProtocolV2, false, v4Src, v4Dst, v2Tcp4, false},
+		{"v2 UDP6", ProxyProtocolV2, true, v6Src, v6Dst, v2Udp6, false},
+		{"v2 unix", ProxyProtocolV2, false, unix, unix, v2Local, false},
+		{"unknown version", ProxyProtocolVersion(3), false, v4Src, v4Dst, nil, true},
+	} {
+		got, err := buildProxyHeader(v.version, v.udp, v.src, v.dst)
+		if v.fails {
+			if err == nil {
+				t.Errorf("%s: Expected a error, got %q", v.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Failed to build header: %v", v.name, err)
+			continue
+		}
+		if !bytes.Equal(got, v.expect) {
+			t.Errorf("%s: Expected %q got %q", v.name, v.expect, got)
+		}
+	}
+}
+
+// acceptProxyHeader, Ensure valid headers are read & the addresses reported
+//
+// Expect: RemoteAddr & LocalAddr are the header addresses, UNKNOWN, LOCAL & unix keep the real addresses, data after the header is untouched
+func TestAcceptProxyHeader(t *testing.T) {
+	tlv := append([]byte{0x04, 0x00, 0xff}, bytes.Repeat([]byte{'x'}, 0xff)...)
+	v2Tcp4 := rawProxyV2(proxyV2VersionProxy, proxyV2FamTcp4, append([]byte{1, 2, 3, 4, 5, 6, 7, 8, 0x03, 0xe8, 0x07, 0xd0}, tlv...))
+	v2Tcp6Body := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x03, 0xe8, 0x07, 0xd0)
+	for _, v := range []struct {
+		name   string
+		header []byte
+		src    string // "" if the real address is kept
+		dst    string
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\n"), "1.2.3.4:1000", "5.6.7.8:2000"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 2000\r\n"), "[2001:db8::1]:1000", "[2001:db8::2]:2000"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN ff ff 1 2\r\n"), "", ""},
+		{"v2 PROXY TCP4 with TLVs", v2Tcp4, "1.2.3.4:1000", "5.6.7.8:2000"},
+		{"v2 PROXY TCP6", rawProxyV2(proxyV2VersionProxy, proxyV2FamTcp6, v2Tcp6Body), "[2001:db8::1]:1000", "[2001:db8::2]:2000"},
+		{"v2 PROXY UDP4", rawProxyV2(proxyV2VersionProxy, proxyV2FamUdp4, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x03, 0xe8, 0x07, 0xd0}), "1.2.3.4:1000", "5.6.7.8:2000"},
+		{"v2 PROXY unix", rawProxyV2(proxyV2VersionProxy, 0x31, make([]byte, 216)), "", ""},
+		{"v2 LOCAL", rawProxyV2(proxyV2VersionLocal, proxyV2FamUnspec, nil), "", ""},
+	} {
+		raw := connWithData(t, append(append([]byte{}, v.header...), "payload"...))
+		c, err := acceptProxyHeader(raw)
+		if err != nil {
+			t.Errorf("%s: Failed to read header: %v", v.name, err)
+			continue
+		}
+		if v.src == "" {
+			if c != raw {
+				t.Errorf("%s: Expected the real addresses, got %v %v", v.name, c.RemoteAddr(), c.LocalAddr())
+			}
+		} else if c.RemoteAddr().String() != v.src || c.LocalAddr().String() != v.dst {
+			t.Errorf("%s: Expected %s %s, got %v %v", v.name, v.src, v.dst, c.RemoteAddr(), c.LocalAddr())
+		}
+		rest, _ := io.ReadAll(c)
+		if string(rest) != "payload" {
+			t.Errorf("%s: Expected the data after the header to be untouched, got %q", v.name, rest)
+		}
+	}
+}
+
+// acceptProxyHeader, Ensure invalid headers are rejected
+//
+// Expect: A error for each header
+func TestAcceptProxyHeaderInvalid(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		header []byte
+	}{
+		{"no header", []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")},
+		{"bad signature", append(append([]byte{}, proxyV2Signature[:11]...), 0x00, 0x21, 0x11, 0x00, 0x0c)},
+		{"short", []byte("PROXY")},
+		{"v1 over 107 bytes", []byte("PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n")},
+		{"v1 no CRLF", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000")},
+		{"v1 missing fields", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000\r\n")},
+		{"v1 unknown protocol", []byte("PROXY UDP4 1.2.3.4 5.6.7.8 1000 2000\r\n")},
+		{"v1 host name", []byte("PROXY TCP4 localhost 5.6.7.8 1000 2000\r\n")},
+		{"v1 wrong family", []byte("PROXY TCP4 2001:db8::1 5.6.7.8 1000 2000\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 70000\r\n")},
+		{"v2 truncated length", append(append([]byte{}, proxyV2Signature...), 0x21, 0x11)},
+		{"v2 truncated body", rawProxyV2(proxyV2VersionProxy, proxyV2FamTcp4, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x03, 0xe8, 0x07, 0xd0})[:20]},
+		{"v2 too short for addresses", rawProxyV2(proxyV2VersionProxy, proxyV2FamTcp6, make([]byte, 12))},
+		{"v2 bad version", rawProxyV2(0x11, proxyV2FamTcp4, make([]byte, 12))},
+		{"v2 unknown command", rawProxyV2(0x22, proxyV2FamTcp4, make([]byte, 12))},
+	} {
+		if c, err := acceptProxyHeader(connWithData(t, v.header)); err == nil {
+			t.Errorf("%s: Expected a error, got %v %v", v.name, c.RemoteAddr(), c.LocalAddr())
+		}
+	}
+}
+
+// buildProxyHeader & acceptProxyHeader, Ensure a built header reads back as the same addresses
+//
+// Expect: RemoteAddr & LocalAddr match the addresses the header was built with for v1, v2, IPv4, IPv6 & UDP
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	for _, v := range []struct {
+		version ProxyProtocolVersion
+		udp     bool
+		src     string
+		dst     string
+	}{
+		{ProxyProtocolV1, false, "10.0.0.1:40000", "10.0.0.2:443"},
+		{ProxyProtocolV1, false, "[2001:db8::1]:40000", "[2001:db8::2]:443"},
+		{ProxyProtocolV2, false, "10.0.0.1:40000", "10.0.0.2:443"},
+		{ProxyProtocolV2, false, "[2001:db8::1]:40000", "[2001:db8::2]:443"},
+		{ProxyProtocolV2, true, "10.0.0.1:40000", "10.0.0.2:53"},
+		{ProxyProtocolV2, true, "[2001:db8::1]:40000", "[2001:db8::2]:53"},
+	} {
+		src, dst := mustTcpAddr(t, v.src), mustTcpAddr(t, v.dst)
+		header, err := buildProxyHeader(v.version, v.udp, src, dst)
+		if err != nil {
+			t.Fatalf("v%d %s: Failed to build header: %v", v.version, v.src, err)
+		}
+		c, err := acceptProxyHeader(connWithData(t, header))
+		if err != nil {
+			t.Fatalf("v%d %s: Failed to read header: %v", v.version, v.src, err)
+		}
+		if c.RemoteAddr().String() != v.src || c.LocalAddr().String() != v.dst {
+			t.Errorf("v%d udp %v: Expected %s %s, got %v %v", v.version, v.udp, v.src, v.dst, c.RemoteAddr(), c.LocalAddr())
+		}
+	}
+}
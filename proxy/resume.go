@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	resumePrefix         string        = "EZP-RESUME "          // Start of a resume line, followed by the token & "\r\n" or "\n"
+	joinPrefix           string        = "EZP-JOIN "            // Start of a join line, followed by the token, the role & "\r\n" or "\n"
+	resumeLineMaxLength  int           = 128                    // Longest resume or join line, including the newline
+	defaultResumeTimeout time.Duration = time.Millisecond * 200 // Default time a client has to start sending, clients that wait for the server first are delayed by this
+)
+
+// Returns the resume or join prefix read starts with, or "" if it doesn't start with either.
+// partial is true if read could still become one of them.
+func matchResumePrefix(read []byte) (prefix string, partial bool) {
+	for _, p := range []string{resumePrefix, joinPrefix} {
+		if strings.HasPrefix(string(read), p) {
+			return p, false
+		}
+		if strings.HasPrefix(p, string(read)) {
+			partial = true
+		}
+	}
+	return "", partial
+}
+
+// Reads a resume line ("EZP-RESUME <token>\r\n") or join line ("EZP-JOIN <token> <writer|observer>\r\n") from the start of c,
+// one byte at a time so nothing past it is read. Resume lines replace the client, join lines add one with the given role.
+// If c didn't start with one within timeout token is empty & read is every byte read, which must be replayed to the server.
+func readResumeToken(c net.Conn, timeout time.Duration) (token string, role handler.ClientRole, read []byte, err error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	b := []byte{0}
+	prefix := ""
+	for !bytes.HasSuffix(read, []byte("\n")) {
+		if len(read) >= resumeLineMaxLength {
+			return "", 0, nil, errors.New("resume line too long")
+		}
+		if _, err := io.ReadFull(c, b); err != nil {
+			if isTimeoutError(err) && prefix == "" {
+				// Waiting for the server, or the first packet was shorter than the prefix
+				return "", 0, read, nil
+			}
+			return "", 0, nil, err
+		}
+		read = append(read, b[0])
+		if prefix == "" {
+			var partial bool
+			if prefix, partial = matchResumePrefix(read); prefix == "" && !partial {
+				return "", 0, read, nil
+			}
+		}
+	}
+	fields := strings.Fields(string(read[len(prefix):]))
+	if prefix == resumePrefix {
+		if len(fields) != 1 {
+			return "", 0, nil, errors.New("resume line needs exactly one token")
+		}
+		return fields[0], handler.ClientRoleReplace, nil, nil
+	}
+	if len(fields) != 2 {
+		return "", 0, nil, errors.New("join line needs a token & a role")
+	}
+	role, err = handler.ParseClientRole(fields[1])
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if role == handler.ClientRoleReplace {
+		return "", 0, nil, fmt.Errorf("can't join with the %s role", role)
+	}
+	return fields[0], role, nil, nil
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"ezproxy/handler"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readResumeToken, Ensure resume & join lines are read & anything else is left for the server
+//
+// Expect: The token & role for valid lines, invalid lines fail, other data is returned as read after at most the timeout
+func TestReadResumeToken(t *testing.T) {
+	for _, v := range []struct {
+		name  string
+		data  string
+		token string
+		role  handler.ClientRole
+		read  string // Bytes returned to be replayed
+		fails bool
+	}{
+		{"resume", "EZP-RESUME abc\r\nrest", "abc", handler.ClientRoleReplace, "", false},
+		{"resume LF", "EZP-RESUME abc\n", "abc", handler.ClientRoleReplace, "", false},
+		{"join writer", "EZP-JOIN abc writer\r\n", "abc", handler.ClientRoleWriter, "", false},
+		{"join observer", "EZP-JOIN abc observer\n", "abc", handler.ClientRoleObserver, "", false},
+		{"join replace", "EZP-JOIN abc replace\n", "", 0, "", true},
+		{"join unknown role", "EZP-JOIN abc owner\n", "", 0, "", true},
+		{"join no role", "EZP-JOIN abc\n", "", 0, "", true},
+		{"resume no token", "EZP-RESUME \n", "", 0, "", true},
+		{"resume two tokens", "EZP-RESUME abc def\n", "", 0, "", true},
+		{"too long", "EZP-RESUME " + strings.Repeat("a", resumeLineMaxLength), "", 0, "", true},
+		{"other data", "GET / HTTP/1.1\r\n", "", 0, "G", false},
+		{"close to a prefix", "EZP-RESUMED", "", 0, "EZP-RESUMED", false},
+		{"partial prefix", "EZP-", "", 0, "EZP-", false},
+		{"nothing sent", "", "", 0, "", false},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			// Left open so short data has to wait for the timeout
+			go client.Write([]byte(v.data))
+			start := time.Now()
+			token, role, read, err := readResumeToken(server, defaultResumeTimeout)
+			if took := time.Since(start); took > defaultResumeTimeout+time.Millisecond*500 {
+				t.Errorf("Took %v, expected at most %v", took, defaultResumeTimeout)
+			}
+			if v.fails {
+				if err == nil {
+					t.Errorf("Expected a error, got %q %v", token, role)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to read resume token: %v", err)
+			}
+			if token != v.token || role != v.role || string(read) != v.read {
+				t.Errorf("Expected %q %v %q, got %q %v %q", v.token, v.role, v.read, token, role, read)
+			}
+		})
+	}
+}
+
+// Starts a spawner with resume lines allowed, the first client has a proxy. Returns the spawner, its address, the client & its proxy
+func startResumeSpawner(t *testing.T, tag string) (*handler.ProxySpawner, string, net.Conn, handler.IProxyContainer) {
+	t.Helper()
+	server := startTagServer(t, "tcp", "127.0.0.1:0", tag)
+	ps, pAddr := startSpawner(t, "tcp", server, NewTcpListener(ListenerOptions{Resume: true}))
+	// One byte, bytes read looking for a resume line are replayed in their own write so more could reach the server split
+	first := dialResume(t, pAddr.String(), "0")
+	if got, err := readWithin(first, len(tag)+1, time.Second); err != nil || string(got) != tag+"0" {
+		t.Fatalf("Expected %s0, got %q %v", tag, got, err)
+	}
+	proxies := ps.GetAllProxies()
+	if len(proxies) != 1 {
+		t.Fatalf("Expected 1 proxy, got %d", len(proxies))
+	}
+	return ps, pAddr.String(), first, proxies[0]
+}
+
+// Starts a TCP server that sends greeting as soon as a connection is accepted
+func startGreetServer(t *testing.T, greeting string) net.Addr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+			c.Write([]byte(greeting))
+		}
+	}()
+	return l.Addr()
+}
+
+// Dials addr & sends line
+func dialResume(t *testing.T, addr string, line string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.Write([]byte(line))
+	return c
+}
+
+// Checks c gets exactly expect
+func expectRead(t *testing.T, c net.Conn, expect string) {
+	t.Helper()
+	if got, err := readWithin(c, len(expect), time.Second); err != nil || string(got) != expect {
+		t.Errorf("Expected %q, got %q %v", expect, got, err)
+	}
+}
+
+// Checks c was closed without getting anything
+func expectClosed(t *testing.T, c net.Conn) {
+	t.Helper()
+	if got, err := readWithin(c, 1, time.Second*2); err == nil || isTimeoutError(err) {
+		t.Errorf("Expected the connection to be closed, got %q %v", got, err)
+	}
+}
+
+// NewTcpListener with Resume, Ensure a resume line with the proxies token takes over the proxy
+//
+// Expect: The new client keeps the proxies ID & server connection, the old client is closed
+func TestResumeListenerResume(t *testing.T) {
+	ps, addr, first, pc := startResumeSpawner(t, "s:")
+	token := pc.(handler.IResumable).GetResumeToken()
+	second := dialResume(t, addr, "EZP-RESUME "+token+"\r\nsecond")
+	expectRead(t, second, "s:second")
+	expectClosed(t, first)
+	if proxies := ps.GetAllProxies(); len(proxies) != 1 || proxies[0].GetId() != pc.GetId() {
+		t.Errorf("Expected only proxy %d, got %d proxies", pc.GetId(), len(proxies))
+	}
+}
+
+// NewTcpListener with Resume, Ensure tokens that don't match a running proxy are rejected
+//
+// Expect: The connection is closed & no proxy is made for wrong tokens, tokens sharing all but the last byte
+// (compared in constant time by ResumeClient) & tokens of a closed proxy. The first client keeps its proxy
+func TestResumeListenerWrongToken(t *testing.T) {
+	ps, addr, first, pc := startResumeSpawner(t, "s:")
+	token := pc.(handler.IResumable).GetResumeToken()
+	for _, wrong := range []string{"wrong", token[:len(token)-1], token[:len(token)-1] + "!", token + "a"} {
+		expectClosed(t, dialResume(t, addr, "EZP-RESUME "+wrong+"\n"))
+		expectClosed(t, dialResume(t, addr, "EZP-JOIN "+wrong+" observer\n"))
+	}
+	if proxies := ps.GetAllProxies(); len(proxies) != 1 {
+		t.Errorf("Expected 1 proxy, got %d", len(proxies))
+	}
+	first.Write([]byte("again"))
+	expectRead(t, first, "s:again")
+	// Tokens expire with their proxy
+	if err := ps.CloseProxy(pc.GetId()); err != nil {
+		t.Fatalf("Failed to close proxy: %v", err)
+	}
+	for end := time.Now().Add(time.Second * 2); len(ps.GetAllProxies()) != 0 && time.Now().Before(end); {
+		time.Sleep(time.Millisecond * 10)
+	}
+	expectClosed(t, dialResume(t, addr, "EZP-RESUME "+token+"\n"))
+	if proxies := ps.GetAllProxies(); len(proxies) != 0 {
+		t.Errorf("Expected no proxies after resuming a closed proxy, got %d", len(proxies))
+	}
+}
+
+// NewTcpListener with Resume, Ensure join lines add a client with the role asked for
+//
+// Expect: Observers & writers get all server data, only data from writers reaches the server
+func TestResumeListenerJoin(t *testing.T) {
+	_, addr, first, pc := startResumeSpawner(t, "s:")
+	token := pc.(handler.IResumable).GetResumeToken()
+	observer := dialResume(t, addr, "EZP-JOIN "+token+" observer\n")
+	writer := dialResume(t, addr, "EZP-JOIN "+token+" writer\n")
+	// The join lines are read by the listener goroutines, wait until both clients are added
+	time.Sleep(time.Millisecond * 100)
+	first.Write([]byte("1"))
+	for _, c := range []net.Conn{first, observer, writer} {
+		expectRead(t, c, "s:1")
+	}
+	writer.Write([]byte("2"))
+	for _, c := range []net.Conn{first, observer, writer} {
+		expectRead(t, c, "s:2")
+	}
+	observer.Write([]byte("3"))
+	if got, err := readWithin(first, 1, time.Millisecond*200); err == nil {
+		t.Errorf("Observer data reached the server, got %q", got)
+	}
+}
+
+// NewTcpListener with Resume, Ensure clients that don't send a resume line in time are proxied with their bytes untouched
+//
+// Expect: Bytes sent before & after ResumeTimeout reach the server unchanged, a resume line after it isn't read as one
+func TestResumeListenerNoPreamble(t *testing.T) {
+	server := startTagServer(t, "tcp", "127.0.0.1:0", "")
+	ps, pAddr := startSpawner(t, "tcp", server, NewTcpListener(ListenerOptions{Resume: true, ResumeTimeout: time.Millisecond * 100}))
+	c := dialResume(t, pAddr.String(), "EZP-")
+	time.Sleep(time.Millisecond * 300)
+	expectRead(t, c, "EZP-")
+	c.Write([]byte("RESUME abc\n"))
+	expectRead(t, c, "RESUME abc\n")
+	late := dialResume(t, pAddr.String(), "")
+	time.Sleep(time.Millisecond * 300)
+	late.Write([]byte("EZP-RESUME abc\n"))
+	expectRead(t, late, "EZP-RESUME abc\n")
+	if proxies := ps.GetAllProxies(); len(proxies) != 2 {
+		t.Errorf("Expected 2 proxies, got %d", len(proxies))
+	}
+}
+
+// NewTcpListener with Resume, Ensure clients waiting for the server first are only delayed by ResumeTimeout
+//
+// Expect: The servers greeting arrives after about ResumeTimeout with the default & a longer timeout
+func TestResumeListenerServerFirst(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Millisecond * 500} {
+		server := startGreetServer(t, "hello")
+		_, pAddr := startSpawner(t, "tcp", server, NewTcpListener(ListenerOptions{Resume: true, ResumeTimeout: timeout}))
+		expect := (&ListenerOptions{ResumeTimeout: timeout}).resumeTimeout()
+		start := time.Now()
+		c := dialResume(t, pAddr.String(), "")
+		expectRead(t, c, "hello")
+		if took := time.Since(start); took < expect || took > expect+time.Millisecond*300 {
+			t.Errorf("Timeout %v: Greeting took %v, expected about %v", timeout, took, expect)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"ezproxy/handler"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// Returned from GetConfigForClient once we have the ClientHello, stops the handshake.
+var errGotClientHello = errors.New("got client hello")
+
+// Connection that can only be read from, used to run the TLS server just far enough to parse the ClientHello.
+type readOnlyConn struct {
+	r    io.Reader
+	conn net.Conn
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c *readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *readOnlyConn) Close() error                       { return nil }
+func (c *readOnlyConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *readOnlyConn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Reads the ClientHello from c, returning the SNI the client asked for and every byte read so they can be replayed to the server.
+// Nothing is written to c.
+func peekClientHello(c net.Conn) (sni string, read []byte, err error) {
+	buf := &bytes.Buffer{}
+	var hello *tls.ClientHelloInfo
+	err = tls.Server(&readOnlyConn{r: io.TeeReader(c, buf), conn: c}, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = chi
+			return nil, errGotClientHello
+		},
+	}).Handshake()
+	if hello == nil {
+		return "", buf.Bytes(), err
+	}
+	return hello.ServerName, buf.Bytes(), nil
+}
+
+// Finds the server for sni, exact matches are checked first then wildcards ("*.example.com"), nil if nothing matches.
+func matchSniRoute(routes map[string]net.Addr, sni string) net.Addr {
+	sni = strings.ToLower(sni)
+	if addr, found := routes[sni]; found {
+		return addr
+	}
+	for dot := strings.IndexByte(sni, '.'); dot != -1; dot = strings.IndexByte(sni, '.') {
+		sni = sni[dot+1:]
+		if addr, found := routes["*."+sni]; found {
+			return addr
+		}
+	}
+	return nil
+}
+
+// Reads the ClientHello, picks a server & adds the proxy. defaultUpstream is what the spawner picked for defaultAddr.
+func addSniConnection(ps handler.IConnectionAdder, routes map[string]net.Addr, c net.Conn, defaultAddr net.Addr, defaultUpstream net.Addr) {
+	logger := slog.Default()
+	c.SetReadDeadline(time.Now().Add(tlsHandshakeTimeout))
+	sni, read, err := peekClientHello(c)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		logger.Debug("Failed to read ClientHello", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		return
+	}
+	sAddr, upstream := defaultAddr, defaultUpstream
+	if addr := matchSniRoute(routes, sni); addr != nil {
+		// Routes aren't upstreams
+		sAddr, upstream = addr, nil
+	}
+	s, err := net.Dial(sAddr.Network(), sAddr.String())
+	if err != nil {
+		logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String(), "Sni", sni)
+		c.Close()
+		return
+	}
+	px := newTcpProxy(newPrefixConn(c, read), s)
+	px.picked = upstream
+	px.metadata = map[string]string{
+		"Sni": sni,
+	}
+	logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", s.RemoteAddr().String(), "Sni", sni)
+	if _, err := ps.AddConnection(px); err != nil {
+		logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		s.Close()
+	}
+}
+
+// Creates a listener that routes TLS connections by the SNI in the ClientHello without decrypting them.
+// routes maps a server name, or a wildcard like "*.example.com", to the server to use. Connections that don't
+// match a route go to the spawners server address.
+func NewSniListener(routes map[string]net.Addr) handler.IProxyListener {
+	lower := make(map[string]net.Addr, len(routes))
+	for k, v := range routes {
+		lower[strings.ToLower(k)] = v
+	}
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		acceptTcp(ctx, cancel, ps, func(c net.Conn, sAddr net.Addr, upstream net.Addr) {
+			// Don't hold up the listener waiting for a ClientHello
+			go addSniConnection(ps, lower, c, sAddr, upstream)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// matchSniRoute, Ensure exact names win over wildcards & names are matched without case
+//
+// Expect: The matching route, nil without one
+func TestMatchSniRoute(t *testing.T) {
+	exact, wildcard := mustTcpAddr(t, "127.0.0.1:1"), mustTcpAddr(t, "127.0.0.1:2")
+	routes := map[string]net.Addr{"a.example.com": exact, "*.example.com": wildcard}
+	for sni, expect := range map[string]net.Addr{
+		"a.example.com": exact,
+		"A.Example.Com": exact,
+		"b.example.com": wildcard,
+		"example.com":   nil,
+		"a.b.other.com": nil,
+		"":              nil,
+	} {
+		if got := matchSniRoute(routes, sni); got != expect {
+			t.Errorf("%s: Expected %v, got %v", sni, expect, got)
+		}
+	}
+}
+
+// NewSniListener, Ensure connections are routed by SNI without being decrypted
+//
+// Expect: Matching names reach their route, others reach the spawners server, the Sni is in the metadata
+func TestSniListener(t *testing.T) {
+	routed, fallback := startTlsTagServer(t, "routed:"), startTlsTagServer(t, "default:")
+	ps, pAddr := startSpawner(t, "tcp", fallback, NewSniListener(map[string]net.Addr{"*.Routed.test": routed}))
+	tlsExchange(t, pAddr.String(), &tls.Config{ServerName: "a.routed.test", InsecureSkipVerify: true}, "routed:ping")
+	tlsExchange(t, pAddr.String(), &tls.Config{ServerName: "other.test", InsecureSkipVerify: true}, "default:ping")
+	found := map[string]bool{}
+	for _, v := range ps.GetAllProxies() {
+		found[getMetadata(v)["Sni"]] = true
+	}
+	if !found["a.routed.test"] || !found["other.test"] {
+		t.Errorf("Expected the Sni in the metadata, got %v", found)
+	}
+}
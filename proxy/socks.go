@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion     byte = 5
+	socksAuthVersion byte = 1
+
+	socksMethodNone     byte = 0x00
+	socksMethodPassword byte = 0x02
+	socksMethodNoAccept byte = 0xff
+
+	socksCmdConnect      byte = 1
+	socksCmdUdpAssociate byte = 3
+
+	socksAtypIpv4   byte = 1
+	socksAtypDomain byte = 3
+	socksAtypIpv6   byte = 4
+
+	socksRepOk                  byte = 0
+	socksRepFailure             byte = 1
+	socksRepHostUnreachable     byte = 4
+	socksRepRefused             byte = 5
+	socksRepCmdNotSupported     byte = 7
+	socksRepAddrTypeUnsupported byte = 8
+
+	socksHandshakeTimeout time.Duration = time.Second * 10 // Time a client has to finish the SOCKS handshake
+	socksDialTimeout      time.Duration = time.Second * 10 // Time to connect to a destination
+)
+
+// SOCKS5 settings for a Socks5Listener
+type Socks5Config struct {
+	Username string // If set clients must authenticate with Username & Password
+	Password string
+}
+
+// Reads a SOCKS address of type atyp and the port after it
+func readSocksAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case socksAtypIpv4, socksAtypIpv6:
+		size := net.IPv4len
+		if atyp == socksAtypIpv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		size := []byte{0}
+		if _, err := io.ReadFull(r, size); err != nil {
+			return "", err
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unknown address type %d", atyp)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// Encodes addr as ATYP, ADDR, PORT
+func socksAddrBytes(addr net.Addr) []byte {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []byte{socksAtypIpv4, 0, 0, 0, 0, 0, 0}
+	}
+	port, _ := strconv.Atoi(portStr)
+	var out []byte
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		out = append([]byte{socksAtypIpv4}, ip4...)
+	} else if ip != nil {
+		out = append([]byte{socksAtypIpv6}, ip.To16()...)
+	} else {
+		out = append([]byte{socksAtypDomain, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(out, uint16(port))
+}
+
+// Sends a reply to a request, bound may be nil
+func socksReply(c net.Conn, rep byte, bound net.Addr) error {
+	msg := []byte{socksVersion, rep, 0}
+	if bound == nil {
+		msg = append(msg, socksAtypIpv4, 0, 0, 0, 0, 0, 0)
+	} else {
+		msg = append(msg, socksAddrBytes(bound)...)
+	}
+	_, err := c.Write(msg)
+	return err
+}
+
+// Gets the reply code for a failed dial
+func socksDialError(err error) byte {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || isTimeoutError(err) {
+		return socksRepHostUnreachable
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return socksRepRefused
+	}
+	return socksRepFailure
+}
+
+// Negotiates the auth method and authenticates the client
+func socksAuthenticate(c net.Conn, cfg *Socks5Config) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return err
+	}
+	want := socksMethodNone
+	if cfg.Username != "" {
+		want = socksMethodPassword
+	}
+	found := false
+	for _, m := range methods {
+		if m == want {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.Write([]byte{socksVersion, socksMethodNoAccept})
+		return errors.New("client doesn't support the required auth method")
+	}
+	if _, err := c.Write([]byte{socksVersion, want}); err != nil {
+		return err
+	}
+	if want == socksMethodNone {
+		return nil
+	}
+	// RFC 1929
+	ver := make([]byte, 2)
+	if _, err := io.ReadFull(c, ver); err != nil {
+		return err
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	plen := []byte{0}
+	if _, err := io.ReadFull(c, plen); err != nil {
+		return err
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+	userOk := subtle.ConstantTimeCompare(user, []byte(cfg.Username)) == 1
+	passOk := subtle.ConstantTimeCompare(pass, []byte(cfg.Password)) == 1
+	if ver[0] != socksAuthVersion || !userOk || !passOk {
+		c.Write([]byte{socksAuthVersion, 1})
+		return errors.New("invalid username or password")
+	}
+	_, err := c.Write([]byte{socksAuthVersion, 0})
+	return err
+}
+
+// Handles the SOCKS handshake and adds the proxy for the request.
+func addSocksConnection(ctx context.Context, ps handler.IConnectionAdder, cfg *Socks5Config, c net.Conn) {
+	logger := slog.Default()
+	c.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	if err := socksAuthenticate(c, cfg); err != nil {
+		logger.Debug("SOCKS authentication failed", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		return
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(c, req); err != nil {
+		logger.Debug("Failed to read SOCKS request", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		return
+	}
+	if req[0] != socksVersion {
+		logger.Debug("Unsupported SOCKS request version", "Version", req[0], "ClientAddr", c.RemoteAddr().String())
+		socksReply(c, socksRepFailure, nil)
+		c.Close()
+		return
+	}
+	dest, err := readSocksAddr(c, req[3])
+	if err != nil {
+		logger.Debug("Failed to read SOCKS destination", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		socksReply(c, socksRepAddrTypeUnsupported, nil)
+		c.Close()
+		return
+	}
+	switch req[1] {
+	case socksCmdConnect:
+		s, err := net.DialTimeout("tcp", dest, socksDialTimeout)
+		if err != nil {
+			logger.Debug("Failed to connect to SOCKS destination", "Error", err.Error(), "Destination", dest)
+			socksReply(c, socksDialError(err), nil)
+			c.Close()
+			return
+		}
+		if err := socksReply(c, socksRepOk, s.LocalAddr()); err != nil {
+			c.Close()
+			s.Close()
+			return
+		}
+		c.SetDeadline(time.Time{})
+		px := newTcpProxy(c, s)
+		px.metadata = map[string]string{
+			"Socks":       "connect",
+			"Destination": dest,
+		}
+		logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", s.RemoteAddr().String(), "Destination", dest)
+		if _, err := ps.AddConnection(px); err != nil {
+			logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+			c.Close()
+			s.Close()
+		}
+	case socksCmdUdpAssociate:
+		// Relay on the same IP the client connected to
+		local, ok := c.LocalAddr().(*net.TCPAddr)
+		if !ok {
+			// Clients on unix sockets have no address to relay to
+			socksReply(c, socksRepCmdNotSupported, nil)
+			c.Close()
+			return
+		}
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+		if err != nil {
+			logger.Warn("Failed to open SOCKS UDP relay", "Error", err.Error())
+			socksReply(c, socksRepFailure, nil)
+			c.Close()
+			return
+		}
+		if err := socksReply(c, socksRepOk, relay.LocalAddr()); err != nil {
+			c.Close()
+			relay.Close()
+			return
+		}
+		c.SetDeadline(time.Time{})
+		go socksUdpRelay(ctx, ps, c, relay)
+	default:
+		socksReply(c, socksRepCmdNotSupported, nil)
+		c.Close()
+	}
+}
+
+// Runs a UDP association, each destination the client sends to is a separate UdpProxy.
+// The association ends when the TCP connection closes.
+func socksUdpRelay(ctx context.Context, ps handler.IConnectionAdder, control net.Conn, relay *net.UDPConn) {
+	logger := slog.Default()
+	defer relay.Close()
+	clientIp := control.RemoteAddr().(*net.TCPAddr).IP
+	// The association lives as long as the control connection
+	aCtx, aCancel := context.WithCancel(ctx)
+	defer aCancel()
+	go func() {
+		defer control.Close()
+		io.Copy(io.Discard, control)
+		aCancel()
+	}()
+	go func() {
+		<-aCtx.Done()
+		control.Close()
+	}()
+	sessions := make(map[string]*UdpProxy)
+	defer func() {
+		for _, v := range sessions {
+			if v.isAlive() {
+				v.ctxCancel(handler.ErrProxyClosedOk)
+			}
+		}
+	}()
+	// Reused for every read, datagrams are copied out of it. One extra byte to tell if a datagram was too large
+	buffer := make([]byte, defaultMaxDatagramSize+1)
+	for aCtx.Err() == nil {
+		for k, v := range sessions {
+			if !v.isAlive() {
+				delete(sessions, k)
+			}
+		}
+		relay.SetReadDeadline(time.Now().Add(time.Second * 2))
+		n, from, err := relay.ReadFromUDP(buffer)
+		if err != nil {
+			if isTimeoutError(err) {
+				continue
+			}
+			logger.Debug("Failed to read from SOCKS relay", "Error", err.Error())
+			return
+		}
+		if !from.IP.Equal(clientIp) {
+			logger.Debug("Ignoring datagram from unknown sender on SOCKS relay", "From", from.String())
+			continue
+		}
+		if n > defaultMaxDatagramSize {
+			logger.Debug("Dropping datagram larger than the max size on SOCKS relay", "From", from.String(), "MaxSize", defaultMaxDatagramSize)
+			continue
+		}
+		// RSV, FRAG, ATYP
+		if n < 4 || buffer[2] != 0 {
+			// Fragmentation isn't supported
+			continue
+		}
+		r := bytes.NewReader(buffer[4:n])
+		dest, err := readSocksAddr(r, buffer[3])
+		if err != nil {
+			logger.Debug("Invalid SOCKS UDP header", "Error", err.Error(), "From", from.String())
+			continue
+		}
+		payload := buffer[n-r.Len() : n]
+		key := from.String() + "|" + dest
+		if s, found := sessions[key]; found && s.isAlive() {
+			data := pooledCopy(payload)
+			if !s.queue(s.clientPkts, data) {
+				logger.Debug("Session queue full, dropping datagram", "Client", from.String(), "Destination", dest)
+				packetBuffers.Put(data)
+			}
+			continue
+		}
+		sAddr, err := net.ResolveUDPAddr("udp", dest)
+		if err != nil {
+			logger.Debug("Failed to resolve SOCKS UDP destination", "Error", err.Error(), "Destination", dest)
+			continue
+		}
+		upstream, err := net.DialUDP("udp", nil, sAddr)
+		if err != nil {
+			logger.Debug("Failed to create new connection to SOCKS UDP destination", "Error", err.Error(), "Destination", dest)
+			continue
+		}
+		up := newUdpProxy(from, relay, sAddr, upstream, pooledCopy(payload))
+		// Replies are from the server the session is on
+		up.headers = func(server net.Addr) ([]byte, []byte, error) {
+			return append([]byte{0, 0, 0}, socksAddrBytes(server)...), nil, nil
+		}
+		up.clientHeader, up.serverHeader, _ = up.buildHeaders(sAddr)
+		up.metadata = map[string]string{
+			"Socks":       "udp",
+			"Destination": dest,
+		}
+		if _, err := ps.AddConnection(up); err != nil {
+			logger.Debug("Failed to add new connection", "Error", err.Error(), "From", from.String(), "Destination", dest)
+			upstream.Close()
+			continue
+		}
+		sessions[key] = up
+	}
+}
+
+// Creates a SOCKS5 listener, the server each client connects to comes from its request instead of the spawners server address.
+// CONNECT and UDP ASSOCIATE are supported, if cfg.Username is set clients must authenticate.
+func NewSocks5Listener(cfg Socks5Config) handler.IProxyListener {
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		acceptTcp(ctx, cancel, ps, func(c net.Conn, _ net.Addr, _ net.Addr) {
+			go addSocksConnection(ctx, ps, &cfg, c)
+		})
+	}
+}
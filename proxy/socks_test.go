@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Starts addSocksConnection on a new connection, returns the client side
+func startSocks(t *testing.T, ca *testAdder, cfg Socks5Config) net.Conn {
+	t.Helper()
+	client, server := tcpPair(t)
+	go addSocksConnection(ca.ctx, ca, &cfg, server)
+	return client
+}
+
+// Sends the greeting with methods & checks the chosen method
+func socksGreet(t *testing.T, c net.Conn, expect byte, methods ...byte) {
+	t.Helper()
+	c.Write(append([]byte{socksVersion, byte(len(methods))}, methods...))
+	got, err := readWithin(c, 2, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read method: %v", err)
+	}
+	if !bytes.Equal(got, []byte{socksVersion, expect}) {
+		t.Fatalf("Expected method %d, got %v", expect, got)
+	}
+}
+
+// Sends a request for addr (ATYP, ADDR, PORT) & reads the reply code and bound address
+func socksRequest(t *testing.T, c net.Conn, cmd byte, addr []byte) (byte, string) {
+	t.Helper()
+	c.Write(append([]byte{socksVersion, cmd, 0}, addr...))
+	header, err := readWithin(c, 4, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	bound, err := readSocksAddr(c, header[3])
+	if err != nil {
+		t.Fatalf("Failed to read bound address: %v", err)
+	}
+	return header[1], bound
+}
+
+func socksDomainBytes(host string, port int) []byte {
+	return binary.BigEndian.AppendUint16(append([]byte{socksAtypDomain, byte(len(host))}, host...), uint16(port))
+}
+
+// socksAuthenticate, Ensure the auth method is negotiated & passwords are checked
+//
+// Expect: No auth & password auth succeed, a bad password gets status 1, a missing method gets 0xff & the connection is closed
+func TestSocksAuthenticate(t *testing.T) {
+	withPassword := Socks5Config{Username: "user", Password: "pass"}
+	for _, v := range []struct {
+		name    string
+		cfg     Socks5Config
+		methods []byte
+		method  byte
+		auth    []byte // Sent after the method if not nil
+		status  byte
+	}{
+		{"no auth", Socks5Config{}, []byte{socksMethodNone}, socksMethodNone, nil, 0},
+		{"password", withPassword, []byte{socksMethodNone, socksMethodPassword}, socksMethodPassword, []byte("\x01\x04user\x04pass"), 0},
+		{"bad password", withPassword, []byte{socksMethodPassword}, socksMethodPassword, []byte("\x01\x04user\x04nope"), 1},
+		{"bad username", withPassword, []byte{socksMethodPassword}, socksMethodPassword, []byte("\x01\x04resu\x04pass"), 1},
+		{"password not offered", withPassword, []byte{socksMethodNone}, socksMethodNoAccept, nil, 0},
+		{"no auth not offered", Socks5Config{}, []byte{socksMethodPassword}, socksMethodNoAccept, nil, 0},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			client, server := tcpPair(t)
+			result := make(chan error, 1)
+			go func() { result <- socksAuthenticate(server, &v.cfg) }()
+			socksGreet(t, client, v.method, v.methods...)
+			if v.auth != nil {
+				client.Write(v.auth)
+				got, err := readWithin(client, 2, time.Second)
+				if err != nil || !bytes.Equal(got, []byte{socksAuthVersion, v.status}) {
+					t.Fatalf("Expected status %d, got %v %v", v.status, got, err)
+				}
+			}
+			err := <-result
+			ok := v.method != socksMethodNoAccept && v.status == 0
+			if ok && err != nil {
+				t.Errorf("Failed to authenticate: %v", err)
+			} else if !ok && err == nil {
+				t.Errorf("Expected a error")
+			}
+		})
+	}
+}
+
+// addSocksConnection, Ensure CONNECT requests are proxied to IPv4, IPv6 & domain destinations
+//
+// Expect: Reply 0, the proxy has the destination in its metadata & data reaches the destination
+func TestSocksConnect(t *testing.T) {
+	v4 := startTagServer(t, "tcp", "127.0.0.1:0", "ok:").(*net.TCPAddr)
+	for _, v := range []struct {
+		name string
+		addr func(t *testing.T) ([]byte, string)
+	}{
+		{"IPv4", func(t *testing.T) ([]byte, string) {
+			return socksAddrBytes(v4), v4.String()
+		}},
+		{"IPv6", func(t *testing.T) ([]byte, string) {
+			l, err := net.Listen("tcp", "[::1]:0")
+			if err != nil {
+				t.Skipf("IPv6 isn't available: %v", err)
+			}
+			l.Close()
+			addr := startTagServer(t, "tcp", "[::1]:0", "ok:")
+			return socksAddrBytes(addr), addr.String()
+		}},
+		{"domain", func(t *testing.T) ([]byte, string) {
+			return socksDomainBytes("localhost", v4.Port), net.JoinHostPort("localhost", strconv.Itoa(v4.Port))
+		}},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			addr, dest := v.addr(t)
+			ca := newTestAdder(t)
+			client := startSocks(t, ca, Socks5Config{})
+			socksGreet(t, client, socksMethodNone, socksMethodNone)
+			if rep, _ := socksRequest(t, client, socksCmdConnect, addr); rep != socksRepOk {
+				t.Fatalf("Expected reply %d, got %d", socksRepOk, rep)
+			}
+			px := ca.waitProxies(t, 1)[0]
+			if md := getMetadata(px); md["Socks"] != "connect" || md["Destination"] != dest {
+				t.Errorf("Expected the destination %s in the metadata, got %v", dest, md)
+			}
+			client.Write([]byte("ping"))
+			if got, err := readWithin(client, 7, time.Second); err != nil || string(got) != "ok:ping" {
+				t.Errorf("Expected the destinations reply, got %q %v", got, err)
+			}
+		})
+	}
+}
+
+// addSocksConnection, Ensure invalid requests get a error reply
+//
+// Expect: The matching reply code, no proxy is added & the connection is closed
+func TestSocksRequestInvalid(t *testing.T) {
+	closed := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: freeAddr(t).Port}
+	for _, v := range []struct {
+		name    string
+		request []byte
+		rep     byte
+	}{
+		{"unsupported command", append([]byte{socksVersion, 2, 0}, socksAddrBytes(closed)...), socksRepCmdNotSupported},
+		{"bad version", append([]byte{4, socksCmdConnect, 0}, socksAddrBytes(closed)...), socksRepFailure},
+		{"unknown address type", []byte{socksVersion, socksCmdConnect, 0, 2, 0, 0}, socksRepAddrTypeUnsupported},
+		{"refused", append([]byte{socksVersion, socksCmdConnect, 0}, socksAddrBytes(closed)...), socksRepRefused},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			ca := newTestAdder(t)
+			client := startSocks(t, ca, Socks5Config{})
+			socksGreet(t, client, socksMethodNone, socksMethodNone)
+			client.Write(v.request)
+			got, err := readWithin(client, 10, time.Second)
+			if err != nil || got[0] != socksVersion || got[1] != v.rep {
+				t.Fatalf("Expected reply %d, got %v %v", v.rep, got, err)
+			}
+			if rest, err := readWithin(client, 1, time.Second); err == nil {
+				t.Errorf("Expected the connection to be closed, got %v", rest)
+			}
+			ca.lock.Lock()
+			defer ca.lock.Unlock()
+			if len(ca.proxies) != 0 {
+				t.Errorf("Expected no proxies, got %d", len(ca.proxies))
+			}
+		})
+	}
+}
+
+// socksUdpRelay, Ensure datagrams are demuxed into a session per client address & destination
+//
+// Expect: Datagrams from the same address to the same destination share a session, replies have the SOCKS header,
+// closing the control connection closes the sessions
+func TestSocksUdpAssociate(t *testing.T) {
+	destA := startTagServer(t, "udp", "127.0.0.1:0", "a:")
+	destB := startTagServer(t, "udp", "127.0.0.1:0", "b:")
+	ca := newTestAdder(t)
+	control := startSocks(t, ca, Socks5Config{})
+	socksGreet(t, control, socksMethodNone, socksMethodNone)
+	rep, bound := socksRequest(t, control, socksCmdUdpAssociate, []byte{socksAtypIpv4, 0, 0, 0, 0, 0, 0})
+	if rep != socksRepOk {
+		t.Fatalf("Expected reply %d, got %d", socksRepOk, rep)
+	}
+	relay, err := net.ResolveUDPAddr("udp", bound)
+	if err != nil {
+		t.Fatalf("Invalid relay address %s: %v", bound, err)
+	}
+	dial := func() *net.UDPConn {
+		c, err := net.DialUDP("udp", nil, relay)
+		if err != nil {
+			t.Fatalf("Failed to dial relay: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	exchange := func(c *net.UDPConn, dest net.Addr, data string, expect string) {
+		t.Helper()
+		header := append([]byte{0, 0, 0}, socksAddrBytes(dest)...)
+		c.Write(append(append([]byte{}, header...), data...))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		buffer := make([]byte, 1024)
+		n, err := c.Read(buffer)
+		if err != nil {
+			t.Fatalf("Failed to read reply for %s: %v", data, err)
+		}
+		if expect := append(header, expect...); !bytes.Equal(buffer[:n], expect) {
+			t.Fatalf("Expected %q, got %q", expect, buffer[:n])
+		}
+	}
+	first, second := dial(), dial()
+	exchange(first, destA, "1", "a:1")
+	exchange(first, destA, "2", "a:2")
+	exchange(first, destB, "3", "b:3")
+	exchange(second, destA, "4", "a:4")
+	proxies := ca.waitProxies(t, 3)
+	if len(proxies) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(proxies))
+	}
+	for k, expect := range []struct {
+		client net.Addr
+		dest   net.Addr
+	}{{first.LocalAddr(), destA}, {first.LocalAddr(), destB}, {second.LocalAddr(), destA}} {
+		px := proxies[k]
+		if px.GetClientAddr().String() != expect.client.String() || getMetadata(px)["Destination"] != expect.dest.String() {
+			t.Errorf("Session %d: Expected %v to %v, got %v to %v", k, expect.client, expect.dest, px.GetClientAddr(), getMetadata(px)["Destination"])
+		}
+	}
+	control.Close()
+	for k, px := range proxies {
+		u := px.(*UdpProxy)
+		select {
+		case <-u.ctx.Done():
+		case <-time.After(time.Second * 3):
+			t.Errorf("Session %d wasn't closed with the association", k)
+		}
+	}
+}
+
+// NewSocks5Listener, Ensure the listener accepts SOCKS clients
+//
+// Expect: A CONNECT through the listener reaches the destination
+func TestSocks5Listener(t *testing.T) {
+	dest := startTagServer(t, "tcp", "127.0.0.1:0", "s:")
+	_, pAddr := startSpawner(t, "tcp", dest, NewSocks5Listener(Socks5Config{Username: "user", Password: "pass"}))
+	client, err := net.Dial("tcp", pAddr.String())
+	if err != nil {
+		t.Fatalf("Failed to dial listener: %v", err)
+	}
+	defer client.Close()
+	socksGreet(t, client, socksMethodPassword, socksMethodPassword)
+	client.Write([]byte("\x01\x04user\x04pass"))
+	if got, err := readWithin(client, 2, time.Second); err != nil || got[1] != 0 {
+		t.Fatalf("Failed to authenticate: %v %v", got, err)
+	}
+	if rep, _ := socksRequest(t, client, socksCmdConnect, socksAddrBytes(dest)); rep != socksRepOk {
+		t.Fatalf("Expected reply %d, got %d", socksRepOk, rep)
+	}
+	client.Write([]byte("ping"))
+	if got, err := readWithin(client, 6, time.Second); err != nil || string(got) != "s:ping" {
+		t.Errorf("Expected the destinations reply, got %q %v", got, err)
+	}
+}
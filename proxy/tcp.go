@@ -8,70 +8,650 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"sync"
 	"time"
 )
 
 // TCP proxy
 type TcpProxy struct {
-	ctx       context.Context                // Proxy context
-	ctxCancel context.CancelCauseFunc        // Cancel context
-	client    net.Conn                       // Client connection
-	server    net.Conn                       // Server connection
-	pktChan   chan<- handler.ProxyPacketData // Packet channel
+	ctx       context.Context                       // Proxy context
+	ctxCancel context.CancelCauseFunc               // Cancel context
+	client    net.Conn                              // Client connection, guarded by connLock
+	server    net.Conn                              // Server connection, guarded by connLock
+	connLock  sync.RWMutex                          // Held while writing, so ChangeServer & ChangeClient don't swap a connection mid write
+	dial      func(addr net.Addr) (net.Conn, error) // Connects to a server for ChangeServer
+	pktChan   chan<- handler.ProxyPacketData        // Packet channel
+	metadata  map[string]string                     // Extra connection info, may be nil
+	halfLock  sync.Mutex                            // Guards clientEof & serverEof
+	clientEof bool                                  // Client finished sending & the server's write side was closed
+	serverEof bool                                  // Server finished sending & the client's write side was closed
+	observer  handler.IObservable                   // If set, data is spliced between the connections while nothing observes it
+	readSize  int                                   // Largest read, each one is a packet
+	reconnect *ReconnectPolicy                      // If set, the server is redialed when it dies instead of closing the proxy
+	down      bool                                  // The server died & is being redialed, guarded by connLock
+	pending   []byte                                // Data for the server while it's down, guarded by connLock
+	extras    []*tcpExtraClient                     // Clients from AddClient, guarded by connLock
+	picked    net.Addr                              // Upstream the spawner picked for the proxy, before it was resolved. May be nil
 	logger    *slog.Logger
 }
 
-// Listen for packets
-func (t *TcpProxy) listen(c net.Conn) {
-	// Serverbound
-	serverbound := true
-	source := t.client
-	dest := t.server
-	if compareNetAddr(c.RemoteAddr(), t.server.RemoteAddr()) {
-		// Clientbound
-		source = t.server
-		dest = t.client
-		serverbound = false
+// Client added with AddClient
+type tcpExtraClient struct {
+	conn  net.Conn
+	role  handler.ClientRole
+	queue chan []byte   // Data for the client, written by its own goroutine so a slow client doesn't hold up the others
+	done  chan struct{} // Closed once the client is removed
+	once  sync.Once
+}
+
+// Number of writes queued for a client from AddClient before it's dropped for being too slow
+const tcpExtraClientQueue int = 256
+
+// What a TcpProxy does when its server connection dies, instead of closing.
+// A server closing its side while the client is still sending counts as dying, as a server restarting does that.
+// Data from the client is held until the server is back, data the old server had accepted but not handled is lost.
+type ReconnectPolicy struct {
+	Buffer     int           // Most data held for the server, the client is closed if it sends more. 0 for 1MiB
+	Deadline   time.Duration // Time the server has to come back before the client is closed. 0 for 30 seconds
+	MinBackoff time.Duration // Wait after the first failed dial, doubled after each one. 0 for 100ms
+	MaxBackoff time.Duration // Longest wait between dials. 0 for 5 seconds
+}
+
+const (
+	defaultReconnectBuffer     int           = 1 << 20
+	defaultReconnectDeadline   time.Duration = time.Second * 30
+	defaultReconnectMinBackoff time.Duration = time.Millisecond * 100
+	defaultReconnectMaxBackoff time.Duration = time.Second * 5
+)
+
+// Gets the policy with defaults for anything unset
+func (r ReconnectPolicy) withDefaults() *ReconnectPolicy {
+	if r.Buffer <= 0 {
+		r.Buffer = defaultReconnectBuffer
+	}
+	if r.Deadline <= 0 {
+		r.Deadline = defaultReconnectDeadline
+	}
+	if r.MinBackoff <= 0 {
+		r.MinBackoff = defaultReconnectMinBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultReconnectMaxBackoff
+	}
+	if r.MaxBackoff < r.MinBackoff {
+		r.MaxBackoff = r.MinBackoff
+	}
+	return &r
+}
+
+// Largest amount spliced before it's counted, the container only sees spliced data in these steps
+const tcpSpliceChunk int64 = 1 << 20
+
+// Most times SendToServer writes to a new server after the one it wrote to died, another server could die too
+const tcpSendAttempts int = 3
+
+// Gets the current server connection
+func (t *TcpProxy) getServer() net.Conn {
+	t.connLock.RLock()
+	defer t.connLock.RUnlock()
+	return t.server
+}
+
+// Gets the current client connection
+func (t *TcpProxy) getClient() net.Conn {
+	t.connLock.RLock()
+	defer t.connLock.RUnlock()
+	return t.client
+}
+
+// Checks if c is an old connection ChangeServer or ChangeClient replaced
+func (t *TcpProxy) replaced(c net.Conn) bool {
+	t.connLock.RLock()
+	defer t.connLock.RUnlock()
+	return c != t.client && c != t.server
+}
+
+// Checks if data can be spliced from src to dst, both have to be plain TCP connections.
+// Data being spliced can't be held for a reconnect or copied to other clients, so proxies doing either never splice.
+func (t *TcpProxy) canSplice(src net.Conn, dst net.Conn) bool {
+	if t.observer == nil || t.reconnect != nil {
+		return false
 	}
+	t.connLock.RLock()
+	extras := len(t.extras)
+	t.connLock.RUnlock()
+	if extras != 0 {
+		return false
+	}
+	_, srcTcp := src.(*net.TCPConn)
+	_, dstTcp := dst.(*net.TCPConn)
+	return srcTcp && dstTcp
+}
+
+// Listen for packets from c, which is the client if serverbound is set
+func (t *TcpProxy) listen(c net.Conn, serverbound bool) {
 	for t.ctx.Err() == nil {
-		buffer := make([]byte, 4096)
+		// Either side can change, so the other side is got for every read
+		dest := t.getClient()
+		if serverbound {
+			dest = t.getServer()
+		}
+		if t.canSplice(c, dest) && !t.observer.IsObserved() {
+			if done := t.splice(c, dest, serverbound); done {
+				return
+			}
+			continue
+		}
+		buffer := packetBuffers.Get(t.readSize)
 		c.SetReadDeadline(time.Now().Add(time.Second * 1))
 		n, err := c.Read(buffer)
 		if err != nil {
+			packetBuffers.Put(buffer)
 			if isTimeoutError(err) {
 				continue
 			}
+			if t.replaced(c) {
+				t.logger.Debug("Stopped listening to old connection", "Serverbound", serverbound)
+				return
+			}
+			if !serverbound && t.reconnect != nil && (err != io.EOF || !t.clientDone()) {
+				// The server died or restarted, a EOF is only it finishing once the client has finished too
+				t.serverDown(c, err)
+				return
+			}
 			// Terminated
 			if err == io.EOF {
-				t.logger.Debug("Connection closed")
-				t.ctxCancel(handler.ErrProxyRetry)
+				// Pass the EOF through the container so it arrives after any data still being forwarded
+				t.logger.Debug("Connection half closed", "Serverbound", serverbound)
+				select {
+				case t.pktChan <- handler.ProxyPacketData{
+					Serverbound: serverbound,
+					Source:      c.RemoteAddr(),
+					Dest:        dest.RemoteAddr(),
+					Eof:         true,
+				}:
+				case <-t.ctx.Done():
+				}
 			} else {
 				t.logger.Debug("Closing due to error", "Error", err.Error())
 				t.ctxCancel(fmt.Errorf("failed to read from proxy: %v", err))
 			}
 			return
 		}
-		t.logger.Debug("Sending packet data", "Serverbound", serverbound, "Source", source.RemoteAddr(), "Dest", dest.RemoteAddr(), "Data", buffer[:n])
+		t.logger.Debug("Sending packet data", "Serverbound", serverbound, "Source", c.RemoteAddr(), "Dest", dest.RemoteAddr(), "Data", buffer[:n])
 		t.pktChan <- handler.ProxyPacketData{
 			Serverbound: serverbound,
-			Source:      source.RemoteAddr(),
+			Source:      c.RemoteAddr(),
 			Dest:        dest.RemoteAddr(),
 			Data:        buffer[:n],
+			Pool:        packetBuffers,
+		}
+	}
+}
+
+// Copies from src to dst in the kernel until something observes the proxy, returns true if src is done.
+// Packets from before the splice are always forwarded first.
+func (t *TcpProxy) splice(src net.Conn, dst net.Conn, serverbound bool) (done bool) {
+	stats := handler.ProxyPacketData{
+		Serverbound: serverbound,
+		Source:      src.RemoteAddr(),
+		Dest:        dst.RemoteAddr(),
+		Spliced:     true,
+	}
+	// Nothing is buffered, once the container takes this every earlier packet was sent
+	select {
+	case t.pktChan <- stats:
+	case <-t.ctx.Done():
+		return true
+	}
+	src.SetReadDeadline(time.Time{})
+	// watchObserver sets a deadline when an observer attaches, it may have done that before it was cleared
+	if t.observer.IsObserved() {
+		return false
+	}
+	t.logger.Debug("Splicing", "Serverbound", serverbound)
+	rf := dst.(io.ReaderFrom)
+	for {
+		n, err := rf.ReadFrom(&io.LimitedReader{R: src, N: tcpSpliceChunk})
+		if n > 0 {
+			stats.SplicedBytes = uint64(n)
+			select {
+			case t.pktChan <- stats:
+			case <-t.ctx.Done():
+				return true
+			}
+		}
+		if err != nil {
+			if t.ctx.Err() != nil {
+				return true
+			}
+			if t.replaced(src) {
+				t.logger.Debug("Stopped splicing from old connection", "Serverbound", serverbound)
+				return true
+			}
+			if isTimeoutError(err) || t.replaced(dst) {
+				// Observer attached or the other side changed
+				t.logger.Debug("Stopped splicing", "Serverbound", serverbound)
+				return false
+			}
+			t.logger.Debug("Closing due to error", "Error", err.Error())
+			t.ctxCancel(fmt.Errorf("failed to splice: %v", err))
+			return true
+		}
+		if n == 0 {
+			// Reached EOF, a short copy isn't enough to tell as ReadFrom may stop early
+			t.logger.Debug("Connection half closed", "Serverbound", serverbound)
+			stats.SplicedBytes = 0
+			stats.Spliced = false
+			stats.Eof = true
+			select {
+			case t.pktChan <- stats:
+			case <-t.ctx.Done():
+			}
+			return true
+		}
+	}
+}
+
+// Interrupts splicing when an observer attaches or the proxy dies
+func (t *TcpProxy) watchObserver() {
+	for {
+		changed := t.observer.ObservedChanged()
+		if t.observer.IsObserved() {
+			t.getClient().SetReadDeadline(time.Now())
+			t.getServer().SetReadDeadline(time.Now())
+		}
+		select {
+		case <-changed:
+		case <-t.ctx.Done():
+			t.getClient().SetReadDeadline(time.Now())
+			t.getServer().SetReadDeadline(time.Now())
+			return
+		}
+	}
+}
+
+// Connects to addr & moves the proxy to it, the client connection is kept
+func (t *TcpProxy) ChangeServer(addr net.Addr) error {
+	if t.ctx == nil || t.ctx.Err() != nil {
+		return errors.New("proxy isn't running")
+	}
+	t.halfLock.Lock()
+	serverEof := t.serverEof
+	t.halfLock.Unlock()
+	if serverEof {
+		return errors.New("the client's write side is already closed")
+	}
+	sAddr, err := resolveStreamAddr(addr)
+	if err != nil {
+		return err
+	}
+	s, err := t.dial(sAddr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to new server: %v", err)
+	}
+	t.connLock.Lock()
+	old := t.server
+	if t.down {
+		// Reconnecting, the new server gets the held data instead
+		err := t.restoreServer(s)
+		t.connLock.Unlock()
+		if err != nil {
+			t.giveUp(fmt.Errorf("failed to replay data to server: %v", err))
+			return err
+		}
+	} else {
+		t.server = s
+		t.connLock.Unlock()
+		// Stop splicing to the old server, the client listener picks up the new one
+		t.getClient().SetReadDeadline(time.Now())
+		old.Close()
+		t.halfLock.Lock()
+		clientEof := t.clientEof
+		t.halfLock.Unlock()
+		if clientEof {
+			// The client is already done sending
+			closeWrite(s)
+		}
+	}
+	t.logger.Debug("Changed server", "Old", old.RemoteAddr().String(), "New", s.RemoteAddr().String())
+	go t.listen(s, false)
+	return nil
+}
+
+// Moves the proxy to a new client connection, the server connection is kept
+func (t *TcpProxy) ChangeClient(client net.Conn) error {
+	if t.ctx == nil || t.ctx.Err() != nil {
+		return errors.New("proxy isn't running")
+	}
+	t.halfLock.Lock()
+	clientEof, serverEof := t.clientEof, t.serverEof
+	t.halfLock.Unlock()
+	if clientEof {
+		return errors.New("the server's write side is already closed")
+	}
+	t.connLock.Lock()
+	old := t.client
+	t.client = client
+	t.connLock.Unlock()
+	// Stop splicing to the old client, the server listener picks up the new one
+	t.getServer().SetReadDeadline(time.Now())
+	old.Close()
+	if serverEof {
+		// The server is already done sending
+		closeWrite(client)
+	}
+	t.logger.Debug("Changed client", "Old", old.RemoteAddr().String(), "New", client.RemoteAddr().String())
+	go t.listen(client, true)
+	return nil
+}
+
+// Adds client alongside the others, it gets everything sent to the client. Data from writers is sent to the server
+func (t *TcpProxy) AddClient(client net.Conn, role handler.ClientRole) error {
+	if t.ctx == nil || t.ctx.Err() != nil {
+		return errors.New("proxy isn't running")
+	}
+	if role != handler.ClientRoleWriter && role != handler.ClientRoleObserver {
+		return fmt.Errorf("can't add a client with the %s role", role)
+	}
+	ec := &tcpExtraClient{
+		conn:  client,
+		role:  role,
+		queue: make(chan []byte, tcpExtraClientQueue),
+		done:  make(chan struct{}),
+	}
+	t.connLock.Lock()
+	t.extras = append(t.extras, ec)
+	t.connLock.Unlock()
+	// Stop splicing to the client, so the new one gets the data too
+	t.getServer().SetReadDeadline(time.Now())
+	t.logger.Debug("Added client", "ClientAddr", client.RemoteAddr().String(), "Role", role.String())
+	go t.writeExtra(ec)
+	go t.listenExtra(ec)
+	return nil
+}
+
+// Removes & closes a client from AddClient
+func (t *TcpProxy) removeExtra(ec *tcpExtraClient, reason string) {
+	ec.once.Do(func() {
+		t.connLock.Lock()
+		for k, v := range t.extras {
+			if v == ec {
+				t.extras = append(t.extras[:k], t.extras[k+1:]...)
+				break
+			}
+		}
+		t.connLock.Unlock()
+		close(ec.done)
+		ec.conn.Close()
+		t.logger.Debug("Removed client", "ClientAddr", ec.conn.RemoteAddr().String(), "Reason", reason)
+	})
+}
+
+// Writes queued data to a client from AddClient until it's removed or the proxy dies
+func (t *TcpProxy) writeExtra(ec *tcpExtraClient) {
+	for {
+		select {
+		case data := <-ec.queue:
+			if _, err := ec.conn.Write(data); err != nil {
+				t.removeExtra(ec, err.Error())
+				return
+			}
+		case <-ec.done:
+			return
+		case <-t.ctx.Done():
+			t.removeExtra(ec, "proxy closed")
+			return
+		}
+	}
+}
+
+// Reads from a client from AddClient, data from writers is sent to the server & data from observers is dropped
+func (t *TcpProxy) listenExtra(ec *tcpExtraClient) {
+	for t.ctx.Err() == nil {
+		buffer := packetBuffers.Get(t.readSize)
+		ec.conn.SetReadDeadline(time.Now().Add(time.Second * 1))
+		n, err := ec.conn.Read(buffer)
+		if err != nil {
+			packetBuffers.Put(buffer)
+			if isTimeoutError(err) {
+				continue
+			}
+			// Only this client is done, the proxy keeps going
+			t.removeExtra(ec, err.Error())
+			return
+		}
+		if ec.role != handler.ClientRoleWriter {
+			t.logger.Debug("Dropping data from observer", "ClientAddr", ec.conn.RemoteAddr().String(), "Data", buffer[:n])
+			packetBuffers.Put(buffer)
+			continue
+		}
+		t.logger.Debug("Sending packet data", "Serverbound", true, "Source", ec.conn.RemoteAddr(), "Dest", t.GetServerAddr(), "Data", buffer[:n])
+		select {
+		case t.pktChan <- handler.ProxyPacketData{
+			Serverbound: true,
+			Source:      ec.conn.RemoteAddr(),
+			Dest:        t.GetServerAddr(),
+			Data:        buffer[:n],
+			Pool:        packetBuffers,
+		}:
+		case <-t.ctx.Done():
+			packetBuffers.Put(buffer)
+			return
 		}
 	}
 }
 
+// Queues data for every client from AddClient, clients that are too far behind are dropped
+func (t *TcpProxy) sendToExtras(data []byte) {
+	t.connLock.RLock()
+	if len(t.extras) == 0 {
+		t.connLock.RUnlock()
+		return
+	}
+	// data is only valid during the send, all the clients share one copy
+	shared := append([]byte{}, data...)
+	var slow []*tcpExtraClient
+	for _, ec := range t.extras {
+		select {
+		case ec.queue <- shared:
+		default:
+			slow = append(slow, ec)
+		}
+	}
+	t.connLock.RUnlock()
+	for _, ec := range slow {
+		t.removeExtra(ec, "too slow")
+	}
+}
+
+// Checks if the client finished sending
+func (t *TcpProxy) clientDone() bool {
+	t.halfLock.Lock()
+	defer t.halfLock.Unlock()
+	return t.clientEof
+}
+
+// Checks if the server finished sending, a new server couldn't send anything to the client
+func (t *TcpProxy) serverDone() bool {
+	t.halfLock.Lock()
+	defer t.halfLock.Unlock()
+	return t.serverEof
+}
+
+// Marks the server s as dead & starts redialing it, if it's still the server.
+func (t *TcpProxy) serverDown(s net.Conn, cause error) {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	if s != t.server || t.down {
+		return
+	}
+	t.logger.Info("Server connection died, reconnecting", "ServerAddr", s.RemoteAddr().String(), "Error", cause.Error())
+	t.down = true
+	s.Close()
+	go t.redial(s.RemoteAddr())
+}
+
+// Dials addr with backoff until it works, the server is changed some other way or the deadline passes.
+// Held data is replayed to the new server before anything else is sent.
+func (t *TcpProxy) redial(addr net.Addr) {
+	deadline := time.NewTimer(t.reconnect.Deadline)
+	defer deadline.Stop()
+	backoff := t.reconnect.MinBackoff
+	for {
+		s, err := t.dial(addr)
+		if err == nil {
+			t.connLock.Lock()
+			if !t.down {
+				// ChangeServer got there first
+				t.connLock.Unlock()
+				s.Close()
+				return
+			}
+			err = t.restoreServer(s)
+			t.connLock.Unlock()
+			if err != nil {
+				t.giveUp(fmt.Errorf("failed to replay data to server: %v", err))
+				return
+			}
+			t.logger.Info("Reconnected to server", "ServerAddr", s.RemoteAddr().String())
+			go t.listen(s, false)
+			return
+		}
+		t.logger.Debug("Failed to reconnect to server", "ServerAddr", addr.String(), "Error", err.Error(), "Backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-deadline.C:
+			t.giveUp(fmt.Errorf("server didn't come back within %v: %v", t.reconnect.Deadline, err))
+			return
+		case <-t.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, t.reconnect.MaxBackoff)
+	}
+}
+
+// Makes s the server while it's down, replaying held data & the client's EOF. connLock must be held.
+func (t *TcpProxy) restoreServer(s net.Conn) error {
+	old := t.server
+	t.server = s
+	t.down = false
+	pending := t.pending
+	t.pending = nil
+	old.Close()
+	if len(pending) != 0 {
+		if _, err := s.Write(pending); err != nil {
+			return err
+		}
+	}
+	t.halfLock.Lock()
+	clientEof := t.clientEof
+	t.halfLock.Unlock()
+	if clientEof {
+		closeWrite(s)
+	}
+	return nil
+}
+
+// Holds data for the server while it's down, held is false if it isn't down
+func (t *TcpProxy) holdForServer(data []byte) (held bool, err error) {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	if !t.down {
+		return false, nil
+	}
+	if len(t.pending)+len(data) > t.reconnect.Buffer {
+		return true, fmt.Errorf("more than %d bytes held for the server", t.reconnect.Buffer)
+	}
+	t.pending = append(t.pending, data...)
+	t.logger.Debug("Holding data for server", "Data", data, "Held", len(t.pending))
+	return true, nil
+}
+
+// Closes the client & the proxy once the server can't be reconnected to
+func (t *TcpProxy) giveUp(cause error) {
+	t.logger.Info("Giving up on reconnecting to server", "Error", cause.Error())
+	// Cancelled first, so the client's listener failing on the closed connection isn't the cause
+	t.ctxCancel(cause)
+	t.getClient().Close()
+}
+
+// Marks a direction as finished, the proxy is closed once both are
+func (t *TcpProxy) finish(eof *bool) {
+	t.halfLock.Lock()
+	defer t.halfLock.Unlock()
+	*eof = true
+	if t.clientEof && t.serverEof {
+		t.logger.Debug("Connection closed")
+		t.ctxCancel(handler.ErrProxyClosedOk)
+	}
+}
+
+// Close the write side of the server, if it can't be half closed the proxy is closed
+func (t *TcpProxy) CloseServerWrite() error {
+	if t.reconnect != nil {
+		t.connLock.RLock()
+		down := t.down
+		if down {
+			// Sent once the server is back, redial can't restore it while connLock is held
+			t.finish(&t.clientEof)
+		}
+		t.connLock.RUnlock()
+		if down {
+			return nil
+		}
+	}
+	if err := closeWrite(t.getServer()); err != nil {
+		t.logger.Debug("Can't half close server, closing", "Error", err.Error())
+		t.ctxCancel(handler.ErrProxyClosedOk)
+		return nil
+	}
+	t.finish(&t.clientEof)
+	return nil
+}
+
+// Close the write side of the client, if it can't be half closed the proxy is closed
+func (t *TcpProxy) CloseClientWrite() error {
+	t.connLock.RLock()
+	for _, ec := range t.extras {
+		closeWrite(ec.conn)
+	}
+	t.connLock.RUnlock()
+	if err := closeWrite(t.getClient()); err != nil {
+		t.logger.Debug("Can't half close client, closing", "Error", err.Error())
+		t.ctxCancel(handler.ErrProxyClosedOk)
+		return nil
+	}
+	t.finish(&t.serverEof)
+	return nil
+}
+
 func (t *TcpProxy) Network() string {
 	return "tcp"
 }
 
 func (t *TcpProxy) GetClientAddr() net.Addr {
-	return t.client.RemoteAddr()
+	return t.getClient().RemoteAddr()
+}
+
+func (t *TcpProxy) GetServerAddr() net.Addr {
+	return t.getServer().RemoteAddr()
+}
+
+func (t *TcpProxy) GetUpstream() net.Addr {
+	return t.picked
+}
+
+func (t *TcpProxy) GetMetadata() map[string]string {
+	return t.metadata
 }
 
 // Send data to client
 func (t *TcpProxy) SendToClient(data []byte) error {
+	t.connLock.RLock()
 	_, err := t.client.Write(data)
+	t.connLock.RUnlock()
+	t.sendToExtras(data)
 	if err != nil {
 		t.logger.Debug("Failed to send data to client", "Data", data, "Error", err.Error())
 	} else {
@@ -80,15 +660,35 @@ func (t *TcpProxy) SendToClient(data []byte) error {
 	return err
 }
 
-// Send data to server
+// Send data to server, if it's down & reconnecting the data is held until it's back
 func (t *TcpProxy) SendToServer(data []byte) error {
-	_, err := t.server.Write(data)
-	if err != nil {
-		t.logger.Debug("Failed to send data to server", "Data", data, "Error", err.Error())
-	} else {
-		t.logger.Debug("Sent data to server", "Data", data)
+	for attempt := 1; ; attempt++ {
+		if t.reconnect != nil {
+			held, err := t.holdForServer(data)
+			if err != nil {
+				t.giveUp(err)
+				return err
+			}
+			if held {
+				return nil
+			}
+		}
+		t.connLock.RLock()
+		s := t.server
+		_, err := s.Write(data)
+		t.connLock.RUnlock()
+		if err != nil && t.reconnect != nil && attempt < tcpSendAttempts && !t.clientDone() && !t.serverDone() {
+			// Hold it for the new server, unless the server was already changed & this is tried on it
+			t.serverDown(s, err)
+			continue
+		}
+		if err != nil {
+			t.logger.Debug("Failed to send data to server", "Data", data, "Error", err.Error())
+		} else {
+			t.logger.Debug("Sent data to server", "Data", data)
+		}
+		return err
 	}
-	return err
 }
 
 // Initialize the proxy
@@ -101,72 +701,291 @@ func (t *TcpProxy) Init(pktChan chan<- handler.ProxyPacketData, ctx context.Cont
 	t.pktChan = pktChan
 	t.ctx = ctx
 	t.ctxCancel = cancel
-	go t.listen(t.client)
-	go t.listen(t.server)
+	if t.observer != nil {
+		go t.watchObserver()
+	}
+	go t.listen(t.getClient(), true)
+	go t.listen(t.getServer(), false)
 	return nil
 }
 
 // Create a new TcpProxy
-func newTcpProxy(client net.Conn, server net.Conn) handler.IProxy {
+func newTcpProxy(client net.Conn, server net.Conn) *TcpProxy {
 	t := &TcpProxy{
-		client: client,
-		server: server,
-		logger: slog.Default(),
+		client:   client,
+		server:   server,
+		dial:     dialStream,
+		readSize: DefaultReadSize,
+		logger:   slog.Default(),
 	}
 	return t
 }
 
-// Listen & Accept new connections to create new proxies
-func TcpListener(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+// Accepts TCP connections on the proxy address and calls handle for each one, handle owns the client connection.
+// sAddr is the resolved server address when the connection was accepted, upstream is the address the spawner picked before it was resolved.
+// Returns when the context is cancelled, cancelling it if the listener fails.
+func acceptTcp(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder, handle func(c net.Conn, sAddr net.Addr, upstream net.Addr)) {
+	acceptTcpPorts(ctx, cancel, ps, 0, handle)
+}
+
+// Both *net.TCPListener and *net.UnixListener
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+// Same as acceptTcp but listens on every port from the proxy address port to lastPort, 0 for only the proxy address port.
+// handle may be called from a goroutine per port, sAddr has its port moved as far from the server port as the port the client connected to is from the proxy port.
+func acceptTcpPorts(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder, lastPort int, handle func(c net.Conn, sAddr net.Addr, upstream net.Addr)) {
 	logger := slog.Default()
-	// Convert to TCP form
-	pAddr, err := net.ResolveTCPAddr("tcp", ps.GetProxyAddr().String())
+	// Convert to TCP or unix form
+	pAddr, err := resolveStreamAddr(ps.GetProxyAddr())
 	if err != nil {
 		logger.Warn("Failed to resolve ProxyAddr", "ProxyAddr", ps.GetProxyAddr().String(), "Error", err.Error())
 		cancel(fmt.Errorf("failed to resolve proxy addr: %v", err))
 		return
 	}
-	sAddr, err := net.ResolveTCPAddr("tcp", ps.GetServerAddr().String())
+	server := &serverAddrCache{ps: ps, resolve: resolveStreamAddr}
+	sAddr, err := server.get()
 	if err != nil {
 		logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
 		cancel(fmt.Errorf("failed to resolve server addr: %v", err))
 		return
 	}
-	// Listener
-	con, err := net.ListenTCP("tcp", pAddr)
+	addrs, err := portRangeAddrs(pAddr, sAddr, lastPort)
 	if err != nil {
-		logger.Warn("Failed to listen on proxy", "Error", err.Error(), "ProxyAddress", pAddr.String())
-		cancel(fmt.Errorf("failed to listen on proxy: %v", err))
+		logger.Warn("Invalid port range", "ProxyAddr", pAddr.String(), "LastPort", lastPort, "Error", err.Error())
+		cancel(fmt.Errorf("invalid port range: %v", err))
+		return
+	}
+	// Listeners
+	listeners := make([]deadlineListener, 0, len(addrs))
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, addr := range addrs {
+		removeStaleSocket(addr)
+		l, err := net.Listen(addr.Network(), addr.String())
+		if err != nil {
+			logger.Warn("Failed to listen on proxy", "Error", err.Error(), "ProxyAddress", addr.String())
+			cancel(fmt.Errorf("failed to listen on proxy: %v", err))
+			return
+		}
+		listeners = append(listeners, l.(deadlineListener))
+	}
+	logger.Debug("Listener started", "Ports", len(listeners))
+	if len(listeners) == 1 {
+		acceptTcpLoop(ctx, cancel, ps, listeners[0], server, 0, handle)
 		return
 	}
-	logger.Debug("Listener started")
+	wg := sync.WaitGroup{}
+	for k, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acceptTcpLoop(ctx, cancel, ps, l, server, k, handle)
+		}()
+	}
+	wg.Wait()
+}
+
+// Accepts connections on con until ctx is cancelled, offset is how far the port of con is from the proxy port
+func acceptTcpLoop(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder, con deadlineListener, server *serverAddrCache, offset int, handle func(c net.Conn, sAddr net.Addr, upstream net.Addr)) {
+	logger := slog.Default()
 	for ctx.Err() == nil {
 		con.SetDeadline(time.Now().Add(time.Second * 2))
-		c, err := con.AcceptTCP()
+		c, err := con.Accept()
 		if err != nil {
 			if isTimeoutError(err) {
 				continue
 			}
-			logger.Debug("Failed to accept TCP connection", "Error", err.Error())
+			logger.Debug("Failed to accept connection", "Error", err.Error())
 			cancel(fmt.Errorf("failed to accept tcp connection: %v", err))
-			continue
+			return
 		}
 		if ctx.Err() != nil {
 			logger.Debug("Unsticking connection")
 			// Self connect to unstick connection
+			c.Close()
 			break
 		}
-		// Create new connection to server
-		s, err := net.DialTCP("tcp", nil, sAddr)
+		sAddr, upstream, err := server.pick(c.RemoteAddr())
+		if err == nil {
+			sAddr, err = shiftPort(sAddr, offset)
+		}
 		if err != nil {
-			logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String())
+			logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
 			c.Close()
-			cancel(fmt.Errorf("failed to create new connection to server: %v", err))
 			continue
 		}
-		// Add the proxy in
-		logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", s.RemoteAddr().String())
-		ps.AddConnection(newTcpProxy(c, s))
+		handle(c, sAddr, upstream)
 	}
 	// Proxy handler died - no need to cancel.
 }
+
+// Options for NewTcpListener & NewUdpListener
+type ListenerOptions struct {
+	SendProxyHeader   ProxyProtocolVersion // Send a PROXY protocol header with the client address to the server, UDP only supports v2 & sends it with every datagram
+	AcceptProxyHeader bool                 // Clients must start with a PROXY protocol header, its addresses are used as the client's. TCP only
+	Splice            bool                 // Let the kernel copy TCP data while no filter callback or recv channel is active. TCP only
+	ReadSize          int                  // Largest TCP read, each read is a packet. 0 for the default
+	MaxDatagramSize   int                  // Largest UDP datagram, larger ones are dropped. 0 for the default
+	Resume            bool                 // Clients can start with a resume line to take over a running proxy, see readResumeToken. Clients that wait for the server first are delayed by ResumeTimeout. TCP only
+	ResumeTimeout     time.Duration        // Time a client has to start sending a resume line with Resume. 0 for the default
+	Reconnect         *ReconnectPolicy     // Redial the server when it dies instead of closing the proxy, nil to disable. TCP only
+	LastPort          int                  // Listen on every port from the proxy port to this, each forwards to the server port as far along. 0 for one port
+}
+
+// Gets ReadSize or the default
+func (o *ListenerOptions) readSize() int {
+	if o.ReadSize <= 0 {
+		return DefaultReadSize
+	}
+	return o.ReadSize
+}
+
+// Gets MaxDatagramSize or the default
+func (o *ListenerOptions) maxDatagramSize() int {
+	if o.MaxDatagramSize <= 0 {
+		return defaultMaxDatagramSize
+	}
+	return o.MaxDatagramSize
+}
+
+// Gets ResumeTimeout or the default
+func (o *ListenerOptions) resumeTimeout() time.Duration {
+	if o.ResumeTimeout <= 0 {
+		return defaultResumeTimeout
+	}
+	return o.ResumeTimeout
+}
+
+// Listen & Accept new connections to create new proxies
+//
+// The proxy & server addresses can be TCP or "unix" stream sockets, proxies on unix sockets still report "tcp" as their network.
+func TcpListener(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+	NewTcpListener(ListenerOptions{})(ctx, cancel, ps)
+}
+
+// Gives c to a running proxy if it sent a resume token or one is expecting it.
+// Returns the connection to use for a new proxy if it wasn't, bytes read looking for a token are replayed by it.
+func adoptClient(ps handler.IConnectionAdder, opts *ListenerOptions, c net.Conn) (client net.Conn, adopted bool) {
+	logger := slog.Default()
+	adopter, ok := ps.(handler.IClientAdopter)
+	if !ok {
+		return c, false
+	}
+	if opts.Resume {
+		token, role, read, err := readResumeToken(c, opts.resumeTimeout())
+		if err != nil {
+			logger.Debug("Failed to read resume token", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+			c.Close()
+			return nil, true
+		}
+		if token != "" {
+			if _, err := adopter.ResumeClient(token, role, c); err != nil {
+				logger.Debug("Failed to resume proxy", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+				c.Close()
+			}
+			return nil, true
+		}
+		c = newPrefixConn(c, read)
+	}
+	pc, err := adopter.AdoptExpectedClient(c)
+	if err != nil {
+		logger.Debug("Failed to give client to expecting proxy", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		return nil, true
+	}
+	return c, pc != nil
+}
+
+// Connects to the server & adds the proxy
+func addTcpConnection(ps handler.IConnectionAdder, opts *ListenerOptions, c net.Conn, sAddr net.Addr, upstream net.Addr) {
+	logger := slog.Default()
+	if opts.AcceptProxyHeader {
+		pc, err := acceptProxyHeader(c)
+		if err != nil {
+			logger.Debug("Failed to read PROXY header", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+			c.Close()
+			return
+		}
+		c = pc
+	}
+	c, adopted := adoptClient(ps, opts, c)
+	if adopted {
+		return
+	}
+	var header []byte
+	if opts.SendProxyHeader != ProxyProtocolNone {
+		var err error
+		header, err = buildProxyHeader(opts.SendProxyHeader, false, c.RemoteAddr(), c.LocalAddr())
+		if err != nil {
+			logger.Warn("Failed to build PROXY header", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+			c.Close()
+			return
+		}
+	}
+	// Create new connection to server
+	s, err := net.Dial(sAddr.Network(), sAddr.String())
+	if err != nil {
+		// Only this client is dropped, the listener keeps going & health checks take the server out of rotation
+		logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String())
+		c.Close()
+		return
+	}
+	if header != nil {
+		if _, err := s.Write(header); err != nil {
+			logger.Warn("Failed to send PROXY header to server", "Error", err.Error(), "ServerAddress", sAddr.String())
+			c.Close()
+			s.Close()
+			return
+		}
+	}
+	px := newTcpProxy(c, s)
+	px.picked = upstream
+	px.readSize = opts.readSize()
+	if opts.Reconnect != nil {
+		px.reconnect = opts.Reconnect.withDefaults()
+	}
+	if header != nil {
+		// Servers from ChangeServer get the header too
+		px.dial = func(addr net.Addr) (net.Conn, error) {
+			s, err := dialStream(addr)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.Write(header); err != nil {
+				s.Close()
+				return nil, err
+			}
+			return s, nil
+		}
+	}
+	if obs, ok := ps.(handler.IObservable); ok && opts.Splice {
+		px.observer = obs
+	}
+	// Add the proxy in
+	logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", s.RemoteAddr().String())
+	if _, err := ps.AddConnection(px); err != nil {
+		logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		c.Close()
+		s.Close()
+	}
+}
+
+// Creates a TcpListener with opts
+func NewTcpListener(opts ListenerOptions) handler.IProxyListener {
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		acceptTcpPorts(ctx, cancel, ps, opts.LastPort, func(c net.Conn, sAddr net.Addr, upstream net.Addr) {
+			if opts.AcceptProxyHeader || opts.Resume {
+				// Don't hold up the listener waiting for the header or token
+				go addTcpConnection(ps, &opts, c, sAddr, upstream)
+			} else {
+				addTcpConnection(ps, &opts, c, sAddr, upstream)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Conn that fails every write, calls onWrite first
+type failConn struct {
+	net.Conn
+	onWrite func()
+}
+
+func (f *failConn) Write(b []byte) (int, error) {
+	if f.onWrite != nil {
+		f.onWrite()
+	}
+	return 0, errors.New("write failed")
+}
+
+// Resets c instead of closing it cleanly
+func resetConn(c net.Conn) {
+	c.(*net.TCPConn).SetLinger(0)
+	c.Close()
+}
+
+// Server connections a test dial hands out, failing the first fails dials
+type testDialer struct {
+	t     *testing.T
+	lock  sync.Mutex
+	fails int
+	times []time.Time
+	conns chan net.Conn // Server side of each connection dialed
+}
+
+func newTestDialer(t *testing.T, fails int) *testDialer {
+	return &testDialer{t: t, fails: fails, conns: make(chan net.Conn, 8)}
+}
+
+func (d *testDialer) dial(addr net.Addr) (net.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.times = append(d.times, time.Now())
+	if len(d.times) <= d.fails {
+		return nil, errors.New("dial failed")
+	}
+	client, server := tcpPair(d.t)
+	d.conns <- server
+	return client, nil
+}
+
+func (d *testDialer) dialTimes() []time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]time.Time(nil), d.times...)
+}
+
+// Creates a initialized TcpProxy that reconnects with policy, returns the proxy & the test's side of the client & server
+func createReconnectProxy(t *testing.T, policy ReconnectPolicy, d *testDialer) (*TcpProxy, net.Conn, net.Conn) {
+	t.Helper()
+	client, pClient := tcpPair(t)
+	pServer, server := tcpPair(t)
+	px := newTcpProxy(pClient, pServer)
+	px.reconnect = policy.withDefaults()
+	px.dial = d.dial
+	if _, err := newTestAdder(t).AddConnection(px); err != nil {
+		t.Fatalf("Failed to add proxy: %v", err)
+	}
+	return px, client, server
+}
+
+// Checks if the proxy is redialing its server
+func serverIsDown(px *TcpProxy) bool {
+	px.connLock.RLock()
+	defer px.connLock.RUnlock()
+	return px.down
+}
+
+// Waits for the proxy to close & gets why
+func waitClosed(t *testing.T, px *TcpProxy, timeout time.Duration) error {
+	t.Helper()
+	select {
+	case <-px.ctx.Done():
+		return context.Cause(px.ctx)
+	case <-time.After(timeout):
+		t.Fatalf("Proxy wasn't closed")
+	}
+	return nil
+}
+
+// ReconnectPolicy, Ensure a reset server is redialed with backoff & held data is replayed
+//
+// Expect: Waits between dials double from MinBackoff up to MaxBackoff, data sent while down reaches the new server first
+func TestTcpReconnectBackoff(t *testing.T) {
+	d := newTestDialer(t, 4)
+	policy := ReconnectPolicy{MinBackoff: time.Millisecond * 40, MaxBackoff: time.Millisecond * 120, Deadline: time.Second * 5}
+	px, client, server := createReconnectProxy(t, policy, d)
+	resetConn(server)
+	// Held until the server is back
+	time.Sleep(time.Millisecond * 50)
+	client.Write([]byte("held"))
+	var newServer net.Conn
+	select {
+	case newServer = <-d.conns:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("Server wasn't redialed")
+	}
+	if got, err := readWithin(newServer, 4, time.Second); err != nil || string(got) != "held" {
+		t.Errorf("Expected held data to be replayed, got %q %v", got, err)
+	}
+	client.Write([]byte("after"))
+	if got, err := readWithin(newServer, 5, time.Second); err != nil || string(got) != "after" {
+		t.Errorf("Expected data to reach the new server, got %q %v", got, err)
+	}
+	times := d.dialTimes()
+	if len(times) != 5 {
+		t.Fatalf("Expected 5 dials, got %d", len(times))
+	}
+	for k, expect := range []time.Duration{40, 80, 120, 120} {
+		expect *= time.Millisecond
+		if gap := times[k+1].Sub(times[k]); gap < expect || gap > expect*3 {
+			t.Errorf("Dial %d: Expected a wait of about %v, got %v", k+1, expect, gap)
+		}
+	}
+	if px.ctx.Err() != nil {
+		t.Errorf("Proxy closed after reconnecting: %v", context.Cause(px.ctx))
+	}
+}
+
+// ReconnectPolicy, Ensure a server closing its side after the client did is half closed instead of redialed
+//
+// Expect: The server gets the clients EOF, the client gets the servers EOF, nothing is dialed & the proxy closes ok
+func TestTcpReconnectEof(t *testing.T) {
+	d := newTestDialer(t, 0)
+	px, client, server := createReconnectProxy(t, ReconnectPolicy{}, d)
+	client.(*net.TCPConn).CloseWrite()
+	if got, err := readWithin(server, 1, time.Second); err != io.EOF {
+		t.Fatalf("Expected EOF on the server, got %q %v", got, err)
+	}
+	server.(*net.TCPConn).CloseWrite()
+	if got, err := readWithin(client, 1, time.Second); err != io.EOF {
+		t.Fatalf("Expected EOF on the client, got %q %v", got, err)
+	}
+	if cause := waitClosed(t, px, time.Second); !errors.Is(cause, handler.ErrProxyClosedOk) {
+		t.Errorf("Expected ErrProxyClosedOk, got %v", cause)
+	}
+	if times := d.dialTimes(); len(times) != 0 {
+		t.Errorf("Expected no dials, got %d", len(times))
+	}
+}
+
+// ReconnectPolicy, Ensure a server that restarts cleanly is redialed
+//
+// Expect: The servers EOF isn't passed on, data sent while it's down reaches the restarted server & its replies reach the client
+func TestTcpReconnectRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	pServer, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	client, pClient := tcpPair(t)
+	px := newTcpProxy(pClient, pServer)
+	px.reconnect = ReconnectPolicy{MinBackoff: time.Millisecond * 20, Deadline: time.Second * 5}.withDefaults()
+	if _, err := newTestAdder(t).AddConnection(px); err != nil {
+		t.Fatalf("Failed to add proxy: %v", err)
+	}
+	// Shut down cleanly, the proxy gets a EOF
+	server.Close()
+	l.Close()
+	for end := time.Now().Add(time.Second); !serverIsDown(px) && time.Now().Before(end); {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !serverIsDown(px) {
+		t.Fatalf("Server EOF didn't start a reconnect")
+	}
+	client.Write([]byte("held"))
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to restart server: %v", err)
+	}
+	defer l.Close()
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 3))
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatalf("Server wasn't redialed: %v", err)
+	}
+	defer server.Close()
+	if got, err := readWithin(server, 4, time.Second); err != nil || string(got) != "held" {
+		t.Errorf("Expected held data to be replayed, got %q %v", got, err)
+	}
+	server.Write([]byte("back"))
+	if got, err := readWithin(client, 4, time.Second); err != nil || string(got) != "back" {
+		t.Errorf("Expected the restarted servers reply, got %q %v", got, err)
+	}
+	if px.ctx.Err() != nil {
+		t.Errorf("Proxy closed after reconnecting: %v", context.Cause(px.ctx))
+	}
+}
+
+// ReconnectPolicy, Ensure the proxy gives up once the deadline passes
+//
+// Expect: The proxy is closed with the dial error & the client is closed
+func TestTcpReconnectGiveUp(t *testing.T) {
+	d := newTestDialer(t, 1000)
+	policy := ReconnectPolicy{MinBackoff: time.Millisecond * 20, Deadline: time.Millisecond * 200}
+	px, client, server := createReconnectProxy(t, policy, d)
+	resetConn(server)
+	cause := waitClosed(t, px, time.Second*2)
+	if cause == nil || !strings.Contains(cause.Error(), "didn't come back") {
+		t.Errorf("Expected the deadline to pass, got %v", cause)
+	}
+	if got, err := readWithin(client, 1, time.Second); err == nil || isTimeoutError(err) {
+		t.Errorf("Expected the client to be closed, got %q %v", got, err)
+	}
+}
+
+// ReconnectPolicy, Ensure data past Buffer isn't held
+//
+// Expect: The proxy gives up once the client sends more than Buffer while the server is down
+func TestTcpReconnectBuffer(t *testing.T) {
+	d := newTestDialer(t, 1000)
+	policy := ReconnectPolicy{Buffer: 8, MinBackoff: time.Millisecond * 20, Deadline: time.Second * 5}
+	px, client, server := createReconnectProxy(t, policy, d)
+	resetConn(server)
+	for !serverIsDown(px) && px.ctx.Err() == nil {
+		time.Sleep(time.Millisecond * 10)
+	}
+	client.Write([]byte("12345"))
+	time.Sleep(time.Millisecond * 50)
+	if px.ctx.Err() != nil {
+		t.Fatalf("Proxy closed while under the buffer: %v", context.Cause(px.ctx))
+	}
+	client.Write([]byte("67890"))
+	cause := waitClosed(t, px, time.Second)
+	if cause == nil || !strings.Contains(cause.Error(), "more than 8 bytes") {
+		t.Errorf("Expected the buffer to be full, got %v", cause)
+	}
+}
+
+// SendToServer, Ensure writes to servers that keep dying are only tried tcpSendAttempts times
+//
+// Expect: A error after tcpSendAttempts writes
+func TestTcpSendToServerAttempts(t *testing.T) {
+	client, _ := tcpPair(t)
+	writes := 0
+	var fail func() *failConn
+	px := newTcpProxy(client, nil)
+	// Each write replaces the server, like ChangeServer racing the write
+	fail = func() *failConn {
+		return &failConn{Conn: client, onWrite: func() {
+			writes++
+			px.server = fail()
+		}}
+	}
+	px.server = fail()
+	px.reconnect = ReconnectPolicy{}.withDefaults()
+	if err := px.SendToServer([]byte("data")); err == nil {
+		t.Errorf("Expected a error")
+	}
+	if writes != tcpSendAttempts {
+		t.Errorf("Expected %d writes, got %d", tcpSendAttempts, writes)
+	}
+}
+
+// TcpProxy splicing, Ensure data is spliced while nothing observes the proxy & sent as packets once something does
+//
+// Expect: Data reaches the other side either way, spliced bytes are reported once the observer attaches & later data is a packet
+func TestTcpSpliceObserver(t *testing.T) {
+	client, pClient := tcpPair(t)
+	pServer, server := tcpPair(t)
+	px := newTcpProxy(pClient, pServer)
+	ca := newTestAdder(t)
+	px.observer = ca
+	if _, err := ca.AddConnection(px); err != nil {
+		t.Fatalf("Failed to add proxy: %v", err)
+	}
+	spliced := bytes.Repeat([]byte("s"), 100000)
+	client.Write(spliced)
+	if got, err := readWithin(server, len(spliced), time.Second); err != nil || !bytes.Equal(got, spliced) {
+		t.Fatalf("Expected spliced data to reach the server, got %d bytes %v", len(got), err)
+	}
+	if n := ca.forwarded.Load(); n != 0 {
+		t.Fatalf("Expected no packets while unobserved, got %d bytes", n)
+	}
+	ca.setObserved(true)
+	for end := time.Now().Add(time.Second); ca.spliced.Load() != uint64(len(spliced)) && time.Now().Before(end); {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := ca.spliced.Load(); n != uint64(len(spliced)) {
+		t.Fatalf("Expected %d spliced bytes, got %d", len(spliced), n)
+	}
+	client.Write([]byte("packet"))
+	if got, err := readWithin(server, 6, time.Second); err != nil || string(got) != "packet" {
+		t.Fatalf("Expected data to reach the server, got %q %v", got, err)
+	}
+	server.Write([]byte("reply"))
+	if got, err := readWithin(client, 5, time.Second); err != nil || string(got) != "reply" {
+		t.Fatalf("Expected data to reach the client, got %q %v", got, err)
+	}
+	if n := ca.forwarded.Load(); n != 11 {
+		t.Errorf("Expected 11 bytes of packets once observed, got %d", n)
+	}
+	if n := ca.spliced.Load(); n != uint64(len(spliced)) {
+		t.Errorf("Expected nothing else to be spliced, got %d bytes", n)
+	}
+}
+
+// TcpProxy splicing, Ensure a EOF while splicing is passed on as a half close
+//
+// Expect: The server gets EOF after the spliced data & can still reply
+func TestTcpSpliceEof(t *testing.T) {
+	client, pClient := tcpPair(t)
+	pServer, server := tcpPair(t)
+	px := newTcpProxy(pClient, pServer)
+	ca := newTestAdder(t)
+	px.observer = ca
+	if _, err := ca.AddConnection(px); err != nil {
+		t.Fatalf("Failed to add proxy: %v", err)
+	}
+	client.Write([]byte("last"))
+	client.(*net.TCPConn).CloseWrite()
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if got, err := io.ReadAll(server); err != nil || string(got) != "last" {
+		t.Fatalf("Expected the data then EOF, got %q %v", got, err)
+	}
+	server.Write([]byte("reply"))
+	if got, err := readWithin(client, 5, time.Second); err != nil || string(got) != "reply" {
+		t.Errorf("Expected the reply after the half close, got %q %v", got, err)
+	}
+}
+
+// Unresolved TCP address, such as a host name
+type hostAddr string
+
+func (h hostAddr) Network() string { return "tcp" }
+func (h hostAddr) String() string  { return string(h) }
+
+// NewTcpListener with upstreams, Ensure proxies are counted on the upstream they were picked from
+//
+// Expect: Upstreams given as host names each have 2 of 4 proxies with least-connections
+func TestTcpListenerUpstreamCounts(t *testing.T) {
+	a := startTagServer(t, "tcp", "127.0.0.1:0", "a:").(*net.TCPAddr)
+	b := startTagServer(t, "tcp", "127.0.0.1:0", "b:").(*net.TCPAddr)
+	ps, pAddr := startSpawner(t, "tcp", a, NewTcpListener(ListenerOptions{}))
+	upstreams := []net.Addr{hostAddr(net.JoinHostPort("localhost", strconv.Itoa(a.Port))), hostAddr(net.JoinHostPort("localhost", strconv.Itoa(b.Port)))}
+	if err := ps.SetUpstreams(upstreams, handler.UpstreamLeastConnections); err != nil {
+		t.Fatalf("Failed to set upstreams: %v", err)
+	}
+	for range 4 {
+		c, err := net.Dial("tcp", pAddr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer c.Close()
+		c.Write([]byte("1"))
+		if got, err := readWithin(c, 3, time.Second); err != nil {
+			t.Fatalf("Failed to get a reply: %q %v", got, err)
+		}
+	}
+	for _, v := range ps.GetUpstreams() {
+		if v.Connections != 2 {
+			t.Errorf("Expected 2 proxies on %s, got %d", v.Address, v.Connections)
+		}
+	}
+}
+
+// Pushes b.N reads of 4096 bytes from the client to the server through a TcpProxy
+func benchmarkTcpProxy(b *testing.B, putBack bool) {
+	client, pClient := tcpPair(b)
+	pServer, server := tcpPair(b)
+	px := newTcpProxy(pClient, pServer)
+	ca := newTestAdder(b)
+	ca.putBack = putBack
+	if _, err := ca.AddConnection(px); err != nil {
+		b.Fatalf("Failed to add proxy: %v", err)
+	}
+	data := make([]byte, 4096)
+	buffer := make([]byte, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.Write(data)
+		if _, err := io.ReadFull(server, buffer); err != nil {
+			b.Fatalf("Failed to read: %v", err)
+		}
+	}
+}
+
+// Buffers are put back once forwarded, like the container does
+func BenchmarkTcpProxyPool(b *testing.B) {
+	benchmarkTcpProxy(b, true)
+}
+
+// Buffers are never put back, so every read allocates like proxies used to
+func BenchmarkTcpProxyNoPool(b *testing.B) {
+	benchmarkTcpProxy(b, false)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	tlsHandshakeTimeout time.Duration = time.Second * 10 // Time a client or server has to finish the TLS handshake
+)
+
+// TLS settings for a TlsListener
+type TlsConfig struct {
+	CertFile           string         // PEM certificate presented to clients, unused if Ca is set
+	KeyFile            string         // PEM private key for CertFile, unused if Ca is set
+	Ca                 *CertAuthority // If set a certificate is minted for each SNI and the server connection is always TLS
+	ServerTls          bool           // Re-encrypt traffic to the server, if false the server gets plaintext
+	ServerName         string         // Name sent as SNI & verified against the server certificate, if empty the server address host is used
+	InsecureSkipVerify bool           // Don't verify the server certificate
+}
+
+// Creates the config for connecting to the server, serverName overrides cfg.ServerName if its not empty.
+func (cfg *TlsConfig) clientConfig(sAddr net.Addr, serverName string) *tls.Config {
+	if serverName == "" {
+		serverName = cfg.ServerName
+	}
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(sAddr.String())
+		if err == nil {
+			serverName = host
+		}
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+}
+
+// Completes the TLS handshake on the client connection, dials the server & adds the proxy.
+// Handshake failures only close this connection.
+func addTlsConnection(ps handler.IConnectionAdder, cfg *TlsConfig, serverConf *tls.Config, c net.Conn, sAddr net.Addr, upstream net.Addr) {
+	logger := slog.Default()
+	tc := tls.Server(c, serverConf)
+	hsCtx, hsCancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer hsCancel()
+	err := tc.HandshakeContext(hsCtx)
+	if err != nil {
+		logger.Debug("TLS handshake with client failed", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		tc.Close()
+		return
+	}
+	var s net.Conn
+	s, err = net.Dial(sAddr.Network(), sAddr.String())
+	if err != nil {
+		logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String())
+		tc.Close()
+		return
+	}
+	if cfg.ServerTls {
+		sc := tls.Client(s, cfg.clientConfig(sAddr, tc.ConnectionState().ServerName))
+		err = sc.HandshakeContext(hsCtx)
+		if err != nil {
+			logger.Warn("TLS handshake with server failed", "Error", err.Error(), "ServerAddress", sAddr.String())
+			tc.Close()
+			sc.Close()
+			return
+		}
+		s = sc
+	}
+	px := newTcpProxy(tc, s)
+	px.picked = upstream
+	if cfg.ServerTls {
+		// Servers from ChangeServer use TLS too
+		serverName := tc.ConnectionState().ServerName
+		px.dial = func(addr net.Addr) (net.Conn, error) {
+			s, err := dialStream(addr)
+			if err != nil {
+				return nil, err
+			}
+			sc := tls.Client(s, cfg.clientConfig(addr, serverName))
+			hsCtx, hsCancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+			defer hsCancel()
+			if err := sc.HandshakeContext(hsCtx); err != nil {
+				sc.Close()
+				return nil, err
+			}
+			return sc, nil
+		}
+	}
+	logger.Debug("Adding new proxy", "ClientAddr", c.RemoteAddr().String(), "ServerAddr", sAddr.String(), "ServerTls", cfg.ServerTls)
+	if _, err := ps.AddConnection(px); err != nil {
+		logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", c.RemoteAddr().String())
+		tc.Close()
+		s.Close()
+	}
+}
+
+// Creates a listener that terminates TLS from clients, packets seen by the spawner are the decrypted application data.
+// If cfg.ServerTls is set traffic is encrypted again before being sent to the server.
+//
+// If cfg.Ca is set the listener intercepts instead, minting a certificate for the SNI the client sent and connecting
+// to the server with the same SNI.
+func NewTlsListener(cfg TlsConfig) (handler.IProxyListener, error) {
+	serverConf := &tls.Config{}
+	if cfg.Ca != nil {
+		serverConf.GetCertificate = cfg.Ca.GetCertificate
+		cfg.ServerTls = true
+	} else {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("tls listener requires a certificate and key")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls key pair: %v", err)
+		}
+		serverConf.Certificates = []tls.Certificate{cert}
+	}
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		acceptTcp(ctx, cancel, ps, func(c net.Conn, sAddr net.Addr, upstream net.Addr) {
+			// Handshakes can be slow, don't hold up the listener.
+			go addTlsConnection(ps, &cfg, serverConf, c, sAddr, upstream)
+		})
+	}, nil
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	caValidFor   time.Duration = time.Hour * 24 * 365 * 10 // How long a generated CA is valid for
+	leafValidFor time.Duration = time.Hour * 24 * 365      // How long a minted leaf certificate is valid for
+	caCacheSize  int           = 1024                      // Minted certificates kept, the least recently used is dropped first
+)
+
+// Local certificate authority, mints leaf certificates for each name clients ask for.
+type CertAuthority struct {
+	cert      *x509.Certificate
+	key       crypto.Signer
+	certPem   []byte
+	cache     map[string]*list.Element // Minted certificates by name, the elements are in lru. Guarded by cacheLock
+	lru       *list.List               // *cachedCert, most recently used first. Guarded by cacheLock
+	cacheSize int                      // Most certificates kept in cache
+	minting   map[string]*mintCall     // Names being minted, later requests wait for the first. Guarded by cacheLock
+	cacheLock sync.Mutex
+	logger    *slog.Logger
+}
+
+type cachedCert struct {
+	name string
+	cert *tls.Certificate
+}
+
+// Mint in progress, done is closed once cert or err is set
+type mintCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// Gets the CA certificate in PEM form, this is what should be installed on clients.
+func (ca *CertAuthority) CertPem() []byte {
+	return ca.certPem
+}
+
+// Gets a certificate for the ClientHello, minting and caching it if needed.
+// If the client didn't send SNI the certificate is for the local IP the client connected to.
+func (ca *CertAuthority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := hello.ServerName
+	if name == "" && hello.Conn != nil {
+		host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String())
+		if err == nil {
+			name = host
+		}
+	}
+	if name == "" {
+		return nil, errors.New("no server name to mint a certificate for")
+	}
+	ca.cacheLock.Lock()
+	if el, found := ca.cache[name]; found {
+		c := el.Value.(*cachedCert).cert
+		if time.Now().Before(c.Leaf.NotAfter) {
+			ca.lru.MoveToFront(el)
+			ca.cacheLock.Unlock()
+			return c, nil
+		}
+	}
+	// Minting is slow, it's done without the lock & only once for each name
+	if call, found := ca.minting[name]; found {
+		ca.cacheLock.Unlock()
+		<-call.done
+		return call.cert, call.err
+	}
+	call := &mintCall{done: make(chan struct{})}
+	ca.minting[name] = call
+	ca.cacheLock.Unlock()
+
+	call.cert, call.err = ca.mint(name)
+	ca.cacheLock.Lock()
+	delete(ca.minting, name)
+	if call.err == nil {
+		ca.addCached(name, call.cert)
+	}
+	ca.cacheLock.Unlock()
+	close(call.done)
+	if call.err != nil {
+		ca.logger.Warn("Failed to mint certificate", "Name", name, "Error", call.err.Error())
+		return nil, call.err
+	}
+	ca.logger.Debug("Minted certificate", "Name", name)
+	return call.cert, nil
+}
+
+// Caches c for name, dropping the least recently used certificates past cacheSize. Must hold cacheLock
+func (ca *CertAuthority) addCached(name string, c *tls.Certificate) {
+	if el, found := ca.cache[name]; found {
+		ca.lru.Remove(el)
+	}
+	ca.cache[name] = ca.lru.PushFront(&cachedCert{name: name, cert: c})
+	for ca.lru.Len() > ca.cacheSize {
+		oldest := ca.lru.Back()
+		ca.lru.Remove(oldest)
+		delete(ca.cache, oldest.Value.(*cachedCert).name)
+	}
+}
+
+// Creates a new leaf certificate for name signed by the CA
+func (ca *CertAuthority) mint(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().Add(leafValidFor)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Creates a new self signed CA and writes it to certFile & keyFile
+func createCertAuthority(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "EzProxy Local CA", Organization: []string{"EzProxy"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// The key is written first, with a restricted mode, so a cert never exists without its key.
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Loads a CA from certFile & keyFile, if certFile doesn't exist a new CA is generated and saved to both files.
+func LoadOrCreateCertAuthority(certFile string, keyFile string) (*CertAuthority, error) {
+	logger := slog.Default()
+	if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+		logger.Info("Creating new certificate authority", "CertFile", certFile, "KeyFile", keyFile)
+		err = createCertAuthority(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create certificate authority: %v", err)
+		}
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate authority: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate authority: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a certificate authority")
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("certificate authority key can't sign")
+	}
+	return &CertAuthority{
+		cert:      cert,
+		key:       key,
+		certPem:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+		cacheSize: caCacheSize,
+		minting:   make(map[string]*mintCall),
+		cacheLock: sync.Mutex{},
+		logger:    logger,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func createTestCa(t *testing.T) *CertAuthority {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCertAuthority(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	return ca
+}
+
+// LoadOrCreateCertAuthority, Ensure a created CA is loaded again from its files
+//
+// Expect: The same CA certificate both times
+func TestLoadOrCreateCertAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	first, err := LoadOrCreateCertAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	second, err := LoadOrCreateCertAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load CA: %v", err)
+	}
+	if string(first.CertPem()) != string(second.CertPem()) {
+		t.Errorf("Loaded CA isn't the created CA")
+	}
+}
+
+// GetCertificate, Ensure minted certificates are signed by the CA for the name asked for
+//
+// Expect: DNS names & IPs verify against the CA, the second request is cached, no name fails
+func TestCertAuthorityGetCertificate(t *testing.T) {
+	ca := createTestCa(t)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.CertPem())
+	for _, name := range []string{"example.com", "127.0.0.1", "::1"} {
+		c, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("Failed to get certificate for %s: %v", name, err)
+		}
+		if _, err := c.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: pool}); err != nil {
+			t.Errorf("Certificate for %s didn't verify: %v", name, err)
+		}
+		again, _ := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if again != c {
+			t.Errorf("Certificate for %s wasn't cached", name)
+		}
+	}
+	if _, err := ca.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Errorf("Expected a error without a name")
+	}
+}
+
+// GetCertificate, Ensure the cache doesn't grow past cacheSize
+//
+// Expect: The least recently used name is dropped, recently used names are kept
+func TestCertAuthorityCacheBound(t *testing.T) {
+	ca := createTestCa(t)
+	ca.cacheSize = 2
+	get := func(name string) *tls.Certificate {
+		c, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("Failed to get certificate for %s: %v", name, err)
+		}
+		return c
+	}
+	a := get("a.test")
+	b := get("b.test")
+	// a is now more recent than b
+	get("a.test")
+	get("c.test")
+	if len(ca.cache) != 2 || ca.lru.Len() != 2 {
+		t.Fatalf("Expected 2 cached certificates, got %d %d", len(ca.cache), ca.lru.Len())
+	}
+	if get("a.test") != a {
+		t.Errorf("Recently used certificate was dropped")
+	}
+	if get("b.test") == b {
+		t.Errorf("Least recently used certificate wasn't dropped")
+	}
+}
+
+// GetCertificate, Ensure requests for a name being minted wait for it instead of minting again
+//
+// Expect: Every request gets the same certificate
+func TestCertAuthorityMintOnce(t *testing.T) {
+	ca := createTestCa(t)
+	certs := make([]*tls.Certificate, 20)
+	wg := sync.WaitGroup{}
+	for k := range certs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs[k], _ = ca.GetCertificate(&tls.ClientHelloInfo{ServerName: "once.test"})
+		}()
+	}
+	wg.Wait()
+	for _, c := range certs {
+		if c == nil || c != certs[0] {
+			t.Fatalf("Expected every request to get the same certificate")
+		}
+	}
+}
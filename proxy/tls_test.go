@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a self signed certificate for names, returns the certificate & key files
+func writeTestCert(t *testing.T, names ...string) (certFile string, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+// Starts a TLS server that replies to everything with tag & what it got
+func startTlsTagServer(t *testing.T, tag string) net.Addr {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(writeTestCert(t, "server.test"))
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buffer := make([]byte, 1024)
+				for {
+					n, err := c.Read(buffer)
+					if err != nil {
+						return
+					}
+					c.Write(append([]byte(tag), buffer[:n]...))
+				}
+			}()
+		}
+	}()
+	return l.Addr()
+}
+
+// Sends ping over TLS to addr & checks the reply is expect
+func tlsExchange(t *testing.T, addr string, conf *tls.Config, expect string) *tls.Conn {
+	t.Helper()
+	c, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, conf)
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.Write([]byte("ping"))
+	if got, err := readWithin(c, len(expect), time.Second); err != nil || string(got) != expect {
+		t.Errorf("Expected %q, got %q %v", expect, got, err)
+	}
+	return c
+}
+
+// NewTlsListener, Ensure a certificate & key are needed without a CA
+//
+// Expect: Missing & invalid files fail
+func TestNewTlsListenerInvalid(t *testing.T) {
+	certFile, _ := writeTestCert(t, "proxy.test")
+	for name, cfg := range map[string]TlsConfig{
+		"no files":  {},
+		"no key":    {CertFile: certFile},
+		"bad files": {CertFile: certFile, KeyFile: certFile},
+	} {
+		if _, err := NewTlsListener(cfg); err == nil {
+			t.Errorf("%s: Expected a error", name)
+		}
+	}
+}
+
+// NewTlsListener, Ensure clients are terminated with the static certificate & the server gets plaintext
+//
+// Expect: The client verifies the certificate, data reaches the plaintext server & back
+func TestTlsListenerStatic(t *testing.T) {
+	server := startTagServer(t, "tcp", "127.0.0.1:0", "plain:")
+	certFile, keyFile := writeTestCert(t, "proxy.test")
+	listener, err := NewTlsListener(TlsConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	_, pAddr := startSpawner(t, "tcp", server, listener)
+	pem, _ := os.ReadFile(certFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	tlsExchange(t, pAddr.String(), &tls.Config{ServerName: "proxy.test", RootCAs: pool}, "plain:ping")
+}
+
+// NewTlsListener with a CA, Ensure certificates are minted for the SNI & the server connection is TLS
+//
+// Expect: The client verifies the minted certificate against the CA, data reaches the TLS server & back
+func TestTlsListenerIntercept(t *testing.T) {
+	server := startTlsTagServer(t, "tls:")
+	ca := createTestCa(t)
+	listener, err := NewTlsListener(TlsConfig{Ca: ca, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	_, pAddr := startSpawner(t, "tcp", server, listener)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.CertPem())
+	c := tlsExchange(t, pAddr.String(), &tls.Config{ServerName: "intercept.test", RootCAs: pool}, "tls:ping")
+	if names := c.ConnectionState().PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != "intercept.test" {
+		t.Errorf("Expected a certificate for intercept.test, got %v", names)
+	}
+}
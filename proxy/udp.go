@@ -7,62 +7,152 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	udpIdleTimeout  time.Duration = time.Second * 30 // Time without traffic before a UDP session is closed
+	udpSessionQueue int           = 64               // Number of datagrams queued for a session before they are dropped
+)
+
 type UdpProxy struct {
-	ctx          context.Context
-	ctxCancel    context.CancelCauseFunc
-	client       *net.UDPAddr
-	server       *net.UDPAddr
-	proxy        *net.UDPConn
-	pktChan      chan<- handler.ProxyPacketData
-	firstPktData []byte
+	ctx        context.Context
+	ctxCancel  context.CancelCauseFunc
+	client     net.Addr
+	server     net.Addr       // Guarded by connLock
+	proxy      net.PacketConn // Shared listening connection, used to reach the client
+	upstream   net.Conn       // Connection only this session uses to reach the server, guarded by connLock
+	connLock   sync.RWMutex   // Held while writing to the server, so ChangeServer doesn't swap it mid write
+	pktChan    chan<- handler.ProxyPacketData
+	clientPkts chan []byte // Datagrams from the client in pooled buffers, fed by the listener
+	serverPkts chan []byte // Datagrams from the server in pooled buffers, fed by listenServer
+	metadata   map[string]string
+	picked     net.Addr // Upstream the spawner picked for the session, before it was resolved. May be nil
+	maxSize    int      // Largest datagram from the server, larger ones are dropped
+	// Time without traffic before the session is closed
+	idleTimeout time.Duration
+	// Prepended to every datagram sent to the client, such as a SOCKS UDP header. Packets seen by the spawner don't include it.
+	// Guarded by connLock
+	clientHeader []byte
+	// Prepended to every datagram sent to the server, such as a PROXY protocol header. Guarded by connLock
+	serverHeader []byte
 	logger       *slog.Logger
+	// Builds clientHeader & serverHeader for a server, ChangeServer calls it again for the new server. May be nil
+	headers func(server net.Addr) (clientHeader []byte, serverHeader []byte, err error)
 }
 
-// Listener for UDP proxies
-func (u *UdpProxy) listen() {
-	u.pktChan <- handler.ProxyPacketData{
-		Serverbound: true,
-		Source:      u.client,
-		Dest:        u.server,
-		Data:        u.firstPktData,
+// Builds the headers for server, nil without a header builder
+func (u *UdpProxy) buildHeaders(server net.Addr) ([]byte, []byte, error) {
+	if u.headers == nil {
+		return nil, nil, nil
 	}
-	u.firstPktData = nil
+	return u.headers(server)
+}
+
+// Gets the current server address & upstream connection
+func (u *UdpProxy) getServer() (net.Addr, net.Conn) {
+	u.connLock.RLock()
+	defer u.connLock.RUnlock()
+	return u.server, u.upstream
+}
+
+// Reads replies from the server on upstream
+func (u *UdpProxy) listenServer(upstream net.Conn) {
+	defer upstream.Close()
+	// One extra byte to tell if a datagram was too large
+	buffer := make([]byte, u.maxSize+1)
 	for u.ctx.Err() == nil {
-		buffer := make([]byte, 4096)
-		u.proxy.SetReadDeadline(time.Now().Add(time.Second * 2))
-		n, from, err := u.proxy.ReadFromUDP(buffer)
+		upstream.SetReadDeadline(time.Now().Add(time.Second * 2))
+		n, err := upstream.Read(buffer)
 		if err != nil {
 			if isTimeoutError(err) {
 				continue
 			}
+			if _, current := u.getServer(); current != upstream {
+				u.logger.Debug("Stopped listening to old server")
+				return
+			}
+			// A datagram reached a port nothing listens on, the server may be restarting
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				u.logger.Debug("Server refused datagram", "Client", u.client.String(), "Server", upstream.RemoteAddr().String())
+				continue
+			}
 			u.logger.Debug("Closing due to error", "Error", err.Error())
-			u.ctxCancel(err)
+			u.ctxCancel(fmt.Errorf("failed to read from server: %v", err))
 			return
 		}
-		pktData := handler.ProxyPacketData{
-			Data:   buffer[:n],
-			Source: from,
+		if n > u.maxSize {
+			u.logger.Debug("Dropping datagram larger than the max size", "Client", u.client.String(), "From", upstream.RemoteAddr().String(), "MaxSize", u.maxSize)
+			continue
+		}
+		data := pooledCopy(buffer[:n])
+		if !u.queue(u.serverPkts, data) {
+			u.logger.Debug("Session queue full, dropping datagram", "Client", u.client.String(), "From", upstream.RemoteAddr().String())
+			packetBuffers.Put(data)
 		}
-		if compareNetAddr(from, u.client) {
+	}
+}
+
+// Listener for UDP proxies
+func (u *UdpProxy) listen() {
+	idle := time.NewTimer(u.idleTimeout)
+	defer idle.Stop()
+	for {
+		pktData := handler.ProxyPacketData{Pool: packetBuffers}
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-idle.C:
+			u.logger.Debug("Closing idle session", "Client", u.client.String(), "Timeout", u.idleTimeout)
+			u.ctxCancel(handler.ErrProxyClosedOk)
+			return
+		case data := <-u.clientPkts:
 			// Serverbound
 			pktData.Serverbound = true
-			pktData.Dest = u.server
-		} else if compareNetAddr(from, u.server) {
+			pktData.Source = u.client
+			pktData.Dest, _ = u.getServer()
+			pktData.Data = data
+		case data := <-u.serverPkts:
 			// Clientbound
 			pktData.Serverbound = false
+			pktData.Source, _ = u.getServer()
 			pktData.Dest = u.client
-		} else {
-			// Unknown sender, ignore packet
-			continue
+			pktData.Data = data
 		}
+		// Drain the timer if it fired while handling this packet, or Reset keeps the old expiry in idle.C
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(u.idleTimeout)
 		u.logger.Debug("Sending packet data", "Serverbound", pktData.Serverbound, "Source", pktData.Source, "Dest", pktData.Dest, "Data", pktData.Data)
-		u.pktChan <- pktData
+		select {
+		case u.pktChan <- pktData:
+		case <-u.ctx.Done():
+			return
+		}
+	}
+}
+
+// Queue a datagram for this session, if the queue is full the datagram is dropped.
+func (u *UdpProxy) queue(q chan []byte, data []byte) bool {
+	select {
+	case q <- data:
+		return true
+	default:
+		return false
 	}
 }
 
+// Checks if the session is still alive, a session that hasn't been initialized is not alive.
+func (u *UdpProxy) isAlive() bool {
+	return u.ctx != nil && u.ctx.Err() == nil
+}
+
 func (u *UdpProxy) Network() string {
 	return "udp"
 }
@@ -77,8 +167,8 @@ func (u *UdpProxy) Init(pktChan chan<- handler.ProxyPacketData, ctx context.Cont
 	u.pktChan = pktChan
 	u.ctx = ctx
 	u.ctxCancel = cancel
-	// Send the first packet we got to the server
 	go u.listen()
+	go u.listenServer(u.upstream)
 	return nil
 }
 
@@ -87,9 +177,59 @@ func (u *UdpProxy) GetClientAddr() net.Addr {
 	return u.client
 }
 
+// Gets server address
+func (u *UdpProxy) GetServerAddr() net.Addr {
+	server, _ := u.getServer()
+	return server
+}
+
+// Moves the session to a new server, replies from the old one are dropped. The headers are rebuilt for the new server
+func (u *UdpProxy) ChangeServer(addr net.Addr) error {
+	if !u.isAlive() {
+		return errors.New("proxy isn't running")
+	}
+	sAddr, err := resolveDatagramAddr(addr)
+	if err != nil {
+		return err
+	}
+	clientHeader, serverHeader, err := u.buildHeaders(sAddr)
+	if err != nil {
+		return fmt.Errorf("failed to build headers for new server: %v", err)
+	}
+	upstream, err := dialDatagram(sAddr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to new server: %v", err)
+	}
+	u.connLock.Lock()
+	old := u.upstream
+	u.server = sAddr
+	u.upstream = upstream
+	u.clientHeader = clientHeader
+	u.serverHeader = serverHeader
+	u.connLock.Unlock()
+	old.Close()
+	u.logger.Debug("Changed server", "Client", u.client.String(), "New", sAddr.String())
+	go u.listenServer(upstream)
+	return nil
+}
+
+func (u *UdpProxy) GetUpstream() net.Addr {
+	return u.picked
+}
+
+func (u *UdpProxy) GetMetadata() map[string]string {
+	return u.metadata
+}
+
 // Send packet to client
 func (u *UdpProxy) SendToClient(data []byte) error {
-	_, err := u.proxy.WriteToUDP(data, u.client)
+	u.connLock.RLock()
+	header := u.clientHeader
+	u.connLock.RUnlock()
+	if header != nil {
+		data = append(append(make([]byte, 0, len(header)+len(data)), header...), data...)
+	}
+	_, err := u.proxy.WriteTo(data, u.client)
 	if err != nil {
 		u.logger.Debug("Failed to send data to client", "Data", data, "Error", err.Error())
 	} else {
@@ -100,7 +240,12 @@ func (u *UdpProxy) SendToClient(data []byte) error {
 
 // Send packet to server
 func (u *UdpProxy) SendToServer(data []byte) error {
-	_, err := u.proxy.WriteToUDP(data, u.server)
+	u.connLock.RLock()
+	if u.serverHeader != nil {
+		data = append(append(make([]byte, 0, len(u.serverHeader)+len(data)), u.serverHeader...), data...)
+	}
+	_, err := u.upstream.Write(data)
+	u.connLock.RUnlock()
 	if err != nil {
 		u.logger.Debug("Failed to send data to server", "Data", data, "Error", err.Error())
 	} else {
@@ -109,75 +254,127 @@ func (u *UdpProxy) SendToServer(data []byte) error {
 	return err
 }
 
-// Create a new UDP proxy
-func newUdpProxy(client *net.UDPAddr, proxy *net.UDPConn, server *net.UDPAddr, firstPkt []byte) handler.IProxy {
+// Create a new UDP proxy, firstPkt is queued to be sent to the server once the proxy is initialized.
+// upstream is owned by the proxy and closed when it dies, firstPkt must be from packetBuffers.
+func newUdpProxy(client net.Addr, proxy net.PacketConn, server net.Addr, upstream net.Conn, firstPkt []byte) *UdpProxy {
 	// These should convert properly always because we pass them from Handler
 	up := &UdpProxy{
-		client:       client,
-		server:       server,
-		proxy:        proxy,
-		firstPktData: firstPkt,
-		logger:       slog.Default(),
+		client:      client,
+		server:      server,
+		proxy:       proxy,
+		upstream:    upstream,
+		clientPkts:  make(chan []byte, udpSessionQueue),
+		serverPkts:  make(chan []byte, udpSessionQueue),
+		maxSize:     defaultMaxDatagramSize,
+		idleTimeout: udpIdleTimeout,
+		logger:      slog.Default(),
 	}
+	up.clientPkts <- firstPkt
 	return up
 }
 
 // Listener for new UDP proxies
+//
+// Datagrams are demultiplexed by the client address, each client gets its own UdpProxy and is closed on its own once it goes idle.
+// Every session dials its own socket to the server, so the server sees a distinct address for each client.
+// With ListenerOptions.SendProxyHeader the PROXY v2 header is prepended to every datagram sent to the server, not just the first.
+//
+// The proxy & server addresses can be UDP or "unixgram" sockets, proxies on unixgram sockets still report "udp" as their network.
+// Unixgram clients must bind their socket to a path, datagrams from unbound sockets can't be replied to and are dropped.
+// Sessions reply through the listeners socket, so they are closed when the listener stops.
 func UdpListener(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+	NewUdpListener(ListenerOptions{})(ctx, cancel, ps)
+}
+
+// Creates a UdpListener with opts
+func NewUdpListener(opts ListenerOptions) handler.IProxyListener {
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		udpListen(ctx, cancel, ps, &opts)
+	}
+}
+
+func udpListen(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder, opts *ListenerOptions) {
 	logger := slog.Default()
-	// Get the addresses in UDP form
-	pAddr, err := net.ResolveUDPAddr("udp", ps.GetProxyAddr().String())
+	// Get the addresses in UDP or unixgram form
+	pAddr, err := resolveDatagramAddr(ps.GetProxyAddr())
 	if err != nil {
 		logger.Warn("Failed to resolve ProxyAddr", "ProxyAddr", ps.GetProxyAddr().String(), "Error", err.Error())
 		cancel(fmt.Errorf("failed to resolve udp proxy address: %v", err))
 		return
 	}
-	sAddr, err := net.ResolveUDPAddr("udp", ps.GetServerAddr().String())
+	server := &serverAddrCache{ps: ps, resolve: resolveDatagramAddr}
+	sAddr, err := server.get()
 	if err != nil {
 		logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
 		cancel(fmt.Errorf("failed to resolve udp server address: %v", err))
 		return
 	}
-	// Open a UDP listener
-	id := -1
-	pCon, err := net.ListenUDP("udp", pAddr)
+	addrs, err := portRangeAddrs(pAddr, sAddr, opts.LastPort)
 	if err != nil {
-		// Can't open UDP connection, fatal error.
-		logger.Warn("Failed to listen on proxy", "Error", err.Error(), "ProxyAddress", pAddr.String())
-		cancel(fmt.Errorf("failed to listen on udp proxy: %v", err))
+		logger.Warn("Invalid port range", "ProxyAddr", pAddr.String(), "LastPort", opts.LastPort, "Error", err.Error())
+		cancel(fmt.Errorf("invalid udp port range: %v", err))
 		return
 	}
-	logger.Debug("Listener started")
-	for ctx.Err() == nil {
-		// If we have a proxy already
-		if id != -1 {
-			// Check if its been pruned
-			cl, err := ps.GetProxy(id)
-			if err != nil || !cl.IsAlive() {
-				// If it has, close pCon (Incase the proxy didn't, but it probably did.)
-				pCon.Close()
-				// Create a new pCon, because the proxy probably closed it.
-				pCon, err = net.ListenUDP("udp", pAddr)
-				if err != nil {
-					// Can't open UDP connection, fatal error.
-					logger.Warn("Failed to re listen on proxy address", "ProxyAddress", pAddr.String(), "Error", err.Error())
-					cancel(fmt.Errorf("failed to re listen on udp proxy: %v", err))
-					return
-				}
-				// Set the id to unused.
-				id = -1
-			} else {
-				// Wait for a bit so we don't just spam check it for no reason.
-				// I don't really know how long this wait should be, I think this is good though?
-				time.Sleep(time.Second * 1)
-				continue
+	// Open the UDP listeners
+	pCons := make([]net.PacketConn, 0, len(addrs))
+	defer func() {
+		for _, v := range pCons {
+			v.Close()
+		}
+	}()
+	for _, addr := range addrs {
+		removeStaleSocket(addr)
+		pCon, err := net.ListenPacket(addr.Network(), addr.String())
+		if err != nil {
+			// Can't open UDP connection, fatal error.
+			logger.Warn("Failed to listen on proxy", "Error", err.Error(), "ProxyAddress", addr.String())
+			cancel(fmt.Errorf("failed to listen on udp proxy: %v", err))
+			return
+		}
+		pCons = append(pCons, pCon)
+	}
+	// Unixgram sockets aren't removed when closed
+	defer removeStaleSocket(pAddr)
+	logger.Debug("Listener started", "Ports", len(pCons))
+	if len(pCons) == 1 {
+		udpServe(ctx, ps, opts, pCons[0], server, 0)
+		return
+	}
+	wg := sync.WaitGroup{}
+	for k, pCon := range pCons {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			udpServe(ctx, ps, opts, pCon, server, k)
+		}()
+	}
+	wg.Wait()
+}
+
+// Reads datagrams on pCon & hands them to sessions until ctx is cancelled, offset is how far the port of pCon is from the proxy port
+func udpServe(ctx context.Context, ps handler.IConnectionAdder, opts *ListenerOptions, pCon net.PacketConn, server *serverAddrCache, offset int) {
+	logger := slog.Default()
+	// Sessions by client address, sessions remove themselves once their context is done
+	sessions := make(map[string]*UdpProxy)
+	sessionsLock := sync.Mutex{}
+	// Sessions reach their clients through pCon, so they can't outlive the listener
+	defer func() {
+		sessionsLock.Lock()
+		defer sessionsLock.Unlock()
+		for _, v := range sessions {
+			if v.isAlive() {
+				v.ctxCancel(handler.ErrProxyClosedOk)
 			}
 		}
+	}()
+	maxSize := opts.maxDatagramSize()
+	// Reused for every read, datagrams are copied out of it. One extra byte to tell if a datagram was too large
+	buffer := make([]byte, maxSize+1)
+	for ctx.Err() == nil {
 		// Wait for traffic on proxy
-		buffer := make([]byte, 4096)
-		// Set timeout so we check h.alive every once and a while.
+		// Set timeout so we check ctx every once and a while.
 		pCon.SetReadDeadline(time.Now().Add(time.Second * 2))
-		n, from, err := pCon.ReadFromUDP(buffer)
+		n, from, err := pCon.ReadFrom(buffer)
 		if err != nil {
 			if isTimeoutError(err) {
 				continue
@@ -185,19 +382,82 @@ func UdpListener(ctx context.Context, cancel context.CancelCauseFunc, ps handler
 			logger.Debug("Failed to read from proxy", "Error", err.Error())
 			continue
 		}
-		// From server, ignored.
+		// Unbound unixgram socket
+		if from == nil {
+			logger.Debug("Ignoring datagram from a sender without an address")
+			continue
+		}
+		if n > maxSize {
+			logger.Debug("Dropping datagram larger than the max size", "From", from.String(), "MaxSize", maxSize)
+			continue
+		}
+		// Existing client
+		sessionsLock.Lock()
+		s, found := sessions[from.String()]
+		sessionsLock.Unlock()
+		if found && s.isAlive() {
+			data := pooledCopy(buffer[:n])
+			if !s.queue(s.clientPkts, data) {
+				logger.Debug("Session queue full, dropping datagram", "Client", from.String())
+				packetBuffers.Put(data)
+			}
+			continue
+		}
+		sAddr, picked, err := server.pick(from)
+		if err == nil {
+			sAddr, err = shiftPort(sAddr, offset)
+		}
+		if err != nil {
+			logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
+			continue
+		}
+		// From server, replies should come in on the sessions upstream connection, ignored.
 		if compareNetAddr(sAddr, from) {
 			logger.Debug("Ignoring data from server in listener", "ServerAddress", sAddr.String(), "From", from.String())
 			continue
 		}
-		// New client - The client is nil as this is UDP
-		pc, err := ps.AddConnection(newUdpProxy(from, pCon, sAddr, buffer[:n]))
+		// New client, give it its own connection to the server
+		upstream, err := dialDatagram(sAddr)
+		if err != nil {
+			logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String(), "From", from.String())
+			continue
+		}
+		up := newUdpProxy(from, pCon, sAddr, upstream, pooledCopy(buffer[:n]))
+		up.maxSize = maxSize
+		up.picked = picked
+		if opts.SendProxyHeader != ProxyProtocolNone {
+			// Each datagram stands alone, so every one gets the header
+			version, client, local := opts.SendProxyHeader, from, pCon.LocalAddr()
+			up.headers = func(server net.Addr) ([]byte, []byte, error) {
+				header, err := buildProxyHeader(version, true, client, local)
+				return nil, header, err
+			}
+			up.clientHeader, up.serverHeader, err = up.buildHeaders(sAddr)
+			if err != nil {
+				logger.Warn("Failed to build PROXY header", "Error", err.Error(), "From", from.String())
+				upstream.Close()
+				continue
+			}
+		}
+		pc, err := ps.AddConnection(up)
 		if err != nil {
 			logger.Debug("Failed to add new connection", "Error", err.Error(), "ServerAddress", sAddr.String(), "From", from.String())
-			id = -1
+			upstream.Close()
 			continue
 		}
-		id = pc.GetId()
-		logger.Debug("Added new connection", "ServerAddress", sAddr.String(), "From", from.String(), "Id", id)
+		key := from.String()
+		sessionsLock.Lock()
+		sessions[key] = up
+		sessionsLock.Unlock()
+		context.AfterFunc(up.ctx, func() {
+			sessionsLock.Lock()
+			defer sessionsLock.Unlock()
+			// A new session for the client may have replaced it
+			if sessions[key] == up {
+				logger.Debug("Removing dead session", "Client", key)
+				delete(sessions, key)
+			}
+		})
+		logger.Debug("Added new connection", "ServerAddress", sAddr.String(), "From", from.String(), "Id", pc.GetId())
 	}
 }
@@ -0,0 +1,334 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"net"
+	"testing"
+	"time"
+)
+
+// Starts a UDP server that replies to every datagram with the address it came from
+func startUdpSourceServer(t *testing.T) net.Addr {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			_, from, err := c.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			c.WriteTo([]byte(from.String()), from)
+		}
+	}()
+	return c.LocalAddr()
+}
+
+func dialUdp(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Sends data on c & reads the reply
+func udpExchange(t *testing.T, c net.Conn, data string) string {
+	t.Helper()
+	c.Write([]byte(data))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 65535)
+	n, err := c.Read(buffer)
+	if err != nil {
+		t.Fatalf("Failed to read reply for %s: %v", data, err)
+	}
+	return string(buffer[:n])
+}
+
+// Creates a initialized UdpProxy from a new client to server, returns the proxy & the clients connection
+func createTestUdpProxy(t *testing.T, server net.Addr, setup func(u *UdpProxy)) (*UdpProxy, net.PacketConn) {
+	t.Helper()
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { relay.Close() })
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	upstream, err := dialDatagram(server)
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	u := newUdpProxy(client.LocalAddr(), relay, server, upstream, pooledCopy([]byte("first")))
+	if setup != nil {
+		setup(u)
+	}
+	if _, err := newTestAdder(t).AddConnection(u); err != nil {
+		t.Fatalf("Failed to add proxy: %v", err)
+	}
+	return u, client
+}
+
+// UdpListener, Ensure each client address gets its own session & upstream socket
+//
+// Expect: Datagrams from one client share a session & reach the server from the same address, other clients get another
+func TestUdpListenerDemux(t *testing.T) {
+	server := startUdpSourceServer(t)
+	ps, pAddr := startSpawner(t, "udp", server, UdpListener)
+	first, second := dialUdp(t, pAddr.String()), dialUdp(t, pAddr.String())
+	firstSource := udpExchange(t, first, "1")
+	if again := udpExchange(t, first, "2"); again != firstSource {
+		t.Errorf("Expected the same upstream for one client, got %s & %s", firstSource, again)
+	}
+	secondSource := udpExchange(t, second, "3")
+	if secondSource == firstSource {
+		t.Errorf("Expected a upstream for each client, both got %s", firstSource)
+	}
+	if proxies := ps.GetAllProxies(); len(proxies) != 2 {
+		t.Errorf("Expected 2 sessions, got %d", len(proxies))
+	}
+}
+
+// UdpListener, Ensure a closed session is removed & its client gets a new one
+//
+// Expect: The next datagram from the client makes a new session on a new upstream socket
+func TestUdpListenerClosedSession(t *testing.T) {
+	server := startUdpSourceServer(t)
+	ps, pAddr := startSpawner(t, "udp", server, UdpListener)
+	client := dialUdp(t, pAddr.String())
+	firstSource := udpExchange(t, client, "1")
+	proxies := ps.GetAllProxies()
+	if len(proxies) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(proxies))
+	}
+	proxies[0].Cancel(handler.ErrProxyClosedOk)
+	if again := udpExchange(t, client, "2"); again == firstSource {
+		t.Errorf("Expected a new upstream for the new session, both got %s", firstSource)
+	}
+	alive := 0
+	for _, v := range ps.GetAllProxies() {
+		if v.IsAlive() {
+			alive++
+			if v.GetId() == proxies[0].GetId() {
+				t.Errorf("Closed session %d is still alive", v.GetId())
+			}
+		}
+	}
+	if alive != 1 {
+		t.Errorf("Expected 1 live session, got %d", alive)
+	}
+}
+
+// UdpProxy idle timeout, Ensure sessions close once they have no traffic for idleTimeout
+//
+// Expect: Traffic keeps the session alive, it's closed with ErrProxyClosedOk once it stops
+func TestUdpProxyIdle(t *testing.T) {
+	server := startTagServer(t, "udp", "127.0.0.1:0", "")
+	u, _ := createTestUdpProxy(t, server, func(u *UdpProxy) {
+		u.idleTimeout = time.Millisecond * 200
+	})
+	for range 6 {
+		time.Sleep(time.Millisecond * 100)
+		if !u.isAlive() {
+			t.Fatalf("Session closed while it had traffic")
+		}
+		u.queue(u.clientPkts, pooledCopy([]byte("keep")))
+	}
+	select {
+	case <-u.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Idle session wasn't closed")
+	}
+	if cause := context.Cause(u.ctx); !errors.Is(cause, handler.ErrProxyClosedOk) {
+		t.Errorf("Expected ErrProxyClosedOk, got %v", cause)
+	}
+}
+
+// UdpProxy, Ensure a server that isn't listening doesn't close the session
+//
+// Expect: The session stays alive after the server refuses a datagram & gets replies once the server listens
+func TestUdpProxyServerRefused(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := c.LocalAddr()
+	c.Close()
+	// The first datagram is refused
+	u, client := createTestUdpProxy(t, server, nil)
+	time.Sleep(time.Millisecond * 200)
+	if !u.isAlive() {
+		t.Fatalf("Session closed after the server refused a datagram: %v", context.Cause(u.ctx))
+	}
+	startTagServer(t, "udp", server.String(), "s:")
+	u.queue(u.clientPkts, pooledCopy([]byte("again")))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 64)
+	n, _, err := client.ReadFrom(buffer)
+	if err != nil || string(buffer[:n]) != "s:again" {
+		t.Errorf("Expected s:again, got %q %v", buffer[:n], err)
+	}
+}
+
+// UdpProxy.ChangeServer, Ensure the headers are rebuilt for the new server
+//
+// Expect: Replies to the client have the new servers SOCKS header, datagrams reach the new server
+func TestUdpProxyChangeServerHeaders(t *testing.T) {
+	oldServer := startTagServer(t, "udp", "127.0.0.1:0", "old:")
+	newServer := startTagServer(t, "udp", "127.0.0.1:0", "new:")
+	u, client := createTestUdpProxy(t, oldServer, func(u *UdpProxy) {
+		u.headers = func(server net.Addr) ([]byte, []byte, error) {
+			return socksAddrBytes(server), nil, nil
+		}
+		u.clientHeader, u.serverHeader, _ = u.buildHeaders(u.server)
+	})
+	read := func() []byte {
+		t.Helper()
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		buffer := make([]byte, 1024)
+		n, _, err := client.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Failed to read reply: %v", err)
+		}
+		return buffer[:n]
+	}
+	if got, expect := read(), append(socksAddrBytes(oldServer), "old:first"...); !bytes.Equal(got, expect) {
+		t.Fatalf("Expected %q, got %q", expect, got)
+	}
+	if err := u.ChangeServer(newServer); err != nil {
+		t.Fatalf("Failed to change server: %v", err)
+	}
+	u.queue(u.clientPkts, pooledCopy([]byte("second")))
+	if got, expect := read(), append(socksAddrBytes(newServer), "new:second"...); !bytes.Equal(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+// UdpListener with SendProxyHeader, Ensure every datagram has the PROXY v2 header
+//
+// Expect: Each datagram reads as a header with the client address followed by the payload
+func TestUdpListenerProxyHeader(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+	_, pAddr := startSpawner(t, "udp", server.LocalAddr(), NewUdpListener(ListenerOptions{SendProxyHeader: ProxyProtocolV2}))
+	client := dialUdp(t, pAddr.String())
+	buffer := make([]byte, 1024)
+	for _, data := range []string{"first", "second"} {
+		client.Write([]byte(data))
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", data, err)
+		}
+		c, err := acceptProxyHeader(connWithData(t, append([]byte{}, buffer[:n]...)))
+		if err != nil {
+			t.Fatalf("Expected a header before %s: %v", data, err)
+		}
+		if c.RemoteAddr().String() != client.LocalAddr().String() {
+			t.Errorf("Expected the client address %v, got %v", client.LocalAddr(), c.RemoteAddr())
+		}
+		if payload, _ := readWithin(c, len(data), time.Second); string(payload) != data {
+			t.Errorf("Expected %s after the header, got %q", data, payload)
+		}
+	}
+}
+
+// Counts the spawners proxies on network
+func countProxies(ps *handler.ProxySpawner, network string) int {
+	count := 0
+	for _, v := range ps.GetAllProxies() {
+		if v.Network() == network {
+			count++
+		}
+	}
+	return count
+}
+
+// StopListener & StartListener, Ensure stopping the UDP listener closes its sessions & TCP proxies keep going
+//
+// Expect: UDP sessions are closed with their listener, TCP proxies still forward, both listeners accept again once restarted
+func TestStopListenerSessions(t *testing.T) {
+	tcpServer := startTagServer(t, "tcp", "127.0.0.1:0", "tcp:")
+	// Same port, so the UDP listener uses it as the server too
+	startTagServer(t, "udp", tcpServer.String(), "udp:")
+	pAddr := freeAddr(t)
+	ps, err := handler.NewProxySpawnerWithListeners(tcpServer, pAddr, context.Background(),
+		handler.NamedListener{Name: "tcp", Listener: TcpListener},
+		handler.NamedListener{Name: "udp", Listener: UdpListener},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create spawner: %v", err)
+	}
+	defer ps.Close()
+	waitBound(t, "tcp", pAddr.String())
+	waitBound(t, "udp", pAddr.String())
+	tcpClient, err := net.Dial("tcp", pAddr.String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer tcpClient.Close()
+	exchange := func(data string) {
+		t.Helper()
+		tcpClient.Write([]byte(data))
+		if got, err := readWithin(tcpClient, len(data)+4, time.Second); err != nil || string(got) != "tcp:"+data {
+			t.Fatalf("Expected tcp:%s, got %q %v", data, got, err)
+		}
+	}
+	exchange("1")
+	if got := udpExchange(t, dialUdp(t, pAddr.String()), "1"); got != "udp:1" {
+		t.Fatalf("Expected udp:1, got %q", got)
+	}
+	for _, name := range []string{"udp", "tcp"} {
+		if err := ps.StopListener(name); err != nil {
+			t.Fatalf("Failed to stop %s: %v", name, err)
+		}
+	}
+	for end := time.Now().Add(time.Second * 2); countProxies(ps, "udp") != 0 && time.Now().Before(end); {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if count := countProxies(ps, "udp"); count != 0 {
+		t.Errorf("Expected the UDP sessions to be closed, got %d", count)
+	}
+	if count := countProxies(ps, "tcp"); count != 1 {
+		t.Errorf("Expected the TCP proxy to keep going, got %d", count)
+	}
+	exchange("2")
+	if c, err := net.Dial("tcp", pAddr.String()); err == nil {
+		c.Close()
+		t.Errorf("Connected to a stopped listener")
+	}
+	for _, name := range []string{"udp", "tcp"} {
+		if err := ps.StartListener(name); err != nil {
+			t.Fatalf("Failed to start %s: %v", name, err)
+		}
+	}
+	waitBound(t, "tcp", pAddr.String())
+	waitBound(t, "udp", pAddr.String())
+	again, err := net.Dial("tcp", pAddr.String())
+	if err != nil {
+		t.Fatalf("Failed to dial restarted listener: %v", err)
+	}
+	defer again.Close()
+	again.Write([]byte("3"))
+	if got, err := readWithin(again, 5, time.Second); err != nil || string(got) != "tcp:3" {
+		t.Errorf("Expected tcp:3, got %q %v", got, err)
+	}
+	if got := udpExchange(t, dialUdp(t, pAddr.String()), "4"); got != "udp:4" {
+		t.Errorf("Expected udp:4, got %q", got)
+	}
+}
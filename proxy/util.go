@@ -1,6 +1,81 @@
 package proxy
 
-import "net"
+import (
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+var unixgramSeq atomic.Uint64 // Used to name the sockets dialDatagram binds
+
+// Buffers for packet data, containers put them back once the packets are handled
+var packetBuffers = handler.NewBufferPool()
+
+const (
+	DefaultReadSize        int = 4096  // Largest stream read if ListenerOptions doesn't set one, every listener but the TCP listener uses it
+	defaultMaxDatagramSize int = 65535 // Largest datagram if ListenerOptions doesn't set one
+)
+
+// Dials a stream connection to addr
+func dialStream(addr net.Addr) (net.Conn, error) {
+	return net.Dial(addr.Network(), addr.String())
+}
+
+// Most resolved addresses serverAddrCache keeps, it's emptied when it has more
+const maxCachedServerAddrs int = 64
+
+// Resolves the spawners server addresses, again only once SetServerAddr or SetUpstreams changes them
+type serverAddrCache struct {
+	ps      handler.IConnectionAdder
+	resolve func(addr net.Addr) (net.Addr, error) // resolveStreamAddr or resolveDatagramAddr
+	lock    sync.Mutex
+	addrs   map[net.Addr]net.Addr // Resolved addresses by the spawner address they were resolved from
+}
+
+// Gets the resolved server address
+func (s *serverAddrCache) get() (net.Addr, error) {
+	return s.lookup(s.ps.GetServerAddr())
+}
+
+// Gets the resolved server address for a new proxy from client, picked from the upstreams if the spawner has them.
+// upstream is the address the spawner gave before it was resolved, the spawner counts proxies on each upstream by it.
+func (s *serverAddrCache) pick(client net.Addr) (addr net.Addr, upstream net.Addr, err error) {
+	upstream = s.ps.GetServerAddr()
+	if picker, ok := s.ps.(handler.IUpstreamPicker); ok {
+		upstream = picker.PickServerAddr(client)
+	}
+	addr, err = s.lookup(upstream)
+	return addr, upstream, err
+}
+
+func (s *serverAddrCache) lookup(from net.Addr) (net.Addr, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if addr, ok := s.addrs[from]; ok {
+		return addr, nil
+	}
+	addr, err := s.resolve(from)
+	if err != nil {
+		return nil, err
+	}
+	if s.addrs == nil || len(s.addrs) >= maxCachedServerAddrs {
+		s.addrs = make(map[net.Addr]net.Addr)
+	}
+	s.addrs[from] = addr
+	return addr, nil
+}
+
+// Copies data into a pooled buffer, so the buffer it was read into can be reused
+func pooledCopy(data []byte) []byte {
+	buf := packetBuffers.Get(len(data))
+	copy(buf, data)
+	return buf
+}
 
 // Check if this is a timeout error
 func isTimeoutError(err error) bool {
@@ -17,3 +92,159 @@ func isTimeoutError(err error) bool {
 func compareNetAddr(left net.Addr, right net.Addr) bool {
 	return left.Network() == right.Network() && left.String() == right.String()
 }
+
+// Resolves addr for a stream listener or connection, "unix" addresses are kept as is and anything else is TCP.
+func resolveStreamAddr(addr net.Addr) (net.Addr, error) {
+	switch addr.Network() {
+	case "unix":
+		return net.ResolveUnixAddr("unix", addr.String())
+	case "unixgram", "unixpacket":
+		return nil, fmt.Errorf("%s address can't be used for streams", addr.Network())
+	default:
+		return net.ResolveTCPAddr("tcp", addr.String())
+	}
+}
+
+// Resolves addr for a datagram listener or connection, "unixgram" addresses are kept as is and anything else is UDP.
+func resolveDatagramAddr(addr net.Addr) (net.Addr, error) {
+	switch addr.Network() {
+	case "unixgram":
+		return net.ResolveUnixAddr("unixgram", addr.String())
+	case "unix", "unixpacket":
+		return nil, fmt.Errorf("%s address can't be used for datagrams", addr.Network())
+	default:
+		return net.ResolveUDPAddr("udp", addr.String())
+	}
+}
+
+// Copy of a TCP or UDP address with its port moved by offset
+func shiftPort(addr net.Addr, offset int) (net.Addr, error) {
+	if offset == 0 {
+		return addr, nil
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a.Port+offset < 1 || a.Port+offset > 65535 {
+			return nil, fmt.Errorf("port %d is out of range", a.Port+offset)
+		}
+		return &net.TCPAddr{IP: a.IP, Port: a.Port + offset, Zone: a.Zone}, nil
+	case *net.UDPAddr:
+		if a.Port+offset < 1 || a.Port+offset > 65535 {
+			return nil, fmt.Errorf("port %d is out of range", a.Port+offset)
+		}
+		return &net.UDPAddr{IP: a.IP, Port: a.Port + offset, Zone: a.Zone}, nil
+	default:
+		return nil, fmt.Errorf("%s addresses don't have ports", addr.Network())
+	}
+}
+
+// Gets the addresses a listener binds, every port from the port of pAddr to lastPort or only pAddr if lastPort is 0.
+// server is checked so every port can be forwarded to it.
+func portRangeAddrs(pAddr net.Addr, server net.Addr, lastPort int) ([]net.Addr, error) {
+	if lastPort == 0 {
+		return []net.Addr{pAddr}, nil
+	}
+	var first int
+	switch a := pAddr.(type) {
+	case *net.TCPAddr:
+		first = a.Port
+	case *net.UDPAddr:
+		first = a.Port
+	default:
+		return nil, fmt.Errorf("port ranges need an IP proxy address, got a %s address", pAddr.Network())
+	}
+	if first == 0 {
+		return nil, errors.New("port ranges need a proxy port")
+	}
+	if lastPort < first || lastPort > 65535 {
+		return nil, fmt.Errorf("invalid last port %d, must be from %d to 65535", lastPort, first)
+	}
+	if _, err := shiftPort(server, lastPort-first); err != nil {
+		return nil, fmt.Errorf("can't forward port %d to the server: %v", lastPort, err)
+	}
+	addrs := make([]net.Addr, 0, lastPort-first+1)
+	for port := first; port <= lastPort; port++ {
+		// Checked above
+		addr, _ := shiftPort(pAddr, port-first)
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// Removes a unix socket left behind at addr so it can be listened on again. Anything that isn't a socket is left alone.
+func removeStaleSocket(addr net.Addr) {
+	if _, ok := addr.(*net.UnixAddr); !ok {
+		return
+	}
+	info, err := os.Lstat(addr.String())
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(addr.String())
+	}
+}
+
+// Unixgram connection bound to a temporary path so the server can reply, the path is removed when it's closed.
+type unixgramConn struct {
+	*net.UnixConn
+	path string
+}
+
+func (u *unixgramConn) Close() error {
+	err := u.UnixConn.Close()
+	os.Remove(u.path)
+	return err
+}
+
+// Opens a datagram connection to addr, which must come from resolveDatagramAddr
+func dialDatagram(addr net.Addr) (net.Conn, error) {
+	uAddr, ok := addr.(*net.UnixAddr)
+	if !ok {
+		return net.Dial("udp", addr.String())
+	}
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("ezproxy-%d-%d.sock", os.Getpid(), unixgramSeq.Add(1)))
+	c, err := net.DialUnix("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"}, uAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &unixgramConn{UnixConn: c, path: path}, nil
+}
+
+// Closes the write side of c, looking through the wrappers in this package. Errors if c can't be half closed.
+func closeWrite(c net.Conn) error {
+	switch v := c.(type) {
+	case *prefixConn:
+		return closeWrite(v.Conn)
+	case *proxiedConn:
+		return closeWrite(v.Conn)
+	case interface{ CloseWrite() error }:
+		return v.CloseWrite()
+	default:
+		return fmt.Errorf("%T can't be half closed", c)
+	}
+}
+
+// Connection that returns prefix before reading from the underlying connection, used to replay bytes that were read
+// before the connection was handed to a proxy.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (p *prefixConn) Read(b []byte) (int, error) {
+	if len(p.prefix) != 0 {
+		n := copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		return n, nil
+	}
+	return p.Conn.Read(b)
+}
+
+// Wraps c so prefix is read first, if prefix is empty c is returned.
+func newPrefixConn(c net.Conn, prefix []byte) net.Conn {
+	if len(prefix) == 0 {
+		return c
+	}
+	return &prefixConn{
+		Conn:   c,
+		prefix: prefix,
+	}
+}
@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shiftPort, Ensure ports are moved & the rest of the address is kept
+//
+// Expect: TCP & UDP addresses keep their IP & zone, ports outside 1-65535 & unix addresses fail
+func TestShiftPort(t *testing.T) {
+	tcp := &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1000, Zone: "eth0"}
+	got, err := shiftPort(tcp, 5)
+	if err != nil || got.String() != "[fe80::1%eth0]:1005" {
+		t.Errorf("Expected [fe80::1%%eth0]:1005 got %v %v", got, err)
+	}
+	udp := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	got, err = shiftPort(udp, -999)
+	if _, ok := got.(*net.UDPAddr); err != nil || !ok || got.String() != "127.0.0.1:1" {
+		t.Errorf("Expected UDP 127.0.0.1:1 got %v %v", got, err)
+	}
+	if got, _ := shiftPort(tcp, 0); got != tcp {
+		t.Errorf("Expected a offset of 0 to keep the address")
+	}
+	for name, v := range map[string]struct {
+		addr   net.Addr
+		offset int
+	}{
+		"below 1":      {udp, -1000},
+		"above 65535":  {tcp, 64536},
+		"unix address": {&net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unix"}, 1},
+	} {
+		if got, err := shiftPort(v.addr, v.offset); err == nil {
+			t.Errorf("%s: Expected a error, got %v", name, got)
+		}
+	}
+}
+
+// portRangeAddrs, Ensure every port in the range is listed
+//
+// Expect: One address per port with the proxy IP, only the proxy address without a range
+func TestPortRangeAddrs(t *testing.T) {
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 28000}
+	pAddr := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 27000}
+	addrs, err := portRangeAddrs(pAddr, server, 27002)
+	if err != nil {
+		t.Fatalf("Failed to get port range: %v", err)
+	}
+	expect := []string{"[::1]:27000", "[::1]:27001", "[::1]:27002"}
+	if len(addrs) != len(expect) {
+		t.Fatalf("Expected %v got %v", expect, addrs)
+	}
+	for k, v := range addrs {
+		if v.String() != expect[k] || v.Network() != "tcp" {
+			t.Errorf("Expected %s got %s %s", expect[k], v.Network(), v)
+		}
+	}
+	udp := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 27000}
+	addrs, err = portRangeAddrs(udp, server, 27001)
+	if err != nil || len(addrs) != 2 || addrs[1].Network() != "udp" || addrs[1].String() != "127.0.0.1:27001" {
+		t.Errorf("Expected 2 UDP addresses got %v %v", addrs, err)
+	}
+	unix := &net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unix"}
+	addrs, err = portRangeAddrs(unix, server, 0)
+	if err != nil || len(addrs) != 1 || addrs[0] != unix {
+		t.Errorf("Expected only the proxy address without a range, got %v %v", addrs, err)
+	}
+}
+
+// portRangeAddrs, Ensure invalid ranges are rejected
+//
+// Expect: A error for each range
+func TestPortRangeAddrsInvalid(t *testing.T) {
+	pAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 27000}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 27000}
+	for name, v := range map[string]struct {
+		pAddr    net.Addr
+		server   net.Addr
+		lastPort int
+	}{
+		"reversed":           {pAddr, server, 26999},
+		"above 65535":        {pAddr, server, 65536},
+		"server too short":   {pAddr, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 65530}, 27010},
+		"no proxy port":      {&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, server, 27010},
+		"unix proxy":         {&net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unix"}, server, 27010},
+		"unixgram server":    {pAddr, &net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unixgram"}, 27010},
+		"unix proxy & range": {&net.UnixAddr{Name: "/tmp/ezp.sock", Net: "unixgram"}, server, 1},
+	} {
+		if addrs, err := portRangeAddrs(v.pAddr, v.server, v.lastPort); err == nil {
+			t.Errorf("%s: Expected a error, got %v", name, addrs)
+		}
+	}
+}
+
+// acceptTcpLoop, Ensure a listener that fails to accept stops the loop
+//
+// Expect: The loop returns & the context is cancelled with the accept error
+func TestAcceptTcpLoopClosedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	l.Close()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	done := make(chan struct{})
+	go func() {
+		acceptTcpLoop(ctx, cancel, nil, l.(deadlineListener), nil, 0, func(c net.Conn, sAddr net.Addr, upstream net.Addr) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Accept loop didn't return on a closed listener")
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Expected the context to be cancelled")
+	}
+}
+
+// IConnectionAdder that runs the proxies it's given without a spawner, packets are forwarded as they are.
+// It's a IObservable that is observed once setObserved is called.
+type testAdder struct {
+	ctx       context.Context
+	lock      sync.Mutex
+	proxies   []handler.IProxy
+	observed  bool          // Guarded by lock
+	changed   chan struct{} // Guarded by lock
+	spliced   atomic.Uint64 // Bytes proxies spliced
+	forwarded atomic.Uint64 // Bytes forwarded as packets
+	putBack   bool          // Put forwarded packets back in their pool like the container does, set before AddConnection
+}
+
+func newTestAdder(t testing.TB) *testAdder {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &testAdder{ctx: ctx, changed: make(chan struct{})}
+}
+
+func (a *testAdder) IsObserved() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.observed
+}
+
+func (a *testAdder) ObservedChanged() <-chan struct{} {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.changed
+}
+
+func (a *testAdder) setObserved(observed bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.observed = observed
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *testAdder) GetProxy(id int) (handler.IProxyContainer, error) {
+	return nil, errors.New("proxy not found")
+}
+
+func (a *testAdder) GetProxyAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+func (a *testAdder) GetServerAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+}
+
+func (a *testAdder) AddConnection(px handler.IProxy) (handler.IProxyContainer, error) {
+	pktChan := make(chan handler.ProxyPacketData)
+	ctx, cancel := context.WithCancelCause(a.ctx)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt := <-pktChan:
+				switch {
+				case pkt.Spliced:
+					a.spliced.Add(pkt.SplicedBytes)
+				case pkt.Eof:
+					if hc, ok := px.(handler.IHalfCloser); ok {
+						if pkt.Serverbound {
+							hc.CloseServerWrite()
+						} else {
+							hc.CloseClientWrite()
+						}
+					}
+				case pkt.Serverbound:
+					a.forwarded.Add(uint64(len(pkt.Data)))
+					px.SendToServer(pkt.Data)
+				default:
+					a.forwarded.Add(uint64(len(pkt.Data)))
+					px.SendToClient(pkt.Data)
+				}
+				if a.putBack && pkt.Pool != nil {
+					pkt.Pool.Put(pkt.Data)
+				}
+			}
+		}
+	}()
+	if err := px.Init(pktChan, ctx, cancel); err != nil {
+		return nil, err
+	}
+	a.lock.Lock()
+	a.proxies = append(a.proxies, px)
+	a.lock.Unlock()
+	return nil, nil
+}
+
+// Waits for count proxies to be added
+func (a *testAdder) waitProxies(t *testing.T, count int) []handler.IProxy {
+	t.Helper()
+	for end := time.Now().Add(time.Second * 2); time.Now().Before(end); time.Sleep(time.Millisecond * 10) {
+		a.lock.Lock()
+		proxies := append([]handler.IProxy(nil), a.proxies...)
+		a.lock.Unlock()
+		if len(proxies) >= count {
+			return proxies
+		}
+	}
+	t.Fatalf("Expected %d proxies to be added", count)
+	return nil
+}
+
+// Gets both ends of a loopback TCP connection
+func tcpPair(t testing.TB) (client net.Conn, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// Starts a server that replies to everything with tag & what it got, network is "tcp" or "udp"
+func startTagServer(t *testing.T, network string, addr string, tag string) net.Addr {
+	t.Helper()
+	if network == "udp" {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		go func() {
+			buffer := make([]byte, 65535)
+			for {
+				n, from, err := c.ReadFrom(buffer)
+				if err != nil {
+					return
+				}
+				c.WriteTo(append([]byte(tag), buffer[:n]...), from)
+			}
+		}()
+		return c.LocalAddr()
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buffer := make([]byte, 65535)
+				for {
+					n, err := c.Read(buffer)
+					if err != nil {
+						return
+					}
+					c.Write(append([]byte(tag), buffer[:n]...))
+				}
+			}()
+		}
+	}()
+	return l.Addr()
+}
+
+// Gets a loopback address with a port that's free for both TCP & UDP
+func freeAddr(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	for range 20 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		addr := l.Addr().(*net.TCPAddr)
+		u, err := net.ListenPacket("udp", addr.String())
+		l.Close()
+		if err == nil {
+			u.Close()
+			return addr
+		}
+	}
+	t.Fatalf("Failed to find a free port")
+	return nil
+}
+
+// Starts a spawner on a free port with listeners, waits until network ("tcp" or "udp") is bound
+func startSpawner(t *testing.T, network string, server net.Addr, listeners ...handler.IProxyListener) (*handler.ProxySpawner, *net.TCPAddr) {
+	t.Helper()
+	pAddr := freeAddr(t)
+	ps, err := handler.NewProxySpawner(server, pAddr, context.Background(), listeners...)
+	if err != nil {
+		t.Fatalf("Failed to create spawner: %v", err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	waitBound(t, network, pAddr.String())
+	return ps, pAddr
+}
+
+// Waits for something to be listening on addr
+func waitBound(t *testing.T, network string, addr string) {
+	t.Helper()
+	for end := time.Now().Add(time.Second * 2); time.Now().Before(end); time.Sleep(time.Millisecond * 10) {
+		var err error
+		if network == "udp" {
+			var c net.PacketConn
+			if c, err = net.ListenPacket("udp", addr); err == nil {
+				c.Close()
+			}
+		} else {
+			var l net.Listener
+			if l, err = net.Listen("tcp", addr); err == nil {
+				l.Close()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	t.Fatalf("Nothing is listening on %s %s", network, addr)
+}
+
+// Reads from c until it has size bytes or the deadline passes
+func readWithin(c net.Conn, size int, timeout time.Duration) ([]byte, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+	buffer := make([]byte, size)
+	n, err := io.ReadFull(c, buffer)
+	return buffer[:n], err
+}
+
+// Gets the metadata of a proxy or container, nil if it isn't a IMetadataProvider
+func getMetadata(px any) map[string]string {
+	if mp, ok := px.(handler.IMetadataProvider); ok {
+		return mp.GetMetadata()
+	}
+	return nil
+}
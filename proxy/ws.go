@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	wsBridgeReadLimit     int64         = 1 << 20          // Largest WebSocket message a client can send
+	wsBridgeHeaderTimeout time.Duration = time.Second * 10 // Time a client has to send the upgrade request
+)
+
+// WebSocket bridge settings for a WsBridgeListener
+type WsBridgeConfig struct {
+	OriginPatterns []string              // Extra origins allowed to connect, see websocket.AcceptOptions
+	MessageType    websocket.MessageType // Type of the messages sent to clients
+}
+
+// WebSocket to TCP proxy, every message from the client is a packet. Data from the server is sent to the client as one message per read.
+type WsProxy struct {
+	ctx         context.Context                // Proxy context
+	ctxCancel   context.CancelCauseFunc        // Cancel context
+	client      *websocket.Conn                // Client connection
+	clientAddr  net.Addr                       // Client address, from the HTTP request
+	server      net.Conn                       // Server connection, guarded by connLock
+	connLock    sync.RWMutex                   // Held while writing to the server, so ChangeServer doesn't swap it mid write
+	messageType websocket.MessageType          // Type of the messages sent to the client
+	pktChan     chan<- handler.ProxyPacketData // Packet channel
+	metadata    map[string]string              // Extra connection info
+	picked      net.Addr                       // Upstream the spawner picked for the proxy, before it was resolved
+	logger      *slog.Logger
+}
+
+// Gets the current server connection
+func (w *WsProxy) getServer() net.Conn {
+	w.connLock.RLock()
+	defer w.connLock.RUnlock()
+	return w.server
+}
+
+// Listen for messages from the client
+func (w *WsProxy) listenClient() {
+	for w.ctx.Err() == nil {
+		// Cancelling ctx closes the WebSocket
+		_, data, err := w.client.Read(w.ctx)
+		if err != nil {
+			if w.ctx.Err() != nil {
+				return
+			}
+			// Terminated
+			status := websocket.CloseStatus(err)
+			if status == websocket.StatusNormalClosure || status == websocket.StatusGoingAway || errors.Is(err, io.EOF) {
+				w.logger.Debug("Connection closed")
+				w.ctxCancel(handler.ErrProxyClosedOk)
+			} else {
+				w.logger.Debug("Closing due to error", "Error", err.Error())
+				w.ctxCancel(fmt.Errorf("failed to read from proxy: %v", err))
+			}
+			return
+		}
+		w.logger.Debug("Sending packet data", "Serverbound", true, "Source", w.clientAddr, "Dest", w.GetServerAddr(), "Data", data)
+		select {
+		case w.pktChan <- handler.ProxyPacketData{
+			Serverbound: true,
+			Source:      w.clientAddr,
+			Dest:        w.GetServerAddr(),
+			Data:        data,
+		}:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// Listen for data from server
+func (w *WsProxy) listenServer(server net.Conn) {
+	for w.ctx.Err() == nil {
+		buffer := packetBuffers.Get(DefaultReadSize)
+		server.SetReadDeadline(time.Now().Add(time.Second * 1))
+		n, err := server.Read(buffer)
+		if err != nil {
+			packetBuffers.Put(buffer)
+			if isTimeoutError(err) {
+				continue
+			}
+			if server != w.getServer() {
+				w.logger.Debug("Stopped listening to old server")
+				return
+			}
+			// Terminated
+			if err == io.EOF {
+				w.logger.Debug("Connection closed")
+				w.ctxCancel(handler.ErrProxyClosedOk)
+			} else {
+				w.logger.Debug("Closing due to error", "Error", err.Error())
+				w.ctxCancel(fmt.Errorf("failed to read from proxy: %v", err))
+			}
+			return
+		}
+		w.logger.Debug("Sending packet data", "Serverbound", false, "Source", server.RemoteAddr(), "Dest", w.clientAddr, "Data", buffer[:n])
+		select {
+		case w.pktChan <- handler.ProxyPacketData{
+			Serverbound: false,
+			Source:      server.RemoteAddr(),
+			Dest:        w.clientAddr,
+			Data:        buffer[:n],
+			Pool:        packetBuffers,
+		}:
+		case <-w.ctx.Done():
+			packetBuffers.Put(buffer)
+			return
+		}
+	}
+}
+
+// Closes both connections once the proxy is done
+func (w *WsProxy) closeOnDone() {
+	<-w.ctx.Done()
+	w.client.Close(websocket.StatusNormalClosure, "")
+	w.getServer().Close()
+}
+
+func (w *WsProxy) Network() string {
+	return "tcp"
+}
+
+func (w *WsProxy) GetClientAddr() net.Addr {
+	return w.clientAddr
+}
+
+func (w *WsProxy) GetServerAddr() net.Addr {
+	return w.getServer().RemoteAddr()
+}
+
+// Connects to addr & bridges the client to it, the WebSocket is kept open
+func (w *WsProxy) ChangeServer(addr net.Addr) error {
+	if w.ctx == nil || w.ctx.Err() != nil {
+		return errors.New("proxy isn't running")
+	}
+	sAddr, err := resolveStreamAddr(addr)
+	if err != nil {
+		return err
+	}
+	s, err := dialStream(sAddr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to new server: %v", err)
+	}
+	w.connLock.Lock()
+	old := w.server
+	w.server = s
+	w.connLock.Unlock()
+	old.Close()
+	w.logger.Debug("Changed server", "Old", old.RemoteAddr().String(), "New", s.RemoteAddr().String())
+	go w.listenServer(s)
+	return nil
+}
+
+func (w *WsProxy) GetUpstream() net.Addr {
+	return w.picked
+}
+
+func (w *WsProxy) GetMetadata() map[string]string {
+	return w.metadata
+}
+
+// Send data to client as a single message
+func (w *WsProxy) SendToClient(data []byte) error {
+	err := w.client.Write(w.ctx, w.messageType, data)
+	if err != nil {
+		w.logger.Debug("Failed to send data to client", "Data", data, "Error", err.Error())
+	} else {
+		w.logger.Debug("Sent data to client", "Data", data)
+	}
+	return err
+}
+
+// Send data to server
+func (w *WsProxy) SendToServer(data []byte) error {
+	w.connLock.RLock()
+	_, err := w.server.Write(data)
+	w.connLock.RUnlock()
+	if err != nil {
+		w.logger.Debug("Failed to send data to server", "Data", data, "Error", err.Error())
+	} else {
+		w.logger.Debug("Sent data to server", "Data", data)
+	}
+	return err
+}
+
+// Initialize the proxy
+func (w *WsProxy) Init(pktChan chan<- handler.ProxyPacketData, ctx context.Context, cancel context.CancelCauseFunc) error {
+	if w.pktChan != nil {
+		w.logger.Error("Already initialized")
+		return errors.New("already initialized")
+	}
+	w.logger.Debug("Initializing")
+	w.pktChan = pktChan
+	w.ctx = ctx
+	w.ctxCancel = cancel
+	go w.listenClient()
+	go w.listenServer(w.server)
+	go w.closeOnDone()
+	return nil
+}
+
+// Create a new WsProxy
+func newWsProxy(client *websocket.Conn, clientAddr net.Addr, server net.Conn, messageType websocket.MessageType) *WsProxy {
+	return &WsProxy{
+		client:      client,
+		clientAddr:  clientAddr,
+		server:      server,
+		messageType: messageType,
+		logger:      slog.Default(),
+	}
+}
+
+// Upgrades a request to a WebSocket, connects to the server & adds the proxy.
+func addWsConnection(ps handler.IConnectionAdder, cfg *WsBridgeConfig, server *serverAddrCache, w http.ResponseWriter, r *http.Request) {
+	logger := slog.Default()
+	// Listening on a unix socket if it isn't a TCP address
+	var clientAddr net.Addr = &net.UnixAddr{Name: r.RemoteAddr, Net: "unix"}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		clientAddr = addr
+	}
+	sAddr, picked, err := server.pick(clientAddr)
+	if err != nil {
+		logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
+		http.Error(w, "failed to connect to server", http.StatusBadGateway)
+		return
+	}
+	s, err := net.Dial(sAddr.Network(), sAddr.String())
+	if err != nil {
+		logger.Warn("Failed to create new connection to server", "Error", err.Error(), "ServerAddress", sAddr.String())
+		http.Error(w, "failed to connect to server", http.StatusBadGateway)
+		return
+	}
+	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: cfg.OriginPatterns})
+	if err != nil {
+		// Accept already wrote the response
+		logger.Debug("Failed to accept websocket request", "Error", err.Error(), "ClientAddr", r.RemoteAddr)
+		s.Close()
+		return
+	}
+	ws.SetReadLimit(wsBridgeReadLimit)
+	px := newWsProxy(ws, clientAddr, s, cfg.MessageType)
+	px.picked = picked
+	px.metadata = map[string]string{
+		"WebSocket": r.URL.Path,
+	}
+	logger.Debug("Adding new proxy", "ClientAddr", r.RemoteAddr, "ServerAddr", s.RemoteAddr().String())
+	if _, err := ps.AddConnection(px); err != nil {
+		logger.Debug("Failed to add new connection", "Error", err.Error(), "ClientAddr", r.RemoteAddr)
+		ws.Close(websocket.StatusInternalError, "failed to add connection")
+		s.Close()
+	}
+}
+
+// Creates a listener that accepts WebSocket connections on any path and bridges each one to a TCP connection to the server.
+// Message boundaries from the client are kept, every message is one packet.
+func NewWsBridgeListener(cfg WsBridgeConfig) handler.IProxyListener {
+	if cfg.MessageType == 0 {
+		cfg.MessageType = websocket.MessageBinary
+	}
+	return func(ctx context.Context, cancel context.CancelCauseFunc, ps handler.IConnectionAdder) {
+		logger := slog.Default()
+		pAddr, err := resolveStreamAddr(ps.GetProxyAddr())
+		if err != nil {
+			logger.Warn("Failed to resolve ProxyAddr", "ProxyAddr", ps.GetProxyAddr().String(), "Error", err.Error())
+			cancel(fmt.Errorf("failed to resolve proxy addr: %v", err))
+			return
+		}
+		server := &serverAddrCache{ps: ps, resolve: resolveStreamAddr}
+		if _, err := server.get(); err != nil {
+			logger.Warn("Failed to resolve ServerAddr", "ServerAddr", ps.GetServerAddr().String(), "Error", err.Error())
+			cancel(fmt.Errorf("failed to resolve server addr: %v", err))
+			return
+		}
+		removeStaleSocket(pAddr)
+		l, err := net.Listen(pAddr.Network(), pAddr.String())
+		if err != nil {
+			logger.Warn("Failed to listen on proxy", "Error", err.Error(), "ProxyAddress", pAddr.String())
+			cancel(fmt.Errorf("failed to listen on proxy: %v", err))
+			return
+		}
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				addWsConnection(ps, &cfg, server, w, r)
+			}),
+			ReadHeaderTimeout: wsBridgeHeaderTimeout,
+		}
+		go func() {
+			<-ctx.Done()
+			// Bridged connections are hijacked, closing the server doesn't touch them
+			srv.Close()
+		}()
+		logger.Debug("Listener started")
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("WebSocket listener failed", "Error", err.Error())
+			cancel(fmt.Errorf("failed to serve websocket listener: %v", err))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"ezproxy/handler"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// NewWsBridgeListener, Ensure WebSocket messages are bridged to the TCP server
+//
+// Expect: A message reaches the server & its reply is a message of the configured type, the path is in the metadata
+func TestWsBridgeListener(t *testing.T) {
+	server := startTagServer(t, "tcp", "127.0.0.1:0", "ws:")
+	ps, pAddr := startSpawner(t, "tcp", server, NewWsBridgeListener(WsBridgeConfig{MessageType: websocket.MessageText}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, "ws://"+pAddr.String()+"/bridge", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.CloseNow()
+	if err := c.Write(ctx, websocket.MessageBinary, []byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	typ, data, err := c.Read(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if typ != websocket.MessageText || string(data) != "ws:ping" {
+		t.Errorf("Expected a text message ws:ping, got %v %q", typ, data)
+	}
+	if proxies := ps.GetAllProxies(); len(proxies) != 1 || getMetadata(proxies[0])["WebSocket"] != "/bridge" {
+		t.Errorf("Expected a proxy for /bridge")
+	}
+}
+
+// Starts a WsProxy between a WebSocket client & a TCP server, nothing reads its packets.
+// Returns the proxy, the WebSocket client & the servers end of the TCP connection
+func startWsProxy(t *testing.T) (*WsProxy, *websocket.Conn, net.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- c
+		// The connection is closed once the request returns
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hs.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	client, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.CloseNow() })
+	var wsConn *websocket.Conn
+	select {
+	case wsConn = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatalf("WebSocket wasn't accepted")
+	}
+	proxySide, server := tcpPair(t)
+	px := newWsProxy(wsConn, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, proxySide, websocket.MessageBinary)
+	pCtx, pCancel := context.WithCancelCause(context.Background())
+	t.Cleanup(func() { pCancel(handler.ErrProxyClosedOk) })
+	px.Init(make(chan handler.ProxyPacketData), pCtx, pCancel)
+	return px, client, server
+}
+
+// Waits for the WsProxy to close & gets why
+func waitWsClosed(t *testing.T, px *WsProxy) error {
+	t.Helper()
+	select {
+	case <-px.ctx.Done():
+		return context.Cause(px.ctx)
+	case <-time.After(time.Second * 2):
+		t.Fatalf("Proxy wasn't closed")
+	}
+	return nil
+}
+
+// WsProxy, Ensure a normal WebSocket close or a server EOF closes the proxy ok, even while a packet is waiting to be taken
+//
+// Expect: The proxy is closed with ErrProxyClosedOk
+func TestWsProxyClosedOk(t *testing.T) {
+	t.Run("client close", func(t *testing.T) {
+		px, client, server := startWsProxy(t)
+		// Nothing takes the packet, so the server is still sending when the client closes
+		server.Write([]byte("waiting"))
+		go client.Close(websocket.StatusNormalClosure, "")
+		if cause := waitWsClosed(t, px); !errors.Is(cause, handler.ErrProxyClosedOk) {
+			t.Errorf("Expected ErrProxyClosedOk, got %v", cause)
+		}
+	})
+	t.Run("server EOF", func(t *testing.T) {
+		px, client, server := startWsProxy(t)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		client.Write(ctx, websocket.MessageBinary, []byte("waiting"))
+		server.Close()
+		if cause := waitWsClosed(t, px); !errors.Is(cause, handler.ErrProxyClosedOk) {
+			t.Errorf("Expected ErrProxyClosedOk, got %v", cause)
+		}
+	})
+}